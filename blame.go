@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// BlameOptions controls pit blame.
+type BlameOptions struct {
+	Path     string
+	Rev      string
+	Endpoint string
+	Top      int           // number of contributors to show, 0 for all
+	Verbose  bool          // stream analyzer output as it runs
+	Timeout  time.Duration // give up on analysis after this long, 0 for no limit
+	Runtime  string        // JavaScript runtime to analyze with, empty to pick one
+	Daemon   bool          // analyze through the long-lived analyzer daemon
+}
+
+// contributor is one author's share of the code behind an endpoint.
+type contributor struct {
+	Name        string
+	Email       string
+	Lines       int
+	LastTouched time.Time
+}
+
+// ownership aggregates blame across every function reachable from an
+// endpoint. Lines shared by several functions are only counted once.
+type ownership struct {
+	Endpoint     string
+	Functions    int
+	Lines        int
+	LastTouched  time.Time
+	Contributors []contributor // most lines first
+}
+
+func printBlameUsage() {
+	fmt.Println("Usage: pit blame [flags] [path] <endpoint>")
+	fmt.Println("Reports who wrote the code reachable from an endpoint, by share of lines.")
+	fmt.Println("Flags:")
+	fmt.Println("  --rev <rev>  Blame as of this revision (default HEAD)")
+	fmt.Println("  -n <count>   Number of contributors to show (default 10, 0 for all)")
+	fmt.Println("  --verbose    Stream analyzer output and warnings as they arrive")
+	fmt.Println("  --timeout <duration>")
+	fmt.Println("               Give up on analysis after this long (e.g. 5m), exiting with 124")
+	fmt.Println("  --runtime <runtime>")
+	fmt.Println("               Run the analyzer with bun, node or deno")
+	fmt.Println("  --daemon     Analyze through the analyzer daemon, starting it if needed")
+	fmt.Println("Examples:")
+	fmt.Println("  pit blame 'GET /users/:id'")
+	fmt.Println("  pit blame --rev v2.0 /path/to/repo 'POST /payments'")
+}
+
+func validateBlameArgs(args []string) BlameOptions {
+	opts := BlameOptions{}
+
+	flags := flag.NewFlagSet("blame", flag.ExitOnError)
+	flags.StringVar(&opts.Rev, "rev", "HEAD", "blame as of this revision")
+	flags.IntVar(&opts.Top, "n", 10, "number of contributors to show")
+	flags.BoolVar(&opts.Verbose, "verbose", false, "stream analyzer output as it runs")
+	flags.DurationVar(&opts.Timeout, "timeout", 0, "give up on analysis after this long")
+	flags.StringVar(&opts.Runtime, "runtime", "", "JavaScript runtime to analyze with (bun, node or deno)")
+	flags.BoolVar(&opts.Daemon, "daemon", false, "analyze through the analyzer daemon")
+	flags.Usage = printBlameUsage
+	flags.Parse(args)
+	args = flags.Args()
+
+	switch len(args) {
+	case 1:
+		opts.Path = "."
+		opts.Endpoint = args[0]
+	case 2:
+		opts.Path = args[0]
+		opts.Endpoint = args[1]
+	default:
+		printBlameUsage()
+		os.Exit(1)
+	}
+	return opts
+}
+
+func runBlame(args []string) {
+	opts := validateBlameArgs(args)
+	gitRoot := setupGitRoot(opts.Path)
+
+	r, err := git.PlainOpen(gitRoot)
+	if err != nil {
+		fmt.Printf("Error opening repository: %v\n", err)
+		os.Exit(1)
+	}
+	commit, err := resolveGitRef(r, opts.Rev)
+	if err != nil {
+		fmt.Printf("Error resolving '%s': %v\n", opts.Rev, err)
+		os.Exit(1)
+	}
+
+	rt := setupRuntime(opts.Runtime, gitRoot)
+	pipeName := setupPipe()
+	ctx, cancel := setupSignalHandler(opts.Timeout)
+	defer cancel()
+	defer runCleanups()
+	daemon := setupDaemon(daemonEnabled(opts.Daemon, gitRoot), rt)
+
+	// Blame works on commits, so analyze the committed tree rather than
+	// the working tree to keep line numbers in step
+	analyzer := newTreeAnalyzer(r, gitRoot, analyzerConfig{pipeName: pipeName, runtime: rt, daemon: daemon, verbose: opts.Verbose})
+	functions, err := analyzer.analyze(ctx, snapshot{commit: commit}, shortHash(commit.Hash))
+	if err != nil {
+		fmt.Printf("Error analyzing %s: %v\n", opts.Rev, err)
+		exit(exitStatus(err))
+	}
+
+	report, err := blameEndpoint(commit, functions, opts.Endpoint)
+	if err != nil {
+		fmt.Printf("Error running blame: %v\n", err)
+		exit(1)
+	}
+	if report.Functions == 0 {
+		fmt.Printf("No endpoint %s found at %s\n", opts.Endpoint, opts.Rev)
+		exit(1)
+	}
+	printOwnership(report, opts.Top)
+	printDiagnostics(analyzer.warnings, opts.Verbose)
+}
+
+// blameEndpoint blames the line ranges of every function belonging to
+// endpoint as of commit. Functions in files outside the commit's tree, such
+// as installed packages reached through a symlinked node_modules, have no
+// history to blame and are left out.
+func blameEndpoint(commit *object.Commit, functions []FunctionRange, endpoint string) (*ownership, error) {
+	report := &ownership{Endpoint: endpoint}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("error getting tree of %s: %w", commit.Hash, err)
+	}
+
+	// Collect the distinct lines of each file first so every file is blamed once
+	want := normalizeEndpoint(endpoint)
+	lines := make(map[string]map[int]bool)
+	// A function reached from several call sites is reported once per site
+	seen := make(map[[2]string]bool)
+	for _, fn := range functions {
+		if normalizeEndpoint(fn.ControllerName) != want {
+			continue
+		}
+		if key := [2]string{fn.Filename, fn.FunctionName}; !seen[key] {
+			seen[key] = true
+			report.Functions++
+		}
+		if lines[fn.Filename] == nil {
+			lines[fn.Filename] = make(map[int]bool)
+		}
+		for line := fn.StartLine; line <= fn.EndLine; line++ {
+			lines[fn.Filename][line] = true
+		}
+	}
+
+	files := make([]string, 0, len(lines))
+	for file := range lines {
+		if filepath.IsAbs(file) {
+			continue
+		}
+		if _, err := tree.File(file); err != nil {
+			if errors.Is(err, object.ErrFileNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("looking up %s: %w", file, err)
+		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	byAuthor := make(map[string]*contributor)
+	for _, file := range files {
+		result, err := git.Blame(commit, file)
+		if err != nil {
+			return nil, fmt.Errorf("blaming %s: %w", file, err)
+		}
+		for line := range lines[file] {
+			// Analyzer lines are 1-based
+			if line < 1 || line > len(result.Lines) {
+				continue
+			}
+			blamed := result.Lines[line-1]
+			c, ok := byAuthor[blamed.Author]
+			if !ok {
+				c = &contributor{Name: blamed.AuthorName, Email: blamed.Author}
+				byAuthor[blamed.Author] = c
+			}
+			c.Lines++
+			if blamed.Date.After(c.LastTouched) {
+				c.LastTouched = blamed.Date
+			}
+			if blamed.Date.After(report.LastTouched) {
+				report.LastTouched = blamed.Date
+			}
+			report.Lines++
+		}
+	}
+
+	for _, c := range byAuthor {
+		report.Contributors = append(report.Contributors, *c)
+	}
+	sort.Slice(report.Contributors, func(i, j int) bool {
+		a, b := report.Contributors[i], report.Contributors[j]
+		if a.Lines != b.Lines {
+			return a.Lines > b.Lines
+		}
+		return a.LastTouched.After(b.LastTouched)
+	})
+	return report, nil
+}
+
+func printOwnership(report *ownership, top int) {
+	label := color.New(color.FgWhite, color.Bold)
+	value := color.New(color.FgCyan)
+	name := color.New(color.FgGreen)
+
+	label.Print("Endpoint: ")
+	value.Printf("%s\n", report.Endpoint)
+	label.Print("Functions: ")
+	value.Printf("%d (%d lines)\n", report.Functions, report.Lines)
+	label.Print("Last touched: ")
+	value.Printf("%s\n", report.LastTouched.Format("2006-01-02"))
+	fmt.Println()
+
+	contributors := report.Contributors
+	if top > 0 && len(contributors) > top {
+		contributors = contributors[:top]
+	}
+	fmt.Println("Contributors:")
+	for _, c := range contributors {
+		share := 100 * float64(c.Lines) / float64(report.Lines)
+		fmt.Print("\t")
+		name.Printf("%s <%s>", c.Name, c.Email)
+		fmt.Printf("  %d lines (%.1f%%), last touched %s\n", c.Lines, share, c.LastTouched.Format("2006-01-02"))
+	}
+	if rest := len(report.Contributors) - len(contributors); rest > 0 {
+		fmt.Printf("\t... and %d more\n", rest)
+	}
+}
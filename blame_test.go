@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestBlameEndpoint(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	commitAs := func(name, email string, when time.Time) {
+		if err := wt.AddGlob("."); err != nil {
+			t.Fatalf("failed to stage: %v", err)
+		}
+		_, err := wt.Commit("change", &git.CommitOptions{
+			Author: &object.Signature{Name: name, Email: email, When: when},
+		})
+		if err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+	}
+
+	early := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	writeFile(t, dir, "users.ts", "a\nb\nc\nd\n")
+	commitAs("Alice", "alice@example.com", early)
+	writeFile(t, dir, "users.ts", "a\nB\nc\nd\n")
+	commitAs("Bob", "bob@example.com", late)
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("failed to get HEAD: %v", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatalf("failed to get commit: %v", err)
+	}
+
+	// Two overlapping functions of the endpoint plus one from another endpoint
+	functions := []FunctionRange{
+		{ControllerName: "GET users/:id", FunctionName: "show", Filename: "users.ts", StartLine: 1, EndLine: 3},
+		{ControllerName: "GET users/:id", FunctionName: "load", Filename: "users.ts", StartLine: 2, EndLine: 2},
+		{ControllerName: "POST users", FunctionName: "create", Filename: "users.ts", StartLine: 4, EndLine: 4},
+		// Hit at a second call site, still one function
+		{ControllerName: "GET users/:id", FunctionName: "load", Filename: "users.ts", StartLine: 2, EndLine: 2},
+		// Functions outside the repository or its tree have nothing to blame
+		{ControllerName: "GET users/:id", FunctionName: "lib", Filename: "/elsewhere/node_modules/lib/index.js", StartLine: 1, EndLine: 5},
+		{ControllerName: "GET users/:id", FunctionName: "generated", Filename: "generated.ts", StartLine: 1, EndLine: 5},
+	}
+
+	report, err := blameEndpoint(commit, functions, "GET /users/:id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Functions != 4 || report.Lines != 3 {
+		t.Fatalf("expected 4 functions over 3 lines, got %d over %d", report.Functions, report.Lines)
+	}
+	if len(report.Contributors) != 2 {
+		t.Fatalf("expected 2 contributors, got %d", len(report.Contributors))
+	}
+	if top := report.Contributors[0]; top.Email != "alice@example.com" || top.Lines != 2 {
+		t.Errorf("expected Alice with 2 lines first, got %s with %d", top.Email, top.Lines)
+	}
+	if !report.LastTouched.Equal(late) {
+		t.Errorf("expected last touched %v, got %v", late, report.LastTouched)
+	}
+}
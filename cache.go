@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// The analyzer's output depends only on the tree it reads and the analyzer
+// itself, so results for commits are kept under the git directory, one file
+// per tree in a directory per analyzer version:
+//
+//	.git/pit/cache/<analyzer version>/<tree hash>.json
+//
+// A new pit binary never reads results of an older analyzer, and the
+// modification time of each file records when it was last used.
+
+// defaultCacheMaxAge is how long pit cache prune keeps unused results.
+const defaultCacheMaxAge = 30 * 24 * time.Hour
+
+// resultCache is the on-disk cache of analysis results for one repository.
+type resultCache struct {
+	dir string
+}
+
+// cacheEntry is one cached analysis. Commit is the first commit seen with
+// the tree, only for pit cache ls. Dependents maps each file to the
+// endpoints whose call graphs depend on it, so a later tree can tell which
+// call graphs its changes leave intact.
+type cacheEntry struct {
+	Tree       string              `json:"tree"`
+	Commit     string              `json:"commit"`
+	Analyzer   string              `json:"analyzer"`
+	Created    time.Time           `json:"created"`
+	Routes     []string            `json:"routes"`
+	Functions  []FunctionRange     `json:"functions"`
+	Warnings   []analyzerWarning   `json:"warnings"`
+	Dependents map[string][]string `json:"dependents"`
+}
+
+// openResultCache returns the cache kept in the git directory of r.
+func openResultCache(r *git.Repository, gitRoot string) *resultCache {
+	gitDir := filepath.Join(gitRoot, ".git")
+	if fs, ok := r.Storer.(*filesystem.Storage); ok {
+		gitDir = fs.Filesystem().Root()
+	}
+	return &resultCache{dir: filepath.Join(gitDir, "pit", "cache")}
+}
+
+func (c *resultCache) path(version string, tree plumbing.Hash) string {
+	return filepath.Join(c.dir, version, tree.String()+".json")
+}
+
+// get returns the cached result for tree from the current analyzer, if any.
+func (c *resultCache) get(tree plumbing.Hash) (*analyzerOutput, bool) {
+	path := c.path(analyzerVersion(), tree)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Tree != tree.String() {
+		// Damaged, so analyze again and overwrite it
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return &analyzerOutput{
+		Analyzer:     entry.Analyzer,
+		Routes:       entry.Routes,
+		Functions:    entry.Functions,
+		Warnings:     entry.Warnings,
+		Dependencies: forwardDependencies(entry.Dependents),
+	}, true
+}
+
+// put stores the result of analyzing commit's tree. The file is written
+// under a temporary name and renamed into place, so concurrent runs never
+// read a partial entry.
+func (c *resultCache) put(commit *object.Commit, output *analyzerOutput) error {
+	path := c.path(analyzerVersion(), commit.TreeHash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
+	}
+	data, err := json.Marshal(cacheEntry{
+		Tree:       commit.TreeHash.String(),
+		Commit:     commit.Hash.String(),
+		Analyzer:   analyzerVersion(),
+		Created:    time.Now(),
+		Routes:     output.Routes,
+		Functions:  output.Functions,
+		Warnings:   output.Warnings,
+		Dependents: reverseDependencies(output.Dependencies),
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "tmp-")
+	if err != nil {
+		return fmt.Errorf("writing cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing cache entry: %w", err)
+	}
+	return nil
+}
+
+// cachedResult describes a cache file for pit cache ls and prune.
+type cachedResult struct {
+	Path      string
+	Tree      string
+	Commit    string
+	Analyzer  string
+	Functions int
+	Size      int64
+	LastUsed  time.Time
+}
+
+// list returns every entry in the cache, most recently used first. Entries
+// that cannot be read are listed with what the file name says about them.
+func (c *resultCache) list() ([]cachedResult, error) {
+	versions, err := os.ReadDir(c.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading cache: %w", err)
+	}
+
+	var results []cachedResult
+	for _, v := range versions {
+		if !v.IsDir() {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(c.dir, v.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading cache: %w", err)
+		}
+		for _, f := range files {
+			name, ok := strings.CutSuffix(f.Name(), ".json")
+			if !ok {
+				continue
+			}
+			info, err := f.Info()
+			if err != nil {
+				continue
+			}
+			result := cachedResult{
+				Path:     filepath.Join(c.dir, v.Name(), f.Name()),
+				Tree:     name,
+				Analyzer: v.Name(),
+				Size:     info.Size(),
+				LastUsed: info.ModTime(),
+			}
+			if data, err := os.ReadFile(result.Path); err == nil {
+				var entry cacheEntry
+				if json.Unmarshal(data, &entry) == nil {
+					result.Commit = entry.Commit
+					result.Functions = len(entry.Functions)
+				}
+			}
+			results = append(results, result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].LastUsed.After(results[j].LastUsed)
+	})
+	return results, nil
+}
+
+// prune removes entries written by other analyzer versions and entries not
+// used within maxAge, returning what it removed.
+func (c *resultCache) prune(maxAge time.Duration) ([]cachedResult, error) {
+	results, err := c.list()
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	var removed []cachedResult
+	for _, r := range results {
+		if r.Analyzer == analyzerVersion() && r.LastUsed.After(cutoff) {
+			continue
+		}
+		if err := os.Remove(r.Path); err != nil {
+			return removed, fmt.Errorf("removing %s: %w", r.Path, err)
+		}
+		removed = append(removed, r)
+	}
+
+	// Drop directories of analyzer versions that are now empty
+	versions, _ := os.ReadDir(c.dir)
+	for _, v := range versions {
+		if v.Name() != analyzerVersion() {
+			os.Remove(filepath.Join(c.dir, v.Name()))
+		}
+	}
+	return removed, nil
+}
+
+// clear removes the whole cache.
+func (c *resultCache) clear() error {
+	if err := os.RemoveAll(c.dir); err != nil {
+		return fmt.Errorf("clearing cache: %w", err)
+	}
+	return nil
+}
+
+func printCacheUsage() {
+	fmt.Println("Usage: pit cache ls [path]")
+	fmt.Println("       pit cache prune [--max-age <d>] [path]")
+	fmt.Println("       pit cache clear [path]")
+	fmt.Println("Manages the analysis results pit keeps for each commit tree in the repository")
+	fmt.Println("at path. prune removes results of other analyzer versions and results not used")
+	fmt.Println("for --max-age (default 720h).")
+}
+
+func runCache(args []string) {
+	if len(args) == 0 {
+		printCacheUsage()
+		os.Exit(1)
+	}
+	action := args[0]
+
+	flags := flag.NewFlagSet("cache "+action, flag.ExitOnError)
+	maxAge := flags.Duration("max-age", defaultCacheMaxAge, "remove results not used for this long")
+	flags.Usage = printCacheUsage
+	flags.Parse(args[1:])
+
+	path := "."
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		path = flags.Arg(0)
+	default:
+		printCacheUsage()
+		os.Exit(1)
+	}
+
+	gitRoot := setupGitRoot(path)
+	r, err := git.PlainOpen(gitRoot)
+	if err != nil {
+		fmt.Printf("Error opening repository: %v\n", err)
+		os.Exit(1)
+	}
+	cache := openResultCache(r, gitRoot)
+
+	switch action {
+	case "ls":
+		results, err := cache.list()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		printCacheList(results, cache.dir)
+	case "prune":
+		removed, err := cache.prune(*maxAge)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		var size int64
+		for _, r := range removed {
+			size += r.Size
+		}
+		fmt.Printf("Removed %d cached results (%s)\n", len(removed), formatSize(size))
+	case "clear":
+		if err := cache.clear(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Cache cleared")
+	default:
+		printCacheUsage()
+		os.Exit(1)
+	}
+}
+
+func printCacheList(results []cachedResult, dir string) {
+	label := color.New(color.FgWhite, color.Bold)
+	value := color.New(color.FgCyan)
+
+	label.Print("Cache: ")
+	value.Printf("%s\n", dir)
+	if len(results) == 0 {
+		fmt.Println("No cached results")
+		return
+	}
+
+	var size int64
+	for _, r := range results {
+		size += r.Size
+		commit := "unreadable"
+		if r.Commit != "" {
+			commit = "commit " + r.Commit[:min(7, len(r.Commit))]
+		}
+		stale := ""
+		if r.Analyzer != analyzerVersion() {
+			stale = color.YellowString(" (older analyzer)")
+		}
+		fmt.Printf("\t%s  %-14s  %4d functions  %8s  used %s%s\n",
+			color.CyanString(r.Tree[:min(12, len(r.Tree))]), commit, r.Functions,
+			formatSize(r.Size), r.LastUsed.Format("2006-01-02 15:04"), stale)
+	}
+	label.Printf("%d results, %s\n", len(results), formatSize(size))
+}
+
+// formatSize formats a byte count for people.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func headCommit(t *testing.T, repo *git.Repository) *object.Commit {
+	t.Helper()
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("failed to resolve HEAD: %v", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatalf("failed to read HEAD: %v", err)
+	}
+	return commit
+}
+
+func TestResultCache(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	writeFile(t, dir, "app.ts", "export class App {}\n")
+	commitAll(t, wt, "initial")
+	commit := headCommit(t, repo)
+
+	cache := openResultCache(repo, dir)
+	if want := filepath.Join(dir, ".git", "pit", "cache"); cache.dir != want {
+		t.Errorf("expected cache in %s, got %s", want, cache.dir)
+	}
+	if _, ok := cache.get(commit.TreeHash); ok {
+		t.Fatal("expected a miss on an empty cache")
+	}
+
+	output := &analyzerOutput{
+		Routes:    []string{"GET /app"},
+		Functions: []FunctionRange{{ControllerName: "GET /app", FunctionName: "get", Filename: "app.ts", StartLine: 1, EndLine: 1}},
+		Warnings:  []analyzerWarning{{Kind: warnSkippedFile, File: "src/gone.ts"}},
+	}
+	if err := cache.put(commit, output); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	cached, ok := cache.get(commit.TreeHash)
+	if !ok {
+		t.Fatal("expected a hit after put")
+	}
+	if len(cached.Functions) != 1 || cached.Functions[0] != output.Functions[0] {
+		t.Errorf("unexpected functions: %+v", cached.Functions)
+	}
+	if len(cached.Warnings) != 1 || cached.Warnings[0] != output.Warnings[0] {
+		t.Errorf("unexpected warnings: %+v", cached.Warnings)
+	}
+
+	// A damaged entry is a miss, not an error
+	os.WriteFile(cache.path(analyzerVersion(), commit.TreeHash), []byte("{"), 0644)
+	if _, ok := cache.get(commit.TreeHash); ok {
+		t.Error("expected a damaged entry to miss")
+	}
+}
+
+func TestTreeAnalyzer_UsesCache(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	writeFile(t, dir, "app.ts", "export class App {}\n")
+	commitAll(t, wt, "initial")
+	commit := headCommit(t, repo)
+
+	functions := []FunctionRange{{ControllerName: "GET /app", FunctionName: "get", Filename: "app.ts", StartLine: 1, EndLine: 1}}
+	if err := openResultCache(repo, dir).put(commit, &analyzerOutput{Functions: functions}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+
+	// No runtime is configured, so anything but a cache hit would fail
+	analyzer := newTreeAnalyzer(repo, dir, analyzerConfig{})
+	got, err := analyzer.analyze(context.Background(), snapshot{commit: commit}, "head")
+	if err != nil {
+		t.Fatalf("analyze failed: %v", err)
+	}
+	if len(got) != 1 || got[0] != functions[0] {
+		t.Errorf("expected the cached functions, got %+v", got)
+	}
+}
+
+func TestResultCache_Prune(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	writeFile(t, dir, "a.ts", "one\n")
+	commitAll(t, wt, "first")
+	first := headCommit(t, repo)
+	writeFile(t, dir, "a.ts", "two\n")
+	commitAll(t, wt, "second")
+	second := headCommit(t, repo)
+
+	cache := openResultCache(repo, dir)
+	for _, c := range []*object.Commit{first, second} {
+		if err := cache.put(c, &analyzerOutput{}); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+	// first was last used long ago
+	old := time.Now().Add(-2 * defaultCacheMaxAge)
+	os.Chtimes(cache.path(analyzerVersion(), first.TreeHash), old, old)
+	// and an older analyzer left a result behind
+	stale := cache.path("000000000000", second.TreeHash)
+	os.MkdirAll(filepath.Dir(stale), 0755)
+	os.WriteFile(stale, []byte("{}"), 0644)
+
+	removed, err := cache.prune(defaultCacheMaxAge)
+	if err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("expected 2 results removed, got %+v", removed)
+	}
+	results, err := cache.list()
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Tree != second.TreeHash.String() || results[0].Commit != second.Hash.String() {
+		t.Errorf("expected only the recent result to remain, got %+v", results)
+	}
+	if _, err := os.Stat(filepath.Dir(stale)); !os.IsNotExist(err) {
+		t.Error("expected the older analyzer's directory to be removed")
+	}
+
+	if err := cache.clear(); err != nil {
+		t.Fatalf("clear failed: %v", err)
+	}
+	if results, _ := cache.list(); len(results) != 0 {
+		t.Errorf("expected an empty cache after clear, got %+v", results)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// Commits and the index are analyzed from a checkout of their files, so
+// line numbers match the patch rather than whatever is checked out. Each
+// repository keeps a few checkouts, or slots, under its git directory:
+//
+//	.git/pit/checkouts/<slot>/files/       the files of one snapshot
+//	.git/pit/checkouts/<slot>/state.json   what was written there
+//
+// Moving a slot to another snapshot rewrites only the files that differ,
+// so unchanged files keep their modification times and the slot keeps its
+// path from run to run. The daemon keys its loaded projects by entrypoint
+// and re-reads files by modification time, so a slot stays warm in it.
+
+// commitSlots is how many checkouts of commits are kept, one for each side
+// of a comparison.
+const commitSlots = 2
+
+// indexSlot is the checkout of the index.
+const indexSlot = "index"
+
+// checkoutFile is a file of a snapshot.
+type checkoutFile struct {
+	Hash string            `json:"hash"`
+	Mode filemode.FileMode `json:"mode"`
+}
+
+// checkoutState is what a slot holds. Source is the tree hash of the commit
+// checked out, or empty for the index.
+type checkoutState struct {
+	Source string                  `json:"source"`
+	Files  map[string]checkoutFile `json:"files"`
+}
+
+// checkoutSlot is a slot locked by this pit until release is called.
+type checkoutSlot struct {
+	dir  string
+	lock *os.File
+}
+
+func (s *checkoutSlot) files() string { return filepath.Join(s.dir, "files") }
+func (s *checkoutSlot) state() string { return filepath.Join(s.dir, "state.json") }
+
+func (s *checkoutSlot) release() {
+	syscall.Flock(int(s.lock.Fd()), syscall.LOCK_UN)
+	s.lock.Close()
+}
+
+// checkoutsDir returns the directory holding the checkouts of r.
+func checkoutsDir(r *git.Repository, gitRoot string) string {
+	gitDir := filepath.Join(gitRoot, ".git")
+	if fs, ok := r.Storer.(*filesystem.Storage); ok {
+		gitDir = fs.Filesystem().Root()
+	}
+	return filepath.Join(gitDir, "pit", "checkouts")
+}
+
+// checkoutSnapshot writes the files of a commit or the index into a slot
+// and returns its directory, with a function that gives the slot up once
+// analysis is done. When every slot it could use is held by another pit,
+// the files go to a new temporary directory instead.
+func checkoutSnapshot(r *git.Repository, gitRoot string, snap snapshot) (string, func(), error) {
+	var files map[string]checkoutFile
+	var source string
+	var err error
+	if snap.commit != nil {
+		source = snap.commit.TreeHash.String()
+		files, err = commitFiles(snap.commit)
+	} else {
+		files, err = indexFiles(r)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	slot, err := lockSlot(checkoutsDir(r, gitRoot), snap.commit != nil, source)
+	if err != nil {
+		return "", nil, err
+	}
+	if slot == nil {
+		return temporaryCheckout(r, gitRoot, snap)
+	}
+	if err := syncCheckout(r, slot, source, files); err != nil {
+		slot.release()
+		return "", nil, err
+	}
+	linkNodeModules(slot.files(), gitRoot)
+
+	// Record the use so the least recently used slot is moved next
+	now := time.Now()
+	os.Chtimes(slot.state(), now, now)
+	return slot.files(), slot.release, nil
+}
+
+// temporaryCheckout writes snap into a temporary directory removed once
+// analysis is done or pit exits.
+func temporaryCheckout(r *git.Repository, gitRoot string, snap snapshot) (string, func(), error) {
+	var dir string
+	var err error
+	if snap.commit != nil {
+		dir, err = materializeCommit(snap.commit, gitRoot)
+	} else {
+		dir, err = materializeIndex(r, gitRoot)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return dir, atExit(func() { os.RemoveAll(dir) }), nil
+}
+
+// lockSlot locks a slot for a commit with tree source, or for the index,
+// returning nil if all the candidates are in use. Among free commit slots
+// it picks the one already holding source, else the least recently used.
+func lockSlot(dir string, commit bool, source string) (*checkoutSlot, error) {
+	names := []string{indexSlot}
+	if commit {
+		names = names[:0]
+		for i := 0; i < commitSlots; i++ {
+			names = append(names, fmt.Sprintf("commit-%d", i))
+		}
+	}
+
+	var free []*checkoutSlot
+	for _, name := range names {
+		slot := &checkoutSlot{dir: filepath.Join(dir, name)}
+		if err := os.MkdirAll(slot.dir, 0755); err != nil {
+			return nil, fmt.Errorf("creating checkout: %w", err)
+		}
+		lock, err := os.OpenFile(filepath.Join(slot.dir, "lock"), os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("opening checkout lock: %w", err)
+		}
+		if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			lock.Close()
+			continue
+		}
+		slot.lock = lock
+		free = append(free, slot)
+	}
+	if len(free) == 0 {
+		return nil, nil
+	}
+
+	lastUsed := func(slot *checkoutSlot) time.Time {
+		fi, err := os.Stat(slot.state())
+		if err != nil {
+			return time.Time{}
+		}
+		return fi.ModTime()
+	}
+	sort.SliceStable(free, func(i, j int) bool {
+		return lastUsed(free[i]).Before(lastUsed(free[j]))
+	})
+	chosen := free[0]
+	for _, slot := range free {
+		if state, err := readCheckoutState(slot); err == nil && state.Source == source {
+			chosen = slot
+			break
+		}
+	}
+	for _, slot := range free {
+		if slot != chosen {
+			slot.release()
+		}
+	}
+	return chosen, nil
+}
+
+func readCheckoutState(slot *checkoutSlot) (*checkoutState, error) {
+	data, err := os.ReadFile(slot.state())
+	if err != nil {
+		return nil, err
+	}
+	var state checkoutState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// syncCheckout makes the files of slot match files, writing only those
+// that differ from what it held before. A slot whose state is missing,
+// because it is new or a sync was interrupted, is written afresh.
+func syncCheckout(r *git.Repository, slot *checkoutSlot, source string, files map[string]checkoutFile) error {
+	state, err := readCheckoutState(slot)
+	if err != nil {
+		state = &checkoutState{}
+		if err := os.RemoveAll(slot.files()); err != nil {
+			return fmt.Errorf("clearing checkout: %w", err)
+		}
+	}
+	// Until the new state is written the files match neither
+	if err := os.Remove(slot.state()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("updating checkout: %w", err)
+	}
+	if err := os.MkdirAll(slot.files(), 0755); err != nil {
+		return fmt.Errorf("creating checkout: %w", err)
+	}
+
+	for name, old := range state.Files {
+		if files[name] == old {
+			continue
+		}
+		path := filepath.Join(slot.files(), filepath.FromSlash(name))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing %s: %w", name, err)
+		}
+		// A directory emptied out may be where a file now goes
+		removeEmptyParents(slot.files(), filepath.Dir(path))
+	}
+
+	for name, file := range files {
+		if state.Files[name] == file {
+			continue
+		}
+		blob, err := r.BlobObject(plumbing.NewHash(file.Hash))
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+		err = writeTreeFile(slot.files(), name, file.Mode, reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+	}
+
+	data, err := json.Marshal(checkoutState{Source: source, Files: files})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(slot.state(), data, 0644); err != nil {
+		return fmt.Errorf("updating checkout: %w", err)
+	}
+	return nil
+}
+
+// removeEmptyParents removes dir and its parents up to root for as long as
+// they are empty.
+func removeEmptyParents(root, dir string) {
+	for dir != root && len(dir) > len(root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// commitFiles lists the files in the tree of commit, without reading them.
+func commitFiles(commit *object.Commit) (map[string]checkoutFile, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("error getting tree of %s: %w", commit.Hash, err)
+	}
+	files := make(map[string]checkoutFile)
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading tree of %s: %w", commit.Hash, err)
+		}
+		if entry.Mode.IsFile() {
+			files[name] = checkoutFile{Hash: entry.Hash.String(), Mode: entry.Mode}
+		}
+	}
+	return files, nil
+}
+
+// indexFiles lists the files staged in the index.
+func indexFiles(r *git.Repository) (map[string]checkoutFile, error) {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+	files := make(map[string]checkoutFile)
+	for _, entry := range idx.Entries {
+		if entry.Mode != filemode.Submodule {
+			files[entry.Name] = checkoutFile{Hash: entry.Hash.String(), Mode: entry.Mode}
+		}
+	}
+	return files, nil
+}
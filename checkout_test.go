@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestCheckoutSnapshot(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	for _, d := range []string{"src", "lib"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", d, err)
+		}
+	}
+	writeFile(t, dir, "src/main.ts", "main\n")
+	writeFile(t, dir, "src/users.ts", "users\n")
+	writeFile(t, dir, "lib/util.ts", "util\n")
+	commitAll(t, wt, "a")
+	a := headCommit(t, repo)
+
+	writeFile(t, dir, "src/users.ts", "users b\n")
+	commitAll(t, wt, "b")
+	b := headCommit(t, repo)
+
+	// c edits users.ts again and replaces the lib directory with a file
+	writeFile(t, dir, "src/users.ts", "users c\n")
+	if _, err := wt.Remove("lib/util.ts"); err != nil {
+		t.Fatalf("failed to remove: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "lib")); err != nil {
+		t.Fatalf("failed to remove lib: %v", err)
+	}
+	writeFile(t, dir, "lib", "now a file\n")
+	commitAll(t, wt, "c")
+	c := headCommit(t, repo)
+
+	checkout := func(commit *object.Commit) string {
+		t.Helper()
+		root, release, err := checkoutSnapshot(repo, dir, snapshot{commit: commit})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		release()
+		return root
+	}
+	// Age every file so a rewrite shows up in its modification time
+	old := time.Now().Add(-time.Hour)
+	age := func(root string) {
+		filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err == nil && fi.Mode().IsRegular() {
+				os.Chtimes(path, old, old)
+			}
+			return nil
+		})
+	}
+	rewritten := func(root, name string) bool {
+		t.Helper()
+		fi, err := os.Stat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatalf("expected %s in checkout: %v", name, err)
+		}
+		return !fi.ModTime().Equal(old)
+	}
+	read := func(root, name string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Fatalf("expected %s in checkout: %v", name, err)
+		}
+		return string(data)
+	}
+
+	rootA := checkout(a)
+	if !strings.HasPrefix(rootA, filepath.Join(dir, ".git", "pit", "checkouts")) {
+		t.Fatalf("expected a checkout under the git directory, got %s", rootA)
+	}
+	age(rootA)
+
+	// The same tree again is the same directory, untouched
+	if again := checkout(a); again != rootA {
+		t.Fatalf("expected %s again, got %s", rootA, again)
+	}
+	if rewritten(rootA, "src/main.ts") || rewritten(rootA, "src/users.ts") {
+		t.Error("expected checking out the same tree to rewrite nothing")
+	}
+
+	// b takes the other slot, leaving a's in place
+	rootB := checkout(b)
+	if rootB == rootA {
+		t.Fatal("expected b in the other slot")
+	}
+	if read(rootA, "src/users.ts") != "users\n" || read(rootB, "src/users.ts") != "users b\n" {
+		t.Error("expected a and b checked out side by side")
+	}
+
+	// c moves the least recently used slot, a's, rewriting only what differs
+	if rootC := checkout(c); rootC != rootA {
+		t.Fatalf("expected c in a's slot %s, got %s", rootA, rootC)
+	}
+	if rewritten(rootA, "src/main.ts") {
+		t.Error("expected unchanged src/main.ts not to be rewritten")
+	}
+	if !rewritten(rootA, "src/users.ts") || read(rootA, "src/users.ts") != "users c\n" {
+		t.Error("expected src/users.ts rewritten with c's contents")
+	}
+	if read(rootA, "lib") != "now a file\n" {
+		t.Error("expected lib to have become a file")
+	}
+}
+
+func TestCheckoutSnapshot_SlotsInUse(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	writeFile(t, dir, "a.ts", "a\n")
+	commitAll(t, wt, "a")
+	commit := headCommit(t, repo)
+
+	// Another pit holding every commit slot
+	var releases []func()
+	for i := 0; i < commitSlots; i++ {
+		_, release, err := checkoutSnapshot(repo, dir, snapshot{commit: commit})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		releases = append(releases, release)
+	}
+
+	root, release, err := checkoutSnapshot(repo, dir, snapshot{commit: commit})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.HasPrefix(root, dir) {
+		t.Errorf("expected a temporary checkout with every slot in use, got %s", root)
+	}
+	release()
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary checkout removed, got %v", err)
+	}
+	for _, release := range releases {
+		release()
+	}
+}
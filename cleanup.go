@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// cleanup is a function to run before pit exits. done is set once it has
+// run so that releasing it early and exiting later do not run it twice.
+type cleanup struct {
+	fn   func()
+	done bool
+}
+
+var (
+	cleanupMu sync.Mutex
+	cleanups  []*cleanup
+)
+
+// atExit registers fn to run when pit exits through exit, a signal or
+// runCleanups. The returned function runs fn straight away instead, for
+// resources such as temporary trees that are done with before pit exits.
+func atExit(fn func()) func() {
+	c := &cleanup{fn: fn}
+	cleanupMu.Lock()
+	cleanups = append(cleanups, c)
+	cleanupMu.Unlock()
+
+	return func() {
+		cleanupMu.Lock()
+		defer cleanupMu.Unlock()
+		c.run()
+	}
+}
+
+func (c *cleanup) run() {
+	if c.done {
+		return
+	}
+	c.done = true
+	c.fn()
+}
+
+// runCleanups runs every pending cleanup, most recently registered first.
+func runCleanups() {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i].run()
+	}
+	cleanups = nil
+}
+
+// exit runs the registered cleanups and exits with code. Anything that
+// exits after the IPC channel exists must go through here rather than
+// os.Exit, which skips deferred calls.
+func exit(code int) {
+	runCleanups()
+	os.Exit(code)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateIPCChannel(t *testing.T) {
+	first, err := createIPCChannel()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := createIPCChannel()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first == second {
+		t.Fatalf("expected distinct pipes, both were %s", first)
+	}
+
+	for _, pipe := range []string{first, second} {
+		fi, err := os.Stat(pipe)
+		if err != nil {
+			t.Fatalf("expected pipe to exist: %v", err)
+		}
+		if fi.Mode()&os.ModeNamedPipe == 0 {
+			t.Errorf("expected %s to be a named pipe", pipe)
+		}
+		if perm := fi.Mode().Perm(); perm&0077 != 0 {
+			t.Errorf("expected %s to be private, got %v", pipe, perm)
+		}
+		dir, err := os.Stat(filepath.Dir(pipe))
+		if err != nil {
+			t.Fatalf("expected pipe directory to exist: %v", err)
+		}
+		if perm := dir.Mode().Perm(); perm&0077 != 0 {
+			t.Errorf("expected %s to be private, got %v", filepath.Dir(pipe), perm)
+		}
+	}
+
+	runCleanups()
+	for _, pipe := range []string{first, second} {
+		if _, err := os.Stat(filepath.Dir(pipe)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", filepath.Dir(pipe), err)
+		}
+	}
+}
+
+func TestAtExit_Release(t *testing.T) {
+	calls := 0
+	release := atExit(func() { calls++ })
+	release()
+	release()
+	runCleanups()
+	if calls != 1 {
+		t.Errorf("expected cleanup to run once, ran %d times", calls)
+	}
+}
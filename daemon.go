@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// The daemon is a long-lived analyzer that keeps each project it has seen
+// loaded, re-reading only the files that changed since the last request. pit
+// talks to it with JSON-RPC 2.0, one message per line, over a Unix socket;
+// results still come back through the run's named pipe in the analyzer
+// protocol, so they are validated exactly like a one-off run.
+
+// daemonEntrypoint is the daemon script, relative to the analyzer root.
+const daemonEntrypoint = "ts_src/ffi/daemon.ts"
+
+// daemonStartTimeout is how long to wait for a new daemon to listen.
+const daemonStartTimeout = 30 * time.Second
+
+// daemonPaths are the files of the daemon, kept in a directory only the
+// current user can reach since anyone who can connect can have it read and
+// write files.
+type daemonPaths struct {
+	Dir    string
+	Socket string
+	Lock   string
+	Log    string
+}
+
+func getDaemonPaths() (daemonPaths, error) {
+	cacheRoot, err := analyzerCacheRoot()
+	if err != nil {
+		return daemonPaths{}, err
+	}
+	dir := filepath.Join(cacheRoot, "daemon")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return daemonPaths{}, fmt.Errorf("creating daemon directory: %w", err)
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return daemonPaths{}, fmt.Errorf("securing daemon directory: %w", err)
+	}
+	return daemonPaths{
+		Dir:    dir,
+		Socket: filepath.Join(dir, "daemon.sock"),
+		Lock:   filepath.Join(dir, "start.lock"),
+		Log:    filepath.Join(dir, "daemon.log"),
+	}, nil
+}
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int    `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int             `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("daemon: %s (code %d)", e.Message, e.Code)
+}
+
+// daemonStatus is the daemon's answer to ping.
+type daemonStatus struct {
+	Protocol int      `json:"protocol"`
+	Analyzer string   `json:"analyzer"`
+	PID      int      `json:"pid"`
+	Uptime   float64  `json:"uptime"`   // seconds
+	Projects []string `json:"projects"` // entrypoints kept loaded
+}
+
+// daemonClient is a connection to the daemon. Calls are made one at a time.
+type daemonClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int
+}
+
+func dialDaemon(socket string) (*daemonClient, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	return &daemonClient{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (c *daemonClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *daemonClient) send(method string, params any) (int, error) {
+	c.nextID++
+	data, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: c.nextID, Method: method, Params: params})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		return 0, fmt.Errorf("sending %s to daemon: %w", method, err)
+	}
+	return c.nextID, nil
+}
+
+func (c *daemonClient) receive(id int, result any) error {
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("reading from daemon: %w", err)
+	}
+	var resp rpcResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return fmt.Errorf("invalid response from daemon: %w", err)
+	}
+	if resp.ID != id {
+		return fmt.Errorf("daemon answered request %d, expected %d", resp.ID, id)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+func (c *daemonClient) call(method string, params, result any) error {
+	id, err := c.send(method, params)
+	if err != nil {
+		return err
+	}
+	return c.receive(id, result)
+}
+
+func (c *daemonClient) ping() (*daemonStatus, error) {
+	var status daemonStatus
+	if err := c.call("ping", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// daemonAnalysis is an analyze request in flight. Wait returns once the
+// daemon has finished writing to the pipe.
+type daemonAnalysis struct {
+	client *daemonClient
+	id     int
+	stop   func() bool
+}
+
+// analyze asks the daemon to carry out req and write the result to
+// pipeName. Ending ctx abandons the request; the daemon stops once the pipe
+// is closed on it.
+func (c *daemonClient) analyze(ctx context.Context, req analysisRequest, pipeName string) (analysis, error) {
+	params := map[string]string{"entrypoint": req.mainPath, "framework": req.framework, "pipe": pipeName}
+	if req.reusePath != "" {
+		params["reuse"] = req.reusePath
+	}
+	id, err := c.send("analyze", params)
+	if err != nil {
+		return nil, err
+	}
+	a := &daemonAnalysis{client: c, id: id}
+	a.stop = context.AfterFunc(ctx, func() { a.Kill() })
+	return a, nil
+}
+
+func (a *daemonAnalysis) Wait() error {
+	defer a.stop()
+	return a.client.receive(a.id, nil)
+}
+
+func (a *daemonAnalysis) Kill() error {
+	return a.client.conn.SetDeadline(time.Now())
+}
+
+// connectDaemon returns a client for a daemon running the analyzer embedded
+// in this binary, starting one with rt if none is running or replacing one
+// left by a different version of pit.
+func connectDaemon(rt *jsRuntime) (*daemonClient, error) {
+	paths, err := getDaemonPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only one pit at a time may decide to start a daemon
+	lock, err := os.OpenFile(paths.Lock, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening daemon lock: %w", err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, fmt.Errorf("locking daemon: %w", err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	if client, err := dialDaemon(paths.Socket); err == nil {
+		status, err := client.ping()
+		if err == nil && status.Protocol == protocolVersion && status.Analyzer == analyzerVersion() {
+			return client, nil
+		}
+		// Left behind by another version of pit
+		client.call("shutdown", nil, nil)
+		client.Close()
+	}
+
+	os.Remove(paths.Socket)
+	if err := startDaemon(rt, paths); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(daemonStartTimeout)
+	for {
+		client, err := dialDaemon(paths.Socket)
+		if err == nil {
+			return client, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("daemon did not start within %s; see %s", daemonStartTimeout, paths.Log)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// startDaemon starts the daemon in a session of its own so it outlives
+// this pit, with its output appended to the daemon log.
+func startDaemon(rt *jsRuntime, paths daemonPaths) error {
+	dir, err := analyzerRoot()
+	if err != nil {
+		return err
+	}
+	log, err := os.OpenFile(paths.Log, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("opening daemon log: %w", err)
+	}
+	defer log.Close()
+
+	if err := rt.prepare(context.Background(), dir, log); err != nil {
+		return err
+	}
+	cmd := rt.command(context.Background(), filepath.Join(dir, daemonEntrypoint), paths.Socket, analyzerVersion())
+	cmd.Dir = dir
+	cmd.Stdout = log
+	cmd.Stderr = log
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting daemon with %s: %w", rt.Name, err)
+	}
+	return cmd.Process.Release()
+}
+
+// daemonEnabled reports whether analysis should go through the daemon: the
+// --daemon flag, PIT_DAEMON=1, or the pit.daemon git config key.
+func daemonEnabled(flagValue bool, gitRoot string) bool {
+	if flagValue {
+		return true
+	}
+	switch strings.ToLower(os.Getenv("PIT_DAEMON")) {
+	case "1", "true", "yes":
+		return true
+	case "0", "false", "no":
+		return false
+	}
+	return pitConfig(gitRoot, "daemon") == "true"
+}
+
+// setupDaemon connects to the daemon if it is enabled. A daemon that cannot
+// be reached is not fatal; pit falls back to running the analyzer itself.
+func setupDaemon(enabled bool, rt *jsRuntime) *daemonClient {
+	if !enabled {
+		return nil
+	}
+	client, err := connectDaemon(rt)
+	if err != nil {
+		color.Yellow("Warning: analyzer daemon unavailable, analyzing without it: %v", err)
+		return nil
+	}
+	atExit(func() { client.Close() })
+	return client
+}
+
+func printDaemonUsage() {
+	fmt.Println("Usage: pit daemon [--runtime <runtime>] start | stop | status")
+	fmt.Println("Manages the analyzer daemon, which keeps projects loaded between runs so")
+	fmt.Println("repeated analysis only re-reads changed files. Use it with --daemon, PIT_DAEMON=1")
+	fmt.Println("or git config pit.daemon true. It exits after 30 minutes without requests.")
+}
+
+func runDaemon(args []string) {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	runtimeName := flags.String("runtime", "", "JavaScript runtime to start the daemon with")
+	flags.Usage = printDaemonUsage
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		printDaemonUsage()
+		os.Exit(1)
+	}
+
+	paths, err := getDaemonPaths()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch flags.Arg(0) {
+	case "start":
+		cwd, _ := os.Getwd()
+		gitRoot, _ := findGitRoot(cwd)
+		client, err := connectDaemon(setupRuntime(*runtimeName, gitRoot))
+		if err != nil {
+			fmt.Printf("Error starting daemon: %v\n", err)
+			os.Exit(1)
+		}
+		defer client.Close()
+		printDaemonStatus(client, paths)
+	case "stop":
+		client, err := dialDaemon(paths.Socket)
+		if err != nil {
+			fmt.Println("Daemon is not running")
+			return
+		}
+		defer client.Close()
+		if err := client.call("shutdown", nil, nil); err != nil {
+			fmt.Printf("Error stopping daemon: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Daemon stopped")
+	case "status":
+		client, err := dialDaemon(paths.Socket)
+		if err != nil {
+			fmt.Println("Daemon is not running")
+			os.Exit(1)
+		}
+		defer client.Close()
+		printDaemonStatus(client, paths)
+	default:
+		printDaemonUsage()
+		os.Exit(1)
+	}
+}
+
+func printDaemonStatus(client *daemonClient, paths daemonPaths) {
+	status, err := client.ping()
+	if err != nil {
+		fmt.Printf("Error querying daemon: %v\n", err)
+		os.Exit(1)
+	}
+	label := color.New(color.FgWhite, color.Bold)
+	value := color.New(color.FgCyan)
+
+	label.Print("Daemon: ")
+	value.Printf("pid %d, up %s\n", status.PID, time.Duration(status.Uptime*float64(time.Second)).Round(time.Second))
+	label.Print("Analyzer: ")
+	value.Printf("%s", status.Analyzer)
+	if status.Analyzer != analyzerVersion() {
+		fmt.Printf(" (this pit is %s; the daemon is replaced on next use)", analyzerVersion())
+	}
+	fmt.Println()
+	label.Print("Socket: ")
+	value.Printf("%s\n", paths.Socket)
+	label.Print("Log: ")
+	value.Printf("%s\n", paths.Log)
+	label.Printf("Projects loaded (%d):\n", len(status.Projects))
+	for _, p := range status.Projects {
+		fmt.Printf("\t%s\n", p)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeDaemon listens on a socket in a temporary directory and answers each
+// request with respond, returning the socket path.
+func fakeDaemon(t *testing.T, respond func(req rpcRequest) string) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "d.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					var req rpcRequest
+					if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+						return
+					}
+					if resp := respond(req); resp != "" {
+						fmt.Fprintln(conn, resp)
+					}
+				}
+			}()
+		}
+	}()
+	return socket
+}
+
+func TestDaemonClient(t *testing.T) {
+	socket := fakeDaemon(t, func(req rpcRequest) string {
+		switch req.Method {
+		case "ping":
+			return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"result":{"protocol":1,"analyzer":"abc","pid":42,"uptime":1.5,"projects":["/repo/src/main.ts"]}}`, req.ID)
+		case "analyze":
+			return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"error":{"code":-32000,"message":"entrypoint not found"}}`, req.ID)
+		}
+		return ""
+	})
+
+	client, err := dialDaemon(socket)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	status, err := client.ping()
+	if err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+	if status.PID != 42 || status.Analyzer != "abc" || len(status.Projects) != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	run, err := client.analyze(context.Background(), analysisRequest{mainPath: "/repo/src/main.ts", framework: "nestjs"}, "/tmp/pipe")
+	if err != nil {
+		t.Fatalf("analyze failed: %v", err)
+	}
+	var rpcErr *rpcError
+	if err := run.Wait(); !errors.As(err, &rpcErr) || rpcErr.Message != "entrypoint not found" {
+		t.Errorf("expected the daemon's error, got %v", err)
+	}
+}
+
+func TestDaemonAnalysis_Cancelled(t *testing.T) {
+	// Never answers analyze, like a daemon stuck on a huge project
+	socket := fakeDaemon(t, func(req rpcRequest) string { return "" })
+	client, err := dialDaemon(socket)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run, err := client.analyze(ctx, analysisRequest{mainPath: "/repo/src/main.ts", framework: "nestjs"}, "/tmp/pipe")
+	if err != nil {
+		t.Fatalf("analyze failed: %v", err)
+	}
+	cancel()
+
+	waited := make(chan error, 1)
+	go func() { waited <- run.Wait() }()
+	select {
+	case err := <-waited:
+		if err == nil {
+			t.Error("expected an error from an abandoned request")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after the context was cancelled")
+	}
+}
+
+func TestDaemonEnabled(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("PIT_DAEMON", "")
+
+	dir, repo, _ := initTestRepo(t)
+	if daemonEnabled(false, dir) {
+		t.Error("expected the daemon to be off by default")
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	cfg.Raw.Section("pit").SetOption("daemon", "true")
+	if err := repo.SetConfig(cfg); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if !daemonEnabled(false, dir) {
+		t.Error("expected git config to enable the daemon")
+	}
+
+	t.Setenv("PIT_DAEMON", "0")
+	if daemonEnabled(false, dir) {
+		t.Error("expected PIT_DAEMON=0 to override git config")
+	}
+	if !daemonEnabled(true, dir) {
+		t.Error("expected --daemon to override PIT_DAEMON")
+	}
+}
+
+func TestDaemonAnalysis_ReusesCommitCheckout(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	if err := os.MkdirAll(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatalf("failed to create src: %v", err)
+	}
+	writeFile(t, dir, "package.json", `{"dependencies":{"@nestjs/core":"^10"}}`)
+	writeFile(t, dir, "src/main.ts", "bootstrap()\n")
+	commitAll(t, wt, "initial")
+	commit := headCommit(t, repo)
+
+	// Answers like a daemon with nothing to report, noting what it was asked
+	// to load and when that file last changed, which is all it goes by to
+	// decide whether a loaded project is still current
+	type request struct {
+		entrypoint string
+		modified   time.Time
+	}
+	requests := make(chan request, 2)
+	socket := fakeDaemon(t, func(req rpcRequest) string {
+		params, _ := req.Params.(map[string]any)
+		entrypoint, _ := params["entrypoint"].(string)
+		pipeName, _ := params["pipe"].(string)
+		fi, err := os.Stat(entrypoint)
+		if err != nil {
+			return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"error":{"code":-32000,"message":%q}}`, req.ID, err.Error())
+		}
+		requests <- request{entrypoint, fi.ModTime()}
+		pipe, err := os.OpenFile(pipeName, os.O_WRONLY, 0)
+		if err == nil {
+			fmt.Fprintf(pipe, "{\"type\":\"hello\",\"protocol\":%d}\n{\"type\":\"done\"}\n", protocolVersion)
+			pipe.Close()
+		}
+		return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"result":{}}`, req.ID)
+	})
+	client, err := dialDaemon(socket)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	pipeName := filepath.Join(t.TempDir(), "pipe")
+	if err := syscall.Mkfifo(pipeName, 0600); err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	cfg := analyzerConfig{pipeName: pipeName, daemon: client}
+
+	var seen []request
+	for i := 0; i < 2; i++ {
+		if _, err := analyzeSnapshot(context.Background(), repo, dir, snapshot{commit: commit}, cfg, "HEAD", nil); err != nil {
+			t.Fatalf("run %d: unexpected error: %v", i+1, err)
+		}
+		seen = append(seen, <-requests)
+	}
+
+	// The daemon keys loaded projects by entrypoint and re-reads files whose
+	// modification time changed, so the second run finds the first's
+	// project loaded and nothing to re-read
+	if seen[0].entrypoint != seen[1].entrypoint {
+		t.Errorf("expected the same entrypoint twice, got %s and %s", seen[0].entrypoint, seen[1].entrypoint)
+	}
+	if !seen[0].modified.Equal(seen[1].modified) {
+		t.Error("expected the entrypoint not to be rewritten between runs")
+	}
+}
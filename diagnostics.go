@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// maxAnalyzerStderr bounds how much analyzer output is kept for error
+// messages. The end of the output is what explains a crash.
+const maxAnalyzerStderr = 64 << 10
+
+// tailBuffer is an io.Writer that keeps the last max bytes written to it.
+// The process writes to it from its own goroutine.
+type tailBuffer struct {
+	mu   sync.Mutex
+	max  int
+	data []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	if len(b.data) > b.max {
+		b.data = b.data[len(b.data)-b.max:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
+
+// AnalyzerError is returned when an analyzer run fails. It carries what the
+// analyzer printed so the cause, such as a bad tsconfig or a missing
+// module, is shown rather than just an exit status.
+type AnalyzerError struct {
+	Label  string
+	Err    error
+	Stderr string
+}
+
+func (e *AnalyzerError) Error() string {
+	msg := fmt.Sprintf("analyzer (%s): %v", e.Label, e.Err)
+	stderr := strings.TrimSpace(e.Stderr)
+	if stderr == "" {
+		return msg
+	}
+	return msg + "\nAnalyzer output:\n\t" + strings.ReplaceAll(stderr, "\n", "\n\t")
+}
+
+func (e *AnalyzerError) Unwrap() error {
+	return e.Err
+}
+
+// maxDiagnostics is how many unresolved symbols or skipped files are listed
+// without --verbose.
+const maxDiagnostics = 10
+
+// printDiagnostics summarizes the unresolved symbols and skipped files of
+// every analyzer run, each listed once. Without verbose long lists are cut
+// short; with it the other warnings were already shown as they arrived.
+func printDiagnostics(warnings []analyzerWarning, verbose bool) {
+	seen := make(map[analyzerWarning]bool)
+	var unresolved, skipped []string
+	for _, w := range warnings {
+		key := analyzerWarning{Kind: w.Kind, File: w.File, Symbol: w.Symbol}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		switch w.Kind {
+		case warnUnresolvedSymbol:
+			unresolved = append(unresolved, fmt.Sprintf("%s (%s)", w.Symbol, w.File))
+		case warnSkippedFile:
+			skipped = append(skipped, fmt.Sprintf("%s: %s", w.File, w.Message))
+		}
+	}
+
+	printDiagnosticList("Unresolved symbols", unresolved, verbose)
+	printDiagnosticList("Skipped files", skipped, verbose)
+}
+
+func printDiagnosticList(title string, items []string, verbose bool) {
+	if len(items) == 0 {
+		return
+	}
+	sort.Strings(items)
+	label := color.New(color.FgYellow, color.Bold)
+
+	fmt.Println()
+	label.Printf("%s (%d):\n", title, len(items))
+	shown := items
+	if !verbose && len(shown) > maxDiagnostics {
+		shown = shown[:maxDiagnostics]
+	}
+	for _, item := range shown {
+		fmt.Printf("\t%s\n", item)
+	}
+	if rest := len(items) - len(shown); rest > 0 {
+		fmt.Printf("\t... and %d more (use --verbose to list all)\n", rest)
+	}
+}
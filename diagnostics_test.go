@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 8}
+	b.Write([]byte("Error: "))
+	b.Write([]byte("Cannot find module"))
+	if got := b.String(); got != "d module" {
+		t.Errorf("expected the last 8 bytes, got %q", got)
+	}
+}
+
+func TestAnalyzerError(t *testing.T) {
+	cause := errors.New("exit status 1")
+	err := &AnalyzerError{
+		Label:  "head",
+		Err:    cause,
+		Stderr: "Error: Cannot find module '@nestjs/core'\n    at main.ts:1\n",
+	}
+
+	msg := err.Error()
+	if !strings.Contains(msg, "analyzer (head): exit status 1") {
+		t.Errorf("expected label and cause in %q", msg)
+	}
+	if !strings.Contains(msg, "\tError: Cannot find module '@nestjs/core'\n\t    at main.ts:1") {
+		t.Errorf("expected indented analyzer output in %q", msg)
+	}
+	if !errors.Is(err, cause) {
+		t.Error("expected AnalyzerError to unwrap to its cause")
+	}
+
+	bare := &AnalyzerError{Label: "base", Err: cause}
+	if strings.Contains(bare.Error(), "Analyzer output") {
+		t.Errorf("expected no output section without stderr, got %q", bare.Error())
+	}
+}
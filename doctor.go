@@ -0,0 +1,249 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/go-git/go-git/v5"
+)
+
+// checkStatus is the outcome of one pit doctor check.
+type checkStatus int
+
+const (
+	checkOK checkStatus = iota
+	checkWarn
+	checkFail
+)
+
+// check is one line of the pit doctor report.
+type check struct {
+	Status checkStatus
+	Detail string
+}
+
+// doctorSection is a titled group of checks.
+type doctorSection struct {
+	Title  string
+	Checks []check
+}
+
+func (s *doctorSection) add(status checkStatus, format string, args ...any) {
+	s.Checks = append(s.Checks, check{Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+func printDoctorUsage() {
+	fmt.Println("Usage: pit doctor [--runtime <runtime>] [path]")
+	fmt.Println("Checks everything pit needs to analyze the repository at path: JavaScript")
+	fmt.Println("runtimes, the analyzer cache, the git repository and the detected framework.")
+}
+
+func runDoctor(args []string) {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	runtimeName := flags.String("runtime", "", "JavaScript runtime to check for")
+	flags.Usage = printDoctorUsage
+	flags.Parse(args)
+
+	path := "."
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		path = flags.Arg(0)
+	default:
+		printDoctorUsage()
+		os.Exit(1)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		fmt.Printf("Error getting absolute path: %s\n", err)
+		os.Exit(1)
+	}
+	gitRoot, _ := findGitRoot(absPath)
+
+	sections := []doctorSection{
+		doctorRuntimes(*runtimeName, gitRoot),
+		doctorAnalyzer(),
+		doctorRepository(absPath),
+		doctorProject(gitRoot),
+	}
+
+	failed := false
+	for i, section := range sections {
+		if i > 0 {
+			fmt.Println()
+		}
+		printDoctorSection(section)
+		for _, c := range section.Checks {
+			failed = failed || c.Status == checkFail
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func doctorRuntimes(flagValue, gitRoot string) doctorSection {
+	section := doctorSection{Title: "JavaScript runtimes"}
+	for _, name := range runtimeNames {
+		rt, err := findRuntime(name)
+		if err != nil {
+			section.add(checkWarn, "%s: %v", name, err)
+			continue
+		}
+		section.add(checkOK, "%s %s (%s)", rt.Name, rt.Version, rt.Path)
+	}
+
+	rt, err := selectRuntime(flagValue, gitRoot)
+	if err != nil {
+		section.add(checkFail, "no runtime to analyze with: %v", err)
+	} else {
+		section.add(checkOK, "analyzing with %s, chosen by %s", rt.Name, rt.Source)
+	}
+	return section
+}
+
+func doctorAnalyzer() doctorSection {
+	section := doctorSection{Title: "Analyzer"}
+	section.add(checkOK, "version %s", analyzerVersion())
+
+	root, err := analyzerRoot()
+	if err != nil {
+		section.add(checkFail, "cannot prepare analyzer: %v", err)
+		return section
+	}
+	section.add(checkOK, "running from %s", root)
+	if _, err := os.Stat(filepath.Join(root, "node_modules")); err != nil {
+		section.add(checkWarn, "dependencies not installed yet; they are fetched on first analysis")
+	}
+
+	cacheRoot, err := analyzerCacheRoot()
+	if err != nil {
+		section.add(checkWarn, "%v", err)
+		return section
+	}
+	entries, _ := os.ReadDir(cacheRoot)
+	var stale []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "analyzer-") && e.Name() != "analyzer-"+analyzerVersion() {
+			stale = append(stale, e.Name())
+		}
+	}
+	if len(stale) > 0 {
+		section.add(checkWarn, "%d analyzer versions from older pit binaries in %s can be deleted", len(stale), cacheRoot)
+	}
+	return section
+}
+
+func doctorRepository(path string) doctorSection {
+	section := doctorSection{Title: "Repository"}
+	gitRoot, err := findGitRoot(path)
+	if err != nil {
+		section.add(checkFail, "%s is not inside a git repository", path)
+		return section
+	}
+	section.add(checkOK, "git root %s", gitRoot)
+
+	r, err := git.PlainOpen(gitRoot)
+	if err != nil {
+		section.add(checkFail, "cannot open repository: %v", err)
+		return section
+	}
+	head, err := r.Head()
+	if err != nil {
+		section.add(checkFail, "HEAD does not resolve: %v", err)
+		return section
+	}
+	if head.Name().IsBranch() {
+		section.add(checkOK, "HEAD is %s at %s", head.Name().Short(), shortHash(head.Hash()))
+	} else {
+		section.add(checkOK, "HEAD is detached at %s", shortHash(head.Hash()))
+	}
+
+	if shallow, err := r.Storer.Shallow(); err == nil && len(shallow) > 0 {
+		section.add(checkWarn, "shallow clone: history stops after %d boundary commits; fetch more for pit log and pit blame", len(shallow))
+	}
+	if commit, err := r.CommitObject(head.Hash()); err == nil && commit.NumParents() == 0 {
+		section.add(checkWarn, "HEAD has no parent, so the default HEAD^..HEAD comparison has nothing to compare")
+	}
+
+	if wt, err := r.Worktree(); err == nil {
+		if status, err := wt.Status(); err == nil && !status.IsClean() {
+			changed := 0
+			for _, s := range status {
+				if s.Worktree != git.Untracked {
+					changed++
+				}
+			}
+			if changed > 0 {
+				section.add(checkOK, "%d files with uncommitted changes; see pit --staged --unstaged", changed)
+			}
+		}
+	}
+
+	if results, err := openResultCache(r, gitRoot).list(); err == nil && len(results) > 0 {
+		var size int64
+		stale := 0
+		for _, res := range results {
+			size += res.Size
+			if res.Analyzer != analyzerVersion() {
+				stale++
+			}
+		}
+		section.add(checkOK, "%d cached analysis results (%s)", len(results), formatSize(size))
+		if stale > 0 {
+			section.add(checkWarn, "%d cached results are from older analyzers; pit cache prune removes them", stale)
+		}
+	}
+	return section
+}
+
+func doctorProject(gitRoot string) doctorSection {
+	section := doctorSection{Title: "Project"}
+	if gitRoot == "" {
+		section.add(checkFail, "no repository to look for a project in")
+		return section
+	}
+
+	mainPath, framework, err := DetectFramework(gitRoot)
+	switch {
+	case os.IsNotExist(err):
+		section.add(checkFail, "no package.json at %s", gitRoot)
+		return section
+	case err != nil || framework == Unknown:
+		section.add(checkFail, "no supported framework found in package.json")
+		return section
+	}
+	section.add(checkOK, "framework %s", framework)
+
+	if rel, err := filepath.Rel(gitRoot, mainPath); err == nil {
+		mainPath = rel
+	}
+	if _, err := os.Stat(filepath.Join(gitRoot, mainPath)); err != nil {
+		section.add(checkFail, "entrypoint %s does not exist", mainPath)
+	} else {
+		section.add(checkOK, "entrypoint %s", mainPath)
+	}
+	if _, err := os.Stat(filepath.Join(gitRoot, "node_modules")); err != nil {
+		section.add(checkWarn, "node_modules missing; install dependencies so imports from packages resolve")
+	}
+	return section
+}
+
+func printDoctorSection(section doctorSection) {
+	title := color.New(color.FgWhite, color.Bold)
+	marks := map[checkStatus]string{
+		checkOK:   color.GreenString("✓"),
+		checkWarn: color.YellowString("!"),
+		checkFail: color.RedString("✗"),
+	}
+
+	title.Printf("%s:\n", section.Title)
+	for _, c := range section.Checks {
+		fmt.Printf("\t%s %s\n", marks[c.Status], c.Detail)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// analyzerFS holds the TypeScript analyzer, so an installed binary does not
+// depend on a checkout of pit. package.json, tsconfig.json and deno.json
+// come along for the dependency versions and the import paths.
+//
+//go:embed ts_src package.json tsconfig.json deno.json
+var analyzerFS embed.FS
+
+// analyzerEntrypoint is the script pit runs, relative to the analyzer root.
+const analyzerEntrypoint = "ts_src/ffi/called.ts"
+
+// analyzerVersion identifies the embedded analyzer by its content, so a new
+// binary never reuses a cache extracted by an older one.
+var analyzerVersion = sync.OnceValue(func() string {
+	h := sha256.New()
+	fs.WalkDir(analyzerFS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := analyzerFS.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// WalkDir visits files in lexical order, so the hash is stable
+		fmt.Fprintf(h, "%s %d\n", path, len(data))
+		h.Write(data)
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil))[:12]
+})
+
+// completeMarker is written last when extracting, so a directory left
+// behind by an interrupted extraction is never used.
+const completeMarker = ".complete"
+
+// analyzerCacheRoot returns the directory under the user's cache directory
+// that extracted analyzers live in.
+func analyzerCacheRoot() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("finding cache directory: %w", err)
+	}
+	return filepath.Join(cache, "pit"), nil
+}
+
+// analyzerDir returns the directory the embedded analyzer has been extracted
+// to, extracting it on first use.
+func analyzerDir() (string, error) {
+	cacheRoot, err := analyzerCacheRoot()
+	if err != nil {
+		return "", err
+	}
+	return extractAnalyzer(cacheRoot)
+}
+
+// extractAnalyzer writes the embedded analyzer to cacheRoot/analyzer-<version>
+// unless it is already there. Files are written to a temporary directory
+// that is renamed into place, so concurrent first runs never see a partial
+// tree.
+func extractAnalyzer(cacheRoot string) (string, error) {
+	dir := filepath.Join(cacheRoot, "analyzer-"+analyzerVersion())
+	if _, err := os.Stat(filepath.Join(dir, completeMarker)); err == nil {
+		return dir, nil
+	}
+
+	if err := os.MkdirAll(cacheRoot, 0755); err != nil {
+		return "", fmt.Errorf("creating cache directory: %w", err)
+	}
+	tmp, err := os.MkdirTemp(cacheRoot, "extract-")
+	if err != nil {
+		return "", fmt.Errorf("creating cache directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	err = fs.WalkDir(analyzerFS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(tmp, filepath.FromSlash(path))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := analyzerFS.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+	if err != nil {
+		return "", fmt.Errorf("extracting analyzer: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, completeMarker), nil, 0644); err != nil {
+		return "", fmt.Errorf("extracting analyzer: %w", err)
+	}
+
+	if err := os.Rename(tmp, dir); err != nil {
+		// Another run may have got there first, or left an incomplete tree
+		if _, statErr := os.Stat(filepath.Join(dir, completeMarker)); statErr == nil {
+			return dir, nil
+		}
+		os.RemoveAll(dir)
+		if err := os.Rename(tmp, dir); err != nil {
+			return "", fmt.Errorf("installing analyzer: %w", err)
+		}
+	}
+	return dir, nil
+}
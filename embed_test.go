@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractAnalyzer(t *testing.T) {
+	cache := t.TempDir()
+	dir, err := extractAnalyzer(cache)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if filepath.Base(dir) != "analyzer-"+analyzerVersion() {
+		t.Errorf("expected a versioned directory, got %s", dir)
+	}
+	for _, name := range []string{analyzerEntrypoint, "package.json", "tsconfig.json", completeMarker} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be extracted: %v", name, err)
+		}
+	}
+
+	// A second run reuses the tree, leaving nothing else behind
+	marker := filepath.Join(dir, "ts_src", "marker")
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		t.Fatalf("failed to write marker: %v", err)
+	}
+	again, err := extractAnalyzer(cache)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again != dir {
+		t.Errorf("expected %s again, got %s", dir, again)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Error("expected the existing tree to be reused")
+	}
+	entries, err := os.ReadDir(cache)
+	if err != nil {
+		t.Fatalf("failed to read cache: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the analyzer directory in the cache, got %d entries", len(entries))
+	}
+}
+
+func TestExtractAnalyzer_Incomplete(t *testing.T) {
+	cache := t.TempDir()
+	// An extraction interrupted before the marker was written
+	partial := filepath.Join(cache, "analyzer-"+analyzerVersion())
+	if err := os.MkdirAll(partial, 0755); err != nil {
+		t.Fatalf("failed to create partial tree: %v", err)
+	}
+
+	dir, err := extractAnalyzer(cache)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, analyzerEntrypoint)); err != nil {
+		t.Errorf("expected the partial tree to be replaced: %v", err)
+	}
+}
@@ -27,6 +27,7 @@ const (
 var ErrFrameworkNotFound = errors.New("unable to determine framework type")
 
 type PackageJSON struct {
+	Main            string            `json:"main"`
 	Dependencies    map[string]string `json:"dependencies"`
 	DevDependencies map[string]string `json:"devDependencies"`
 }
@@ -60,11 +61,13 @@ func DetectFramework(absPath string) (string, FrameworkType, error) {
 		}
 	}
 
-	// Check for AdonisJS
+	// Check for AdonisJS, whose routes are declared in start/routes.ts
 	if hasDependency("@adonisjs/core") {
-		mainPath := filepath.Join(absPath, "start", "app.ts")
-		if _, err := os.Stat(mainPath); err == nil {
-			return mainPath, Adonis, nil
+		for _, name := range []string{"routes.ts", "app.ts"} {
+			mainPath := filepath.Join(absPath, "start", name)
+			if _, err := os.Stat(mainPath); err == nil {
+				return mainPath, Adonis, nil
+			}
 		}
 	}
 
@@ -99,30 +102,26 @@ func DetectFramework(absPath string) (string, FrameworkType, error) {
 	}
 
 	// Check for Hapi
-	if hasDependency("@hapi/hapi") {
-		mainPath := filepath.Join(absPath, "server.js")
-		if _, err := os.Stat(mainPath); err == nil {
-			return mainPath, Hapi, nil
+	if hasDependency("@hapi/hapi") || hasDependency("hapi") {
+		for _, path := range entrypointCandidates(absPath, pkg) {
+			if _, err := os.Stat(path); err == nil {
+				return path, Hapi, nil
+			}
 		}
 	}
 
 	// Check for Koa
 	if hasDependency("koa") {
-		mainPath := filepath.Join(absPath, "app.js")
-		if _, err := os.Stat(mainPath); err == nil {
-			return mainPath, Koa, nil
+		for _, path := range entrypointCandidates(absPath, pkg) {
+			if _, err := os.Stat(path); err == nil {
+				return path, Koa, nil
+			}
 		}
 	}
 
 	// Check for Fastify
 	if hasDependency("fastify") {
-		possiblePaths := []string{
-			filepath.Join(absPath, "app.js"),
-			filepath.Join(absPath, "server.js"),
-			filepath.Join(absPath, "index.js"),
-		}
-
-		for _, path := range possiblePaths {
+		for _, path := range entrypointCandidates(absPath, pkg) {
 			if _, err := os.Stat(path); err == nil {
 				return path, Fastify, nil
 			}
@@ -131,13 +130,7 @@ func DetectFramework(absPath string) (string, FrameworkType, error) {
 
 	// Check for Express (fallback)
 	if hasDependency("express") {
-		possiblePaths := []string{
-			filepath.Join(absPath, "app.js"),
-			filepath.Join(absPath, "server.js"),
-			filepath.Join(absPath, "index.js"),
-		}
-
-		for _, path := range possiblePaths {
+		for _, path := range entrypointCandidates(absPath, pkg) {
 			if _, err := os.Stat(path); err == nil {
 				return path, Express, nil
 			}
@@ -147,6 +140,22 @@ func DetectFramework(absPath string) (string, FrameworkType, error) {
 	return "", Unknown, ErrFrameworkNotFound
 }
 
+// entrypointCandidates lists where the entrypoint of a project that
+// registers routes in code may be: the file package.json names as main, then
+// the usual names at the root and under src, in JavaScript or TypeScript.
+func entrypointCandidates(absPath string, pkg PackageJSON) []string {
+	var paths []string
+	if pkg.Main != "" {
+		paths = append(paths, filepath.Join(absPath, pkg.Main))
+	}
+	for _, dir := range []string{absPath, filepath.Join(absPath, "src")} {
+		for _, name := range []string{"app", "server", "index", "main"} {
+			paths = append(paths, filepath.Join(dir, name+".js"), filepath.Join(dir, name+".ts"))
+		}
+	}
+	return paths
+}
+
 func (f FrameworkType) String() string {
 	return [...]string{
 		"Unknown",
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectFramework(t *testing.T) {
+	tests := []struct {
+		name      string
+		pkg       string
+		files     []string
+		main      string
+		framework FrameworkType
+	}{
+		{"nestjs", `{"dependencies":{"@nestjs/core":"^10"}}`, []string{"src/main.ts"}, "src/main.ts", NestJS},
+		{"express app.js", `{"dependencies":{"express":"^4"}}`, []string{"app.js"}, "app.js", Express},
+		{"express in src", `{"dependencies":{"express":"^4"}}`, []string{"src/server.ts"}, "src/server.ts", Express},
+		{"express main", `{"main":"lib/start.js","dependencies":{"express":"^4"}}`, []string{"lib/start.js", "index.js"}, "lib/start.js", Express},
+		{"fastify", `{"dependencies":{"fastify":"^4","express":"^4"}}`, []string{"src/app.ts"}, "src/app.ts", Fastify},
+		{"koa", `{"main":"server.js","dependencies":{"koa":"^2","@koa/router":"^12"}}`, []string{"server.js", "app.js"}, "server.js", Koa},
+		{"hapi", `{"dependencies":{"@hapi/hapi":"^21"}}`, []string{"server.js"}, "server.js", Hapi},
+		{"legacy hapi", `{"dependencies":{"hapi":"^18"}}`, []string{"src/index.ts"}, "src/index.ts", Hapi},
+		{"adonis", `{"dependencies":{"@adonisjs/core":"^6"}}`, []string{"start/app.ts", "start/routes.ts"}, "start/routes.ts", Adonis},
+		{"adonis without routes", `{"dependencies":{"@adonisjs/core":"^6"}}`, []string{"start/app.ts"}, "start/app.ts", Adonis},
+		{"express missing main", `{"main":"dist/index.js","dependencies":{"express":"^4"}}`, []string{"src/index.ts"}, "src/index.ts", Express},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "package.json", tt.pkg)
+			for _, f := range tt.files {
+				os.MkdirAll(filepath.Join(dir, filepath.Dir(f)), 0755)
+				writeFile(t, dir, f, "")
+			}
+
+			mainPath, framework, err := DetectFramework(dir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if framework != tt.framework || mainPath != filepath.Join(dir, tt.main) {
+				t.Errorf("expected %s at %s, got %s at %s", tt.framework, tt.main, framework, mainPath)
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	writeFile(t, dir, "package.json", `{"dependencies":{"express":"^4"}}`)
+	if _, _, err := DetectFramework(dir); err != ErrFrameworkNotFound {
+		t.Errorf("expected ErrFrameworkNotFound without an entrypoint, got %v", err)
+	}
+}
+
+func TestAnalyzerFramework(t *testing.T) {
+	if got := analyzerFramework(NestJS); got != "nestjs" {
+		t.Errorf("expected nestjs, got %s", got)
+	}
+	if got := analyzerFramework(Express); got != "express" {
+		t.Errorf("expected express, got %s", got)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// A tree that is not cached is usually close to one that is: its parent, or
+// the commit pit analyzed just before. Endpoints whose call graphs depend on
+// none of the files changed between the two are taken from the cached
+// result, and the analyzer only builds call graphs for the rest.
+
+// incrementalSearchDepth is how many first-parent ancestors are searched
+// for a cached tree to start from.
+const incrementalSearchDepth = 20
+
+// analyzeCommit analyzes the tree of commit, reusing what it can from the
+// closest tree analyzed before.
+func (a *treeAnalyzer) analyzeCommit(ctx context.Context, commit *object.Commit, label string) (*analyzerOutput, error) {
+	base, baseTree := a.findBase(commit)
+	var reuse []string
+	if base != nil {
+		changed, err := changedPaths(a.repo, baseTree, commit.TreeHash)
+		if err == nil {
+			reuse = reusableEndpoints(base, changed)
+		}
+		if a.cfg.verbose && len(reuse) > 0 {
+			fmt.Fprintf(os.Stderr, "Reusing %d of %d endpoints from tree %s for %s, %d files changed\n",
+				len(reuse), len(base.Routes), shortHash(baseTree), label, len(changed))
+		}
+	}
+
+	output, err := analyzeSnapshot(ctx, a.repo, a.gitRoot, snapshot{commit: commit}, a.cfg, label, reuse)
+	if err != nil {
+		return nil, err
+	}
+	mergeReused(output, base, reuse)
+	return output, nil
+}
+
+// findBase returns the result to build on for commit and the tree it is
+// for: the tree analyzed last in this run, else the nearest first-parent
+// ancestor with a cached result. Results from before dependencies were
+// recorded cannot be built on.
+func (a *treeAnalyzer) findBase(commit *object.Commit) (*analyzerOutput, plumbing.Hash) {
+	if output, ok := a.trees[a.last]; ok && output.Dependencies != nil {
+		return output, a.last
+	}
+	c := commit
+	for i := 0; i < incrementalSearchDepth && c.NumParents() > 0; i++ {
+		parent, err := c.Parent(0)
+		if err != nil {
+			break
+		}
+		if output, ok := a.trees[parent.TreeHash]; ok && output.Dependencies != nil {
+			return output, parent.TreeHash
+		}
+		if output, ok := a.cache.get(parent.TreeHash); ok && output.Dependencies != nil {
+			return output, parent.TreeHash
+		}
+		c = parent
+	}
+	return nil, plumbing.ZeroHash
+}
+
+// changedPaths returns the paths whose content differs between two trees,
+// with both sides of a rename.
+func changedPaths(r *git.Repository, from, to plumbing.Hash) ([]string, error) {
+	fromTree, err := r.TreeObject(from)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tree %s: %w", from, err)
+	}
+	toTree, err := r.TreeObject(to)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tree %s: %w", to, err)
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, fmt.Errorf("diffing trees: %w", err)
+	}
+
+	var paths []string
+	for _, c := range changes {
+		if c.From.Name != "" {
+			paths = append(paths, c.From.Name)
+		}
+		if c.To.Name != "" && c.To.Name != c.From.Name {
+			paths = append(paths, c.To.Name)
+		}
+	}
+	return paths, nil
+}
+
+// affectsWholeProject reports whether a change to path can change how every
+// file is resolved, such as compiler options or installed packages.
+func affectsWholeProject(p string) bool {
+	name := path.Base(p)
+	switch name {
+	case "package.json", "jsconfig.json", "deno.json":
+		return true
+	}
+	return strings.HasPrefix(name, "tsconfig") && strings.HasSuffix(name, ".json")
+}
+
+// reusableEndpoints returns the endpoints of base whose call graphs depend
+// on none of the changed paths.
+func reusableEndpoints(base *analyzerOutput, changed []string) []string {
+	if base.Dependencies == nil {
+		return nil
+	}
+	dependents := reverseDependencies(base.Dependencies)
+	stale := make(map[string]bool)
+	for _, p := range changed {
+		if affectsWholeProject(p) {
+			return nil
+		}
+		for _, endpoint := range dependents[p] {
+			stale[endpoint] = true
+		}
+	}
+
+	var reuse []string
+	seen := make(map[string]bool)
+	for _, route := range base.Routes {
+		if _, ok := base.Dependencies[route]; !ok || stale[route] || seen[route] {
+			continue
+		}
+		seen[route] = true
+		reuse = append(reuse, route)
+	}
+	return reuse
+}
+
+// mergeReused adds the call graphs, warnings and dependencies of reused
+// endpoints from base to output. Reused endpoints the analyzer no longer
+// found are left out.
+func mergeReused(output, base *analyzerOutput, reuse []string) {
+	if len(reuse) == 0 {
+		return
+	}
+	reused := make(map[string]bool, len(reuse))
+	for _, endpoint := range reuse {
+		reused[endpoint] = true
+	}
+	present := make(map[string]bool)
+	for _, route := range output.Routes {
+		if reused[route] {
+			present[route] = true
+		}
+	}
+
+	for _, fn := range base.Functions {
+		if present[fn.ControllerName] {
+			output.Functions = append(output.Functions, fn)
+		}
+	}
+	for _, w := range base.Warnings {
+		if present[w.Endpoint] {
+			output.Warnings = append(output.Warnings, w)
+		}
+	}
+	if output.Dependencies == nil && len(present) > 0 {
+		output.Dependencies = make(map[string][]string)
+	}
+	for endpoint := range present {
+		output.Dependencies[endpoint] = base.Dependencies[endpoint]
+	}
+}
+
+// reverseDependencies maps each file to the endpoints whose call graphs
+// depend on it.
+func reverseDependencies(dependencies map[string][]string) map[string][]string {
+	if dependencies == nil {
+		return nil
+	}
+	dependents := make(map[string][]string)
+	for endpoint, files := range dependencies {
+		for _, file := range files {
+			dependents[file] = append(dependents[file], endpoint)
+		}
+	}
+	for file, endpoints := range dependents {
+		dependents[file] = sortedUnique(endpoints)
+	}
+	return dependents
+}
+
+// forwardDependencies inverts reverseDependencies.
+func forwardDependencies(dependents map[string][]string) map[string][]string {
+	if dependents == nil {
+		return nil
+	}
+	dependencies := make(map[string][]string)
+	for file, endpoints := range dependents {
+		for _, endpoint := range endpoints {
+			dependencies[endpoint] = append(dependencies[endpoint], file)
+		}
+	}
+	for endpoint, files := range dependencies {
+		dependencies[endpoint] = sortedUnique(files)
+	}
+	return dependencies
+}
+
+func sortedUnique(values []string) []string {
+	sort.Strings(values)
+	unique := values[:0]
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// writeReuseList writes the endpoints the analyzer should not analyze again
+// next to the pipe, returning its path, or nothing if there are none.
+func writeReuseList(pipeName string, reuse []string) (string, error) {
+	if len(reuse) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(reuse)
+	if err != nil {
+		return "", err
+	}
+	reusePath := filepath.Join(filepath.Dir(pipeName), "reuse.json")
+	if err := os.WriteFile(reusePath, data, 0600); err != nil {
+		return "", fmt.Errorf("writing reuse list: %w", err)
+	}
+	return reusePath, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func incrementalBase() *analyzerOutput {
+	return &analyzerOutput{
+		Routes: []string{"GET /users", "POST /users", "GET /health"},
+		Functions: []FunctionRange{
+			{ControllerName: "GET /users", FunctionName: "findAll", Filename: "src/users.controller.ts", StartLine: 10, EndLine: 14},
+			{ControllerName: "GET /users", FunctionName: "list", Filename: "src/users.service.ts", StartLine: 5, EndLine: 9},
+			{ControllerName: "POST /users", FunctionName: "create", Filename: "src/users.controller.ts", StartLine: 16, EndLine: 20},
+			{ControllerName: "GET /health", FunctionName: "check", Filename: "src/health.controller.ts", StartLine: 3, EndLine: 5},
+		},
+		Warnings: []analyzerWarning{
+			{Kind: warnUnresolvedSymbol, Symbol: "this.db.ping", Endpoint: "GET /health"},
+		},
+		Dependencies: map[string][]string{
+			"GET /users":  {"src/users.controller.ts", "src/users.service.ts"},
+			"POST /users": {"src/users.controller.ts"},
+			"GET /health": {"src/health.controller.ts", "src/db.ts"},
+		},
+	}
+}
+
+func TestReusableEndpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		changed []string
+		expect  []string
+	}{
+		{"unrelated file", []string{"README.md"}, []string{"GET /users", "POST /users", "GET /health"}},
+		{"service", []string{"src/users.service.ts"}, []string{"POST /users", "GET /health"}},
+		{"import of a call graph file", []string{"src/db.ts"}, []string{"GET /users", "POST /users"}},
+		{"shared controller", []string{"src/users.controller.ts", "src/health.controller.ts"}, nil},
+		{"compiler options", []string{"tsconfig.build.json"}, nil},
+		{"nested package.json", []string{"packages/api/package.json"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := reusableEndpoints(incrementalBase(), tt.changed)
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("expected %v, got %v", tt.expect, got)
+			}
+		})
+	}
+
+	// Results cached before dependencies were recorded are never reused
+	base := incrementalBase()
+	base.Dependencies = nil
+	if got := reusableEndpoints(base, []string{"README.md"}); got != nil {
+		t.Errorf("expected nothing reusable without dependencies, got %v", got)
+	}
+}
+
+func TestMergeReused(t *testing.T) {
+	base := incrementalBase()
+	// The service changed, so only GET /users was analyzed again; GET /health
+	// has since been removed from the project
+	output := &analyzerOutput{
+		Routes: []string{"GET /users", "POST /users"},
+		Functions: []FunctionRange{
+			{ControllerName: "GET /users", FunctionName: "findAll", Filename: "src/users.controller.ts", StartLine: 10, EndLine: 14},
+			{ControllerName: "GET /users", FunctionName: "list", Filename: "src/users.service.ts", StartLine: 5, EndLine: 12},
+		},
+		Dependencies: map[string][]string{
+			"GET /users": {"src/users.controller.ts", "src/users.service.ts"},
+		},
+	}
+	mergeReused(output, base, []string{"POST /users", "GET /health"})
+
+	var names []string
+	for _, fn := range output.Functions {
+		names = append(names, fn.ControllerName+" "+fn.FunctionName)
+	}
+	sort.Strings(names)
+	expect := []string{"GET /users findAll", "GET /users list", "POST /users create"}
+	if !reflect.DeepEqual(names, expect) {
+		t.Errorf("expected functions %v, got %v", expect, names)
+	}
+	if len(output.Warnings) != 0 {
+		t.Errorf("expected the removed endpoint's warnings to be dropped, got %+v", output.Warnings)
+	}
+	if _, ok := output.Dependencies["POST /users"]; !ok {
+		t.Error("expected the reused endpoint's dependencies to carry over")
+	}
+	if _, ok := output.Dependencies["GET /health"]; ok {
+		t.Error("expected no dependencies for the removed endpoint")
+	}
+}
+
+func TestDependencyMapsRoundTrip(t *testing.T) {
+	deps := incrementalBase().Dependencies
+	dependents := reverseDependencies(deps)
+	if got := dependents["src/users.controller.ts"]; !reflect.DeepEqual(got, []string{"GET /users", "POST /users"}) {
+		t.Errorf("unexpected dependents of the controller: %v", got)
+	}
+	back := forwardDependencies(dependents)
+	for endpoint, files := range deps {
+		want := append([]string(nil), files...)
+		sort.Strings(want)
+		if !reflect.DeepEqual(back[endpoint], want) {
+			t.Errorf("%s: expected %v, got %v", endpoint, want, back[endpoint])
+		}
+	}
+}
+
+func TestChangedPaths(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	writeFile(t, dir, "a.ts", "one\n")
+	writeFile(t, dir, "b.ts", "two\n")
+	commitAll(t, wt, "first")
+	first := headCommit(t, repo)
+
+	writeFile(t, dir, "a.ts", "one changed\n")
+	writeFile(t, dir, "c.ts", "three\n")
+	commitAll(t, wt, "second")
+	second := headCommit(t, repo)
+
+	paths, err := changedPaths(repo, first.TreeHash, second.TreeHash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(paths)
+	if !reflect.DeepEqual(paths, []string{"a.ts", "c.ts"}) {
+		t.Errorf("expected a.ts and c.ts, got %v", paths)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import "sort"
+
+// functionIndex finds the functions overlapping a range of lines in a file
+// without scanning every FunctionRange. The analyzer emits one range per
+// call site per endpoint, so a linear scan per chunk is quadratic on large
+// services.
+type functionIndex struct {
+	files map[string]*intervalTree
+}
+
+// intervalTree is a static augmented interval tree. The ranges are sorted by
+// StartLine and the tree is implicit: the root of ranges[lo:hi] is the middle
+// element, and maxEnd holds the largest EndLine within each subtree, which
+// lets a query skip subtrees that end before the lines being looked up.
+type intervalTree struct {
+	ranges []FunctionRange
+	maxEnd []int
+}
+
+func newFunctionIndex(functions []FunctionRange) *functionIndex {
+	byFile := make(map[string][]FunctionRange)
+	for _, fn := range functions {
+		byFile[fn.Filename] = append(byFile[fn.Filename], fn)
+	}
+
+	idx := &functionIndex{files: make(map[string]*intervalTree, len(byFile))}
+	for file, ranges := range byFile {
+		idx.files[file] = newIntervalTree(ranges)
+	}
+	return idx
+}
+
+func newIntervalTree(ranges []FunctionRange) *intervalTree {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].StartLine < ranges[j].StartLine
+	})
+	t := &intervalTree{ranges: ranges, maxEnd: make([]int, len(ranges))}
+	t.build(0, len(ranges))
+	return t
+}
+
+// build fills maxEnd for the subtree over ranges[lo:hi] and returns it.
+func (t *intervalTree) build(lo, hi int) int {
+	if lo >= hi {
+		return -1
+	}
+	mid := (lo + hi) / 2
+	maxEnd := t.ranges[mid].EndLine
+	if left := t.build(lo, mid); left > maxEnd {
+		maxEnd = left
+	}
+	if right := t.build(mid+1, hi); right > maxEnd {
+		maxEnd = right
+	}
+	t.maxEnd[mid] = maxEnd
+	return maxEnd
+}
+
+// overlapping returns the endpoints of every function in filename that
+// shares at least one line with start..end, in StartLine order. An endpoint
+// appears once per overlapping range.
+func (idx *functionIndex) overlapping(filename string, start, end int) []string {
+	t, ok := idx.files[filename]
+	if !ok {
+		return nil
+	}
+	var endpoints []string
+	t.query(0, len(t.ranges), start, end, func(fn FunctionRange) {
+		endpoints = append(endpoints, fn.ControllerName)
+	})
+	return endpoints
+}
+
+// inFile returns every range in filename.
+func (idx *functionIndex) inFile(filename string) []FunctionRange {
+	if t, ok := idx.files[filename]; ok {
+		return t.ranges
+	}
+	return nil
+}
+
+func (t *intervalTree) query(lo, hi, start, end int, visit func(FunctionRange)) {
+	if lo >= hi {
+		return
+	}
+	mid := (lo + hi) / 2
+	// Nothing in this subtree reaches start
+	if t.maxEnd[mid] < start {
+		return
+	}
+	t.query(lo, mid, start, end, visit)
+	// Everything from mid onwards starts after end
+	if t.ranges[mid].StartLine > end {
+		return
+	}
+	if t.ranges[mid].EndLine >= start {
+		visit(t.ranges[mid])
+	}
+	t.query(mid+1, hi, start, end, visit)
+}
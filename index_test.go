@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// overlapsLinear is the straightforward scan the index replaces.
+func overlapsLinear(functions []FunctionRange, filename string, start, end int) []string {
+	var endpoints []string
+	for _, fn := range functions {
+		if fn.Filename == filename && fn.StartLine <= end && start <= fn.EndLine {
+			endpoints = append(endpoints, fn.ControllerName)
+		}
+	}
+	return endpoints
+}
+
+// syntheticFunctions builds ranges shaped like analyzer output: many
+// endpoints sharing the same helper functions across a set of files.
+func syntheticFunctions(endpoints, files, perEndpoint int) []FunctionRange {
+	rng := rand.New(rand.NewSource(1))
+	var functions []FunctionRange
+	for e := 0; e < endpoints; e++ {
+		for i := 0; i < perEndpoint; i++ {
+			start := rng.Intn(2000) + 1
+			functions = append(functions, FunctionRange{
+				ControllerName: fmt.Sprintf("GET /endpoint/%d", e),
+				Filename:       fmt.Sprintf("src/module%d/service.ts", rng.Intn(files)),
+				StartLine:      start,
+				EndLine:        start + rng.Intn(80),
+			})
+		}
+	}
+	return functions
+}
+
+func TestFunctionIndex_MatchesLinearScan(t *testing.T) {
+	functions := syntheticFunctions(50, 5, 20)
+	idx := newFunctionIndex(append([]FunctionRange(nil), functions...))
+
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 1000; i++ {
+		file := fmt.Sprintf("src/module%d/service.ts", rng.Intn(6))
+		start := rng.Intn(2100)
+		end := start + rng.Intn(30)
+
+		got := idx.overlapping(file, start, end)
+		want := overlapsLinear(functions, file, start, end)
+		sort.Strings(got)
+		sort.Strings(want)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s:%d-%d: index returned %v, linear scan %v", file, start, end, got, want)
+		}
+	}
+}
+
+func TestFunctionIndex_ExactPath(t *testing.T) {
+	idx := newFunctionIndex([]FunctionRange{
+		{ControllerName: "GET users", Filename: "src/user.service.ts", StartLine: 1, EndLine: 10},
+		{ControllerName: "GET admin", Filename: "src/admin/user.service.ts", StartLine: 1, EndLine: 10},
+		{ControllerName: "GET backup", Filename: "src/user.service.ts.bak", StartLine: 1, EndLine: 10},
+	})
+
+	got := idx.overlapping("src/user.service.ts", 5, 5)
+	if len(got) != 1 || got[0] != "GET users" {
+		t.Errorf("expected only GET users, got %v", got)
+	}
+}
+
+func TestFunctionIndex_Boundaries(t *testing.T) {
+	idx := newFunctionIndex([]FunctionRange{
+		{ControllerName: "A", Filename: "a.ts", StartLine: 10, EndLine: 20},
+	})
+
+	tests := []struct {
+		start, end int
+		want       bool
+	}{
+		{1, 9, false},
+		{1, 10, true},
+		{20, 30, true},
+		{21, 30, false},
+		{12, 14, true},
+		{5, 25, true},
+	}
+	for _, tt := range tests {
+		got := len(idx.overlapping("a.ts", tt.start, tt.end)) > 0
+		if got != tt.want {
+			t.Errorf("lines %d-%d: expected overlap %v, got %v", tt.start, tt.end, tt.want, got)
+		}
+	}
+}
+
+// BenchmarkFunctionIndex looks up chunks against a 600 endpoint service to
+// keep lookups logarithmic.
+func BenchmarkFunctionIndex(b *testing.B) {
+	functions := syntheticFunctions(600, 200, 40)
+	idx := newFunctionIndex(functions)
+
+	rng := rand.New(rand.NewSource(3))
+	queries := make([][2]int, 1024)
+	files := make([]string, len(queries))
+	for i := range queries {
+		start := rng.Intn(2000)
+		queries[i] = [2]int{start, start + rng.Intn(10)}
+		files[i] = fmt.Sprintf("src/module%d/service.ts", rng.Intn(200))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q := i % len(queries)
+		idx.overlapping(files[q], queries[q][0], queries[q][1])
+	}
+}
+
+func BenchmarkNewFunctionIndex(b *testing.B) {
+	functions := syntheticFunctions(600, 200, 40)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newFunctionIndex(functions)
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// LogOptions controls which commits pit log reports on.
+type LogOptions struct {
+	Path        string
+	BaseRef     string // empty to walk back to the root commit
+	HeadRef     string
+	MaxCount    int           // 0 for no limit
+	Endpoint    string        // only report commits that changed this endpoint
+	Since       time.Time     // zero for no limit
+	FirstParent bool          // follow only the first parent of merges
+	Verbose     bool          // stream analyzer output as it runs
+	Timeout     time.Duration // give up on analysis after this long, 0 for no limit
+	Runtime     string        // JavaScript runtime to analyze with, empty to pick one
+	Daemon      bool          // analyze through the long-lived analyzer daemon
+}
+
+func printLogUsage() {
+	fmt.Println("Usage: pit log [flags] [path] [<base>..<head> | <head>]")
+	fmt.Println("Reports, for every commit reachable from head, which endpoints each commit")
+	fmt.Println("added to or removed from.")
+	fmt.Println("Flags:")
+	fmt.Println("  -n <count>              Limit the number of commits shown")
+	fmt.Println("  --endpoint <endpoint>   Only show commits that changed this endpoint's call graph")
+	fmt.Println("  --since <when>          Stop at commits older than a date (2006-01-02) or age (36h, 14d, 2w)")
+	fmt.Println("  --first-parent          Follow only the first parent of merges, showing each merge")
+	fmt.Println("                          as one change")
+	fmt.Println("  --verbose               Stream analyzer output and warnings as they arrive")
+	fmt.Println("  --timeout <duration>    Give up on analysis after this long (e.g. 10m), exiting with 124")
+	fmt.Println("  --runtime <runtime>     Run the analyzer with bun, node or deno")
+	fmt.Println("  --daemon                Analyze through the analyzer daemon, starting it if needed")
+	fmt.Println("Examples:")
+	fmt.Println("  pit log                      # Every commit reachable from HEAD")
+	fmt.Println("  pit log v1.0..v2.0           # Commits between two releases")
+	fmt.Println("  pit log -n 5 /path/to/repo   # The last five commits of a repository")
+	fmt.Println("  pit log --endpoint 'GET /users/:id' --since 2w")
+}
+
+func validateLogArgs(args []string) LogOptions {
+	opts := LogOptions{HeadRef: "HEAD"}
+
+	flags := flag.NewFlagSet("log", flag.ExitOnError)
+	flags.IntVar(&opts.MaxCount, "n", 0, "limit the number of commits shown")
+	flags.StringVar(&opts.Endpoint, "endpoint", "", "only show commits that changed this endpoint")
+	since := flags.String("since", "", "stop at commits older than this date or age")
+	flags.BoolVar(&opts.FirstParent, "first-parent", false, "follow only the first parent of merges")
+	flags.BoolVar(&opts.Verbose, "verbose", false, "stream analyzer output as it runs")
+	flags.DurationVar(&opts.Timeout, "timeout", 0, "give up on analysis after this long")
+	flags.StringVar(&opts.Runtime, "runtime", "", "JavaScript runtime to analyze with (bun, node or deno)")
+	flags.BoolVar(&opts.Daemon, "daemon", false, "analyze through the analyzer daemon")
+	flags.Usage = printLogUsage
+	flags.Parse(args)
+	args = flags.Args()
+
+	if *since != "" {
+		t, err := parseSince(*since, time.Now())
+		if err != nil {
+			fmt.Printf("Error parsing --since: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Since = t
+	}
+
+	// A lone argument is a path if it is a directory, otherwise a range
+	if len(args) == 1 {
+		if fi, err := os.Stat(args[0]); err == nil && fi.IsDir() {
+			args = append(args, "HEAD")
+		} else {
+			args = []string{".", args[0]}
+		}
+	}
+
+	switch len(args) {
+	case 0:
+		opts.Path = "."
+	case 2:
+		opts.Path = args[0]
+		if base, head, _, ok := splitRange(args[1]); ok {
+			// base...head is read as base..head: the commits head
+			// adds on top of base
+			opts.BaseRef = base
+			opts.HeadRef = head
+		} else {
+			opts.HeadRef = args[1]
+		}
+	default:
+		printLogUsage()
+		os.Exit(1)
+	}
+
+	return opts
+}
+
+func runLog(args []string) {
+	opts := validateLogArgs(args)
+	gitRoot := setupGitRoot(opts.Path)
+
+	r, err := git.PlainOpen(gitRoot)
+	if err != nil {
+		fmt.Printf("Error opening repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	commits, err := logCommits(r, opts)
+	if err != nil {
+		fmt.Printf("Error walking history: %v\n", err)
+		os.Exit(1)
+	}
+
+	rt := setupRuntime(opts.Runtime, gitRoot)
+	pipeName := setupPipe()
+	ctx, cancel := setupSignalHandler(opts.Timeout)
+	defer cancel()
+	defer runCleanups()
+	daemon := setupDaemon(daemonEnabled(opts.Daemon, gitRoot), rt)
+
+	analyzer := newTreeAnalyzer(r, gitRoot, analyzerConfig{pipeName: pipeName, runtime: rt, daemon: daemon, verbose: opts.Verbose})
+	shown := 0
+	for _, commit := range commits {
+		if opts.MaxCount > 0 && shown == opts.MaxCount {
+			break
+		}
+		result, err := commitEndpoints(ctx, analyzer, commit, opts.FirstParent)
+		if err != nil {
+			fmt.Printf("Error analyzing %s: %v\n", shortHash(commit.Hash), err)
+			exit(exitStatus(err))
+		}
+		if opts.Endpoint != "" {
+			result.Added = filterEndpoint(result.Added, opts.Endpoint)
+			result.Removed = filterEndpoint(result.Removed, opts.Endpoint)
+			result.Renames = nil
+			if len(result.Added) == 0 && len(result.Removed) == 0 {
+				continue
+			}
+		}
+
+		if shown > 0 {
+			fmt.Println()
+		}
+		printCommitHeader(commit)
+		printImpact(result, shortHash(commit.Hash))
+		shown++
+	}
+
+	if shown == 0 && opts.Endpoint != "" {
+		fmt.Printf("No commits changed %s\n", opts.Endpoint)
+	}
+	printDiagnostics(analyzer.warnings, opts.Verbose)
+}
+
+// filterEndpoint keeps the entries of endpoints that name endpoint.
+func filterEndpoint(endpoints []string, endpoint string) []string {
+	want := normalizeEndpoint(endpoint)
+	var matched []string
+	for _, e := range endpoints {
+		if normalizeEndpoint(e) == want {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// normalizeEndpoint canonicalizes "METHOD /path" so that "get users/:id/"
+// and "GET /users/:id" compare equal. The analyzer does not always emit a
+// leading slash.
+func normalizeEndpoint(endpoint string) string {
+	method, path, ok := strings.Cut(strings.TrimSpace(endpoint), " ")
+	if !ok {
+		path, method = method, ""
+	}
+	path = "/" + strings.Trim(strings.TrimSpace(path), "/")
+	return strings.TrimSpace(strings.ToUpper(method) + " " + path)
+}
+
+// parseSince parses a --since value: a date (2006-01-02), an RFC 3339 time,
+// an age such as 36h, 14d or 2w, or git's "2 weeks ago" / "2.weeks.ago".
+func parseSince(value string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	age := strings.TrimSuffix(strings.ReplaceAll(value, ".", " "), " ago")
+	fields := strings.Fields(age)
+	var n int
+	var unit string
+	switch len(fields) {
+	case 1:
+		// Compact form: digits followed by a unit letter
+		i := 0
+		for i < len(age) && age[i] >= '0' && age[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+		}
+		n, _ = strconv.Atoi(age[:i])
+		unit = age[i:]
+	case 2:
+		var err error
+		n, err = strconv.Atoi(fields[0])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+		}
+		unit = fields[1]
+	default:
+		return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+	}
+
+	switch strings.TrimSuffix(unit, "s") {
+	case "m", "minute":
+		return now.Add(-time.Duration(n) * time.Minute), nil
+	case "h", "hour":
+		return now.Add(-time.Duration(n) * time.Hour), nil
+	case "d", "day":
+		return now.AddDate(0, 0, -n), nil
+	case "w", "week":
+		return now.AddDate(0, 0, -7*n), nil
+	case "month":
+		return now.AddDate(0, -n, 0), nil
+	case "y", "year":
+		return now.AddDate(-n, 0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time unit %q in %q", unit, value)
+}
+
+// logCommits resolves opts to the list of commits to consider, newest first.
+// MaxCount is applied by the caller since it counts commits actually shown.
+func logCommits(r *git.Repository, opts LogOptions) ([]*object.Commit, error) {
+	head, err := resolveGitRef(r, opts.HeadRef)
+	if err != nil {
+		return nil, err
+	}
+	var base *object.Commit
+	if opts.BaseRef != "" {
+		base, err = resolveGitRef(r, opts.BaseRef)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Without an endpoint filter every commit is shown, so the walk can stop early
+	limit := 0
+	if opts.Endpoint == "" {
+		limit = opts.MaxCount
+	}
+	var commits []*object.Commit
+	if opts.FirstParent {
+		commits, err = firstParentRange(base, head, limit)
+	} else {
+		commits, err = commitRange(r, base, head, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.Since.IsZero() {
+		for i, c := range commits {
+			if c.Committer.When.Before(opts.Since) {
+				return commits[:i], nil
+			}
+		}
+	}
+	return commits, nil
+}
+
+// commitRange returns every commit reachable from head but not from base,
+// newest first by commit time, like git log base..head. A nil base walks
+// to the root commits.
+func commitRange(r *git.Repository, base, head *object.Commit, limit int) ([]*object.Commit, error) {
+	excluded, err := ancestors(base)
+	if err != nil {
+		return nil, err
+	}
+	// Shallow clones end at commits whose parents are missing
+	shallow, err := r.Storer.Shallow()
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range shallow {
+		if c, err := r.CommitObject(hash); err == nil {
+			for _, parent := range c.ParentHashes {
+				excluded[parent] = true
+			}
+		}
+	}
+
+	var commits []*object.Commit
+	err = object.NewCommitIterCTime(head, excluded, nil).ForEach(func(c *object.Commit) error {
+		if limit > 0 && len(commits) == limit {
+			return storer.ErrStop
+		}
+		commits = append(commits, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return commits, nil
+}
+
+// ancestors returns base and every commit reachable from it, or nothing for
+// a nil base.
+func ancestors(base *object.Commit) (map[plumbing.Hash]bool, error) {
+	excluded := make(map[plumbing.Hash]bool)
+	if base == nil {
+		return excluded, nil
+	}
+	err := object.NewCommitPreorderIter(base, nil, nil).ForEach(func(c *object.Commit) error {
+		excluded[c.Hash] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return excluded, nil
+}
+
+// firstParentRange walks the first-parent chain from head, newest first,
+// stopping at the first commit reachable from base, like
+// git log --first-parent base..head. A nil base walks to the root commit.
+func firstParentRange(base, head *object.Commit, limit int) ([]*object.Commit, error) {
+	excluded, err := ancestors(base)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []*object.Commit
+	commit := head
+	for commit != nil && !excluded[commit.Hash] {
+		if limit > 0 && len(commits) == limit {
+			break
+		}
+		commits = append(commits, commit)
+		if commit.NumParents() == 0 {
+			break
+		}
+		parent, err := commit.Parent(0)
+		if err == plumbing.ErrObjectNotFound {
+			// Shallow clones end without a root commit
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		commit = parent
+	}
+	return commits, nil
+}
+
+// commitEndpoints returns the impact of commit relative to its parents. A
+// merge only changes an endpoint in ways that differ from every parent, as
+// in git's combined diffs, since the changes each side brought in are
+// reported on the commits that made them. With firstParent a merge is
+// compared with its first parent alone, as one change bringing in a branch.
+func commitEndpoints(ctx context.Context, analyzer *treeAnalyzer, commit *object.Commit, firstParent bool) (impact, error) {
+	if firstParent || commit.NumParents() < 2 {
+		cmp, err := parentComparison(commit)
+		if err != nil {
+			return impact{}, err
+		}
+		label := shortHash(commit.Hash)
+		return comparisonImpact(ctx, analyzer, cmp, label+"^", label)
+	}
+
+	var result impact
+	for i := 0; i < commit.NumParents(); i++ {
+		parent, err := commit.Parent(i)
+		if err != nil {
+			return impact{}, fmt.Errorf("error getting parent %d of %s: %w", i+1, commit.Hash, err)
+		}
+		patch, err := diffCommits(parent, commit)
+		if err != nil {
+			return impact{}, err
+		}
+		cmp := &comparison{patch: patch, base: snapshot{commit: parent}, head: snapshot{commit: commit}}
+		label := shortHash(commit.Hash)
+		side, err := comparisonImpact(ctx, analyzer, cmp, fmt.Sprintf("%s^%d", label, i+1), label)
+		if err != nil {
+			return impact{}, err
+		}
+		if i == 0 {
+			result = side
+			continue
+		}
+		result.Added = intersect(result.Added, side.Added)
+		result.Removed = intersect(result.Removed, side.Removed)
+		result.Renames = nil
+	}
+	return result, nil
+}
+
+// intersect returns the entries of a that are also in b, in a's order.
+func intersect(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, s := range b {
+		in[s] = true
+	}
+	var both []string
+	for _, s := range a {
+		if in[s] {
+			both = append(both, s)
+		}
+	}
+	return both
+}
+
+// comparisonImpact analyzes both sides of cmp, named baseLabel and
+// headLabel in messages, and returns the endpoints its patch changed.
+func comparisonImpact(ctx context.Context, analyzer *treeAnalyzer, cmp *comparison, baseLabel, headLabel string) (impact, error) {
+	// Commits that only touch docs or config cannot change any endpoint, so
+	// skip running the analyzer for them
+	if !touchesSource(cmp.patch) {
+		return impact{}, nil
+	}
+	baseFunctions, err := analyzer.analyze(ctx, cmp.base, baseLabel)
+	if err != nil {
+		return impact{}, err
+	}
+	headFunctions, err := analyzer.analyze(ctx, cmp.head, headLabel)
+	if err != nil {
+		return impact{}, err
+	}
+	return findChangedFunctions(cmp.patch, baseFunctions, headFunctions), nil
+}
+
+// touchesSource reports whether patch changes any JavaScript or TypeScript
+// file the analyzer could have reported functions in.
+func touchesSource(patch fdiff.Patch) bool {
+	for _, fp := range patch.FilePatches() {
+		from, to := fp.Files()
+		for _, f := range []fdiff.File{from, to} {
+			if f == nil {
+				continue
+			}
+			switch filepath.Ext(f.Path()) {
+			case ".ts", ".tsx", ".js", ".jsx", ".mts", ".cts", ".mjs", ".cjs":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func printCommitHeader(commit *object.Commit) {
+	hash := color.New(color.FgYellow)
+	label := color.New(color.FgWhite, color.Bold)
+
+	hash.Printf("commit %s\n", commit.Hash)
+	label.Print("Author: ")
+	fmt.Printf("%s <%s>\n", commit.Author.Name, commit.Author.Email)
+	label.Print("Date:   ")
+	fmt.Printf("%s\n", commit.Author.When.Format("Mon Jan 2 15:04:05 2006 -0700"))
+	subject, _, _ := strings.Cut(commit.Message, "\n")
+	fmt.Printf("\n    %s\n\n", subject)
+}
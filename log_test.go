@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestCommitRange(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	hashes := make(map[string]plumbing.Hash)
+	// Commits a minute apart, so commit time order is creation order
+	when := time.Now().Add(-time.Hour)
+	commit := func(name string, parents ...plumbing.Hash) {
+		writeFile(t, dir, "a.ts", name+"\n")
+		if _, err := wt.Add("a.ts"); err != nil {
+			t.Fatalf("failed to stage: %v", err)
+		}
+		hash, err := wt.Commit(name, &git.CommitOptions{
+			Author:  &object.Signature{Name: "pit", Email: "pit@example.com", When: when},
+			Parents: parents,
+		})
+		if err != nil {
+			t.Fatalf("failed to commit %s: %v", name, err)
+		}
+		hashes[name] = hash
+		when = when.Add(time.Minute)
+	}
+
+	// A - B - C - M
+	//      \     /
+	//       F ---
+	commit("A")
+	commit("B")
+	commit("C")
+	commit("F", hashes["B"])
+	commit("M", hashes["C"], hashes["F"])
+
+	get := func(name string) *object.Commit {
+		c, err := repo.CommitObject(hashes[name])
+		if err != nil {
+			t.Fatalf("failed to get %s: %v", name, err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		base        string
+		limit       int
+		firstParent bool
+		want        []string
+	}{
+		{"", 0, false, []string{"M", "F", "C", "B", "A"}},
+		{"B", 0, false, []string{"M", "F", "C"}},
+		{"C", 0, false, []string{"M", "F"}},
+		{"F", 0, false, []string{"M", "C"}},
+		{"", 2, false, []string{"M", "F"}},
+		{"M", 0, false, nil},
+		{"", 0, true, []string{"M", "C", "B", "A"}},
+		{"B", 0, true, []string{"M", "C"}},
+		{"F", 0, true, []string{"M", "C"}},
+		{"", 2, true, []string{"M", "C"}},
+		{"M", 0, true, nil},
+	}
+
+	for _, tt := range tests {
+		var base *object.Commit
+		if tt.base != "" {
+			base = get(tt.base)
+		}
+		var commits []*object.Commit
+		var err error
+		if tt.firstParent {
+			commits, err = firstParentRange(base, get("M"), tt.limit)
+		} else {
+			commits, err = commitRange(repo, base, get("M"), tt.limit)
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(commits) != len(tt.want) {
+			t.Fatalf("base %q limit %d first-parent %v: expected %d commits, got %d", tt.base, tt.limit, tt.firstParent, len(tt.want), len(commits))
+		}
+		for i, c := range commits {
+			if c.Hash != hashes[tt.want[i]] {
+				t.Errorf("base %q limit %d first-parent %v: commit %d is %s, want %s", tt.base, tt.limit, tt.firstParent, i, c.Message, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestNormalizeEndpoint(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"GET /users/:id", "GET users/:id"},
+		{"get /users/:id/", "GET /users/:id"},
+	}
+	for _, tt := range tests {
+		if normalizeEndpoint(tt.a) != normalizeEndpoint(tt.b) {
+			t.Errorf("expected %q and %q to match: %q != %q", tt.a, tt.b, normalizeEndpoint(tt.a), normalizeEndpoint(tt.b))
+		}
+	}
+	if normalizeEndpoint("GET /users") == normalizeEndpoint("GET /users/:id") {
+		t.Errorf("expected different paths not to match")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"36h", now.Add(-36 * time.Hour)},
+		{"14d", now.AddDate(0, 0, -14)},
+		{"2w", now.AddDate(0, 0, -14)},
+		{"2 weeks ago", now.AddDate(0, 0, -14)},
+		{"2.weeks.ago", now.AddDate(0, 0, -14)},
+		{"1 month ago", now.AddDate(0, -1, 0)},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.value, now)
+		if err != nil {
+			t.Errorf("parseSince(%q): unexpected error: %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	if got, err := parseSince("2024-03-01", now); err != nil || got.Day() != 1 || got.Month() != time.March {
+		t.Errorf("parseSince(2024-03-01) = %v, %v", got, err)
+	}
+	if _, err := parseSince("yesterday-ish", now); err == nil {
+		t.Errorf("expected error for unrecognized time")
+	}
+}
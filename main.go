@@ -1,13 +1,16 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -15,8 +18,7 @@ import (
 	"github.com/briandowns/spinner"
 	"github.com/fatih/color"
 	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/object"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
 )
 
 func findGitRoot(path string) (string, error) {
@@ -58,17 +60,151 @@ func findGitRoot(path string) (string, error) {
 }
 
 type GitRefs struct {
-	Path     string
-	BaseRef  string
-	HeadRef  string
+	Path      string
+	BaseRef   string
+	HeadRef   string
+	MergeBase bool          // diff from the merge base of BaseRef and HeadRef
+	Staged    bool          // compare the index against HEAD
+	Unstaged  bool          // compare the working tree against the index
+	Verbose   bool          // stream analyzer output as it runs
+	Timeout   time.Duration // give up on analysis after this long, 0 for no limit
+	Runtime   string        // JavaScript runtime to analyze with, empty to pick one
+	Daemon    bool          // analyze through the long-lived analyzer daemon
+}
+
+// Uncommitted reports whether the comparison involves the index or working
+// tree rather than two commits.
+func (g GitRefs) Uncommitted() bool {
+	return g.Staged || g.Unstaged
+}
+
+// Range describes the two sides being compared, e.g. "main..HEAD" or
+// "HEAD..index".
+func (g GitRefs) Range() string {
+	switch {
+	case g.Staged && g.Unstaged:
+		return "HEAD..worktree"
+	case g.Staged:
+		return "HEAD..index"
+	case g.Unstaged:
+		return "index..worktree"
+	}
+	if g.MergeBase {
+		return fmt.Sprintf("%s...%s", g.BaseRef, g.HeadRef)
+	}
+	return fmt.Sprintf("%s..%s", g.BaseRef, g.HeadRef)
+}
+
+// splitRange splits a "base..head" or "base...head" revision range. An empty
+// side defaults to HEAD, as in git. ok is false when spec is a single ref.
+func splitRange(spec string) (base, head string, mergeBase, ok bool) {
+	sep := "..."
+	i := strings.Index(spec, sep)
+	if i < 0 {
+		sep = ".."
+		i = strings.Index(spec, sep)
+	}
+	if i < 0 {
+		return "", "", false, false
+	}
+
+	base, head = spec[:i], spec[i+len(sep):]
+	if base == "" {
+		base = "HEAD"
+	}
+	if head == "" {
+		head = "HEAD"
+	}
+	return base, head, sep == "...", true
+}
+
+// rangeArg splits a lone argument as a revision range, as splitRange does,
+// unless a file or directory of that name exists.
+func rangeArg(arg string) (base, head string, mergeBase, ok bool) {
+	if _, err := os.Stat(arg); !os.IsNotExist(err) {
+		return "", "", false, false
+	}
+	return splitRange(arg)
+}
+
+func printUsage() {
+	fmt.Println("Usage: pit [flags] [path] [base-ref] [head-ref]")
+	fmt.Println("       pit [flags] [path] <base>..<head> | <base>...<head>")
+	fmt.Println("       pit log [flags] [path] [<base>..<head>]")
+	fmt.Println("       pit blame [flags] [path] <endpoint>")
+	fmt.Println("       pit doctor [--runtime <r>] [path]")
+	fmt.Println("       pit daemon start | stop | status")
+	fmt.Println("       pit cache ls | prune | clear [path]")
+	fmt.Println("Flags:")
+	fmt.Println("  --merge-base  Diff from the merge base of base and head, like a pull request")
+	fmt.Println("  --staged      Compare staged changes (index) against HEAD")
+	fmt.Println("  --unstaged    Compare unstaged changes (working tree) against the index")
+	fmt.Println("                Use both to compare the working tree against HEAD")
+	fmt.Println("  --verbose     Stream analyzer output and warnings as they arrive")
+	fmt.Println("  --timeout <d> Give up on analysis after this long (e.g. 5m), exiting with 124")
+	fmt.Println("  --runtime <r> Run the analyzer with bun, node or deno (default: git config")
+	fmt.Println("                pit.runtime, else the first one installed)")
+	fmt.Println("  --daemon      Analyze through the analyzer daemon, starting it if needed")
+	fmt.Println("Examples:")
+	fmt.Println("  pit                          # Compare HEAD^ and HEAD in current directory")
+	fmt.Println("  pit /path/to/repo            # Compare HEAD^ and HEAD in specified directory")
+	fmt.Println("  pit /path/to/repo main       # Compare main and HEAD")
+	fmt.Println("  pit /path/to/repo v1.0 v2.0  # Compare tag v1.0 with tag v2.0")
+	fmt.Println("  pit main...feature           # Changes on feature since it branched from main")
+	fmt.Println("  pit --staged                 # Endpoints touched by what is about to be committed")
+	fmt.Println("  pit --staged --unstaged      # Endpoints touched by all uncommitted changes")
 }
 
 func validateCommandLineArgs() GitRefs {
 	gitRefs := GitRefs{}
-	
+
+	flag.BoolVar(&gitRefs.MergeBase, "merge-base", false, "diff from the merge base of base and head")
+	flag.BoolVar(&gitRefs.Staged, "staged", false, "compare the index against HEAD")
+	flag.BoolVar(&gitRefs.Unstaged, "unstaged", false, "compare the working tree against the index")
+	flag.BoolVar(&gitRefs.Verbose, "verbose", false, "stream analyzer output as it runs")
+	flag.DurationVar(&gitRefs.Timeout, "timeout", 0, "give up on analysis after this long")
+	flag.StringVar(&gitRefs.Runtime, "runtime", "", "JavaScript runtime to analyze with (bun, node or deno)")
+	flag.BoolVar(&gitRefs.Daemon, "daemon", false, "analyze through the analyzer daemon")
+	flag.Usage = printUsage
+	flag.Parse()
+	args := flag.Args()
+
+	// Refs make no sense when diffing the index or working tree
+	if gitRefs.Uncommitted() && (len(args) > 1 || gitRefs.MergeBase) {
+		printUsage()
+		os.Exit(1)
+	}
+
+	// A single range argument stands in for both refs
+	if len(args) == 2 {
+		if base, head, mergeBase, ok := splitRange(args[1]); ok {
+			gitRefs.Path = args[0]
+			gitRefs.BaseRef = base
+			gitRefs.HeadRef = head
+			gitRefs.MergeBase = gitRefs.MergeBase || mergeBase
+			return gitRefs
+		}
+	}
+
+	// pit main...feature in the current directory
+	if len(args) == 1 {
+		if base, head, mergeBase, ok := rangeArg(args[0]); ok {
+			inputPath, err := os.Getwd()
+			if err != nil {
+				fmt.Println("Error getting current working directory", err)
+				os.Exit(1)
+			}
+			gitRefs.Path = inputPath
+			gitRefs.BaseRef = base
+			gitRefs.HeadRef = head
+			gitRefs.MergeBase = gitRefs.MergeBase || mergeBase
+			return gitRefs
+		}
+	}
+
 	// Parse args based on count
-	switch len(os.Args) {
-	case 1: // No args provided, use current directory and HEAD^..HEAD
+	switch len(args) {
+	case 0: // No args provided, use current directory and HEAD^..HEAD
 		inputPath, err := os.Getwd()
 		if err != nil {
 			fmt.Println("Error getting current working directory", err)
@@ -77,28 +213,23 @@ func validateCommandLineArgs() GitRefs {
 		gitRefs.Path = inputPath
 		gitRefs.BaseRef = "HEAD^"
 		gitRefs.HeadRef = "HEAD"
-	case 2: // Just path provided
-		gitRefs.Path = os.Args[1]
+	case 1: // Just path provided
+		gitRefs.Path = args[0]
 		gitRefs.BaseRef = "HEAD^"
 		gitRefs.HeadRef = "HEAD"
-	case 3: // Path and one ref provided - use as base, HEAD as head
-		gitRefs.Path = os.Args[1]
-		gitRefs.BaseRef = os.Args[2]
+	case 2: // Path and one ref provided - use as base, HEAD as head
+		gitRefs.Path = args[0]
+		gitRefs.BaseRef = args[1]
 		gitRefs.HeadRef = "HEAD"
-	case 4: // Path and both refs provided
-		gitRefs.Path = os.Args[1]
-		gitRefs.BaseRef = os.Args[2]
-		gitRefs.HeadRef = os.Args[3]
+	case 3: // Path and both refs provided
+		gitRefs.Path = args[0]
+		gitRefs.BaseRef = args[1]
+		gitRefs.HeadRef = args[2]
 	default:
-		fmt.Println("Usage: pit [path] [base-ref] [head-ref]")
-		fmt.Println("Examples:")
-		fmt.Println("  pit                          # Compare HEAD^ and HEAD in current directory")
-		fmt.Println("  pit /path/to/repo            # Compare HEAD^ and HEAD in specified directory")
-		fmt.Println("  pit /path/to/repo main       # Compare main and HEAD")
-		fmt.Println("  pit /path/to/repo v1.0 v2.0  # Compare tag v1.0 with tag v2.0")
+		printUsage()
 		os.Exit(1)
 	}
-	
+
 	return gitRefs
 }
 
@@ -139,58 +270,62 @@ func printPaths(gitRoot, absPath, framework string) {
 	value.Printf("%s\n", absPath)
 }
 
+// setupPipe creates the named pipe the analyzer reports through. Each run
+// gets its own private directory so concurrent runs never share a pipe, and
+// the directory is removed on every way out of pit.
 func setupPipe() string {
-	pipeName := "/tmp/pip_pipe"
-	if err := createPipe(pipeName); err != nil {
+	pipeName, err := createIPCChannel()
+	if err != nil {
 		fmt.Printf("Error creating named pipe: %s\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 	return pipeName
 }
 
-func setupSignalHandler(pipeName string) {
-	sigChan := make(chan os.Signal, 1)
+// createIPCChannel makes a FIFO readable and writable only by the current
+// user inside a new temporary directory, and registers the directory for
+// removal at exit.
+func createIPCChannel() (string, error) {
+	dir, err := os.MkdirTemp("", "pit-ipc-")
+	if err != nil {
+		return "", fmt.Errorf("creating IPC directory: %w", err)
+	}
+	atExit(func() { os.RemoveAll(dir) })
+
+	pipeName := filepath.Join(dir, "analyzer.pipe")
+	if err := createPipe(pipeName); err != nil {
+		return "", err
+	}
+	return pipeName, nil
+}
+
+// setupSignalHandler returns the context a run is bound to. It ends after
+// timeout, if positive, or on SIGINT or SIGTERM, which stops the analyzer
+// and lets pit clean up. A second signal exits straight away.
+func setupSignalHandler(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-sigChan
-		os.Remove(pipeName)
-		os.Exit(0)
+		cancel(errInterrupted)
+		<-sigChan
+		exit(exitInterrupted)
 	}()
-}
 
-func executeTypeScriptProcess(absPath, pipeName string) *exec.Cmd {
-	cmd := exec.Command("npx", "ts-node", "/Users/prasshan/Desktop/Repos/pit/ts_src/ffi/called.ts", absPath, pipeName)
-	cmd.Stderr = io.Discard
-	if err := cmd.Start(); err != nil {
-		fmt.Printf("Error starting TypeScript process: %s\n", err)
-		os.Exit(1)
+	if timeout <= 0 {
+		return ctx, func() { cancel(nil) }
 	}
-	return cmd
-}
-
-func readFunctionsFromPipe(pipe *os.File) []FunctionRange {
-	var functions []FunctionRange
-	decoder := json.NewDecoder(pipe)
-	for {
-		var functionBatch []FunctionRange
-		err := decoder.Decode(&functionBatch)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			fmt.Printf("Error decoding TypeScript output: %s\n", err)
-			os.Exit(1)
-		}
-		functions = append(functions, functionBatch...)
+	timed, cancelTimeout := context.WithTimeoutCause(ctx, timeout, errTimedOut)
+	return timed, func() {
+		cancelTimeout()
+		cancel(nil)
 	}
-	return functions
 }
 
-func main() {
-	gitRefs := validateCommandLineArgs()
-	// cleanPath := validateTypeScriptFile(tsPath)
-
-	absPath, err := filepath.Abs(gitRefs.Path)
+// setupGitRoot returns the root of the repository containing path.
+func setupGitRoot(path string) string {
+	absPath, err := filepath.Abs(path)
 	if err != nil {
 		fmt.Printf("Error getting absolute path: %s\n", err)
 		os.Exit(1)
@@ -200,270 +335,365 @@ func main() {
 		fmt.Println("Error finding git root", err)
 		os.Exit(1)
 	}
-	mainPath, framework, err := DetectFramework(gitRoot)
+	return gitRoot
+}
+
+func main() {
+	// Subcommands come first; anything else is a plain comparison
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "log":
+			runLog(os.Args[2:])
+			return
+		case "blame":
+			runBlame(os.Args[2:])
+			return
+		case "doctor":
+			runDoctor(os.Args[2:])
+			return
+		case "daemon":
+			runDaemon(os.Args[2:])
+			return
+		case "cache":
+			runCache(os.Args[2:])
+			return
+		}
+	}
+
+	gitRefs := validateCommandLineArgs()
+	// cleanPath := validateTypeScriptFile(tsPath)
+
+	gitRoot := setupGitRoot(gitRefs.Path)
+	mainPath, framework, _ := DetectFramework(gitRoot)
 	if framework == 0 {
 		fmt.Println("No supported framework found")
 		os.Exit(1)
 	}
 
 	printPaths(gitRoot, mainPath, framework.String())
-	
+	rt := setupRuntime(gitRefs.Runtime, gitRoot)
+
 	// Print the Git refs being compared
 	label := color.New(color.FgWhite, color.Bold)
 	value := color.New(color.FgCyan)
 	label.Print("Comparing Git refs: ")
-	value.Printf("%s..%s\n", gitRefs.BaseRef, gitRefs.HeadRef)
+	value.Printf("%s\n", gitRefs.Range())
 
 	pipeName := setupPipe()
-	setupSignalHandler(pipeName)
+	ctx, cancel := setupSignalHandler(gitRefs.Timeout)
+	defer cancel()
+	defer runCleanups()
+	daemon := setupDaemon(daemonEnabled(gitRefs.Daemon, gitRoot), rt)
+
+	handleRepo(ctx, gitRoot, analyzerConfig{pipeName: pipeName, runtime: rt, daemon: daemon, verbose: gitRefs.Verbose}, gitRefs)
+}
+
+// analyzerConfig is how every analyzer run of one pit invocation is made.
+type analyzerConfig struct {
+	pipeName string
+	runtime  *jsRuntime
+	daemon   *daemonClient // analyze through the daemon rather than a new process
+	verbose  bool          // stream analyzer output and warnings as they arrive
+}
+
+// analysis is an analyzer run in progress, writing to the pipe.
+type analysis interface {
+	Wait() error
+	Kill() error
+}
+
+// processAnalysis is an analyzer run in a process of its own.
+type processAnalysis struct {
+	*exec.Cmd
+}
+
+func (p processAnalysis) Kill() error {
+	return killProcessGroup(p.Cmd)
+}
+
+// analysisRequest is what the analyzer is asked to analyze: the entrypoint
+// of a project, the framework its routes are read as, and the file listing
+// endpoints to skip, if any.
+type analysisRequest struct {
+	mainPath  string
+	framework string
+	reusePath string
+}
+
+// analyzerFramework is the name the analyzer knows framework by.
+func analyzerFramework(framework FrameworkType) string {
+	return strings.ToLower(framework.String())
+}
+
+// startAnalysis starts the analysis req, through the daemon if there is
+// one. The daemon writes its output to its own log.
+func startAnalysis(ctx context.Context, cfg analyzerConfig, req analysisRequest, output io.Writer) (analysis, error) {
+	if cfg.daemon != nil {
+		return cfg.daemon.analyze(ctx, req, cfg.pipeName)
+	}
+	cmd, err := executeTypeScriptProcess(ctx, cfg.runtime, req, cfg.pipeName, output)
+	if err != nil {
+		return nil, err
+	}
+	return processAnalysis{cmd}, nil
+}
+
+// runAnalyzer runs the TypeScript analyzer against the entrypoint of a
+// project using framework and returns everything it reported. Endpoints in
+// reuse are listed among the routes but not analyzed.
+func runAnalyzer(ctx context.Context, mainPath string, framework FrameworkType, cfg analyzerConfig, label string, reuse []string) (*analyzerOutput, error) {
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	reusePath, err := writeReuseList(cfg.pipeName, reuse)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep what the analyzer prints so a failure can be explained
+	stderr := &tailBuffer{max: maxAnalyzerStderr}
+	var output io.Writer = stderr
+	warn := color.New(color.FgYellow)
 
 	s := spinner.New(spinner.CharSets[43], 100*time.Millisecond)
 	s.Color("yellow") // Colors the spinner characters
-	s.Prefix = color.YellowString("Waiting for Typescript parser ")
-	s.Start()
+	s.Prefix = color.YellowString("Waiting for Typescript parser (%s) ", label)
+	if cfg.verbose {
+		// Live output and a spinner would garble each other
+		output = io.MultiWriter(stderr, os.Stderr)
+		warn.Fprintf(os.Stderr, "Running TypeScript parser (%s)\n", label)
+	} else {
+		s.Start()
+		defer s.Stop()
+	}
+
+	req := analysisRequest{mainPath: mainPath, framework: analyzerFramework(framework), reusePath: reusePath}
+	run, err := startAnalysis(ctx, cfg, req, output)
+	if err != nil {
+		return nil, &AnalyzerError{Label: label, Err: err, Stderr: stderr.String()}
+	}
 
-	cmd := executeTypeScriptProcess(mainPath, pipeName)
+	// Waiting in the background lets opening the pipe give up if the
+	// analyzer exits without ever opening its end
+	waited := make(chan error, 1)
+	exited, markExited := context.WithCancel(ctx)
+	defer markExited()
+	go func() {
+		waited <- run.Wait()
+		markExited()
+	}()
 
-	pipe, err := os.OpenFile(pipeName, os.O_RDONLY, os.ModeNamedPipe)
+	pipe, err := openPipe(exited, cfg.pipeName)
 	if err != nil {
-		fmt.Printf("Error opening named pipe: %s\n", err)
-		os.Exit(1)
+		run.Kill()
+		<-waited
+		return nil, fmt.Errorf("opening named pipe: %w", err)
 	}
 	defer pipe.Close()
-	defer os.Remove(pipeName)
-	functions := readFunctionsFromPipe(pipe)
-
-	if err := cmd.Wait(); err != nil {
-		s.Stop()
-		fmt.Printf("TypeScript process failed: %s\n", err)
-		os.Exit(1)
+	// Closing the pipe unblocks a read from an analyzer that has hung
+	stop := context.AfterFunc(ctx, func() { pipe.Close() })
+	defer stop()
+
+	result, err := readAnalyzerOutput(pipe, func(msg analyzerMessage) {
+		switch {
+		case msg.Type == msgWarning && cfg.verbose:
+			warn.Fprintf(os.Stderr, "Warning: %s\n", msg.Message)
+		case msg.Type == msgProgress:
+			s.Lock()
+			s.Suffix = fmt.Sprintf(" %d/%d %s", msg.Current, msg.Total, msg.Message)
+			s.Unlock()
+		}
+	})
+	if err != nil {
+		// After an error message the analyzer exits by itself, having
+		// written whatever explains the failure
+		var protoErr *ProtocolError
+		if errors.As(err, &protoErr) {
+			run.Kill()
+		}
+		waitErr := <-waited
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("analyzer (%s): %w", label, context.Cause(ctx))
+		}
+		if waitErr != nil {
+			err = fmt.Errorf("TypeScript process failed: %w", waitErr)
+		}
+		return nil, &AnalyzerError{Label: label, Err: err, Stderr: stderr.String()}
 	}
-	s.Stop()
 
-	handleRepo(gitRoot, functions, gitRefs.BaseRef, gitRefs.HeadRef)
+	if err := <-waited; err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("analyzer (%s): %w", label, context.Cause(ctx))
+		}
+		return nil, &AnalyzerError{Label: label, Err: fmt.Errorf("TypeScript process failed: %w", err), Stderr: stderr.String()}
+	}
+	return result, nil
 }
 
 type FunctionRange struct {
 	ControllerName string `json:"ControllerName"`
 	FunctionName   string `json:"FunctionName"`
-	Filename       string `json:"Filename"`
+	Filename       string `json:"Filename"` // absolute from the analyzer, root-relative once normalized
 	StartLine      int    `json:"StartLine"`
 	EndLine        int    `json:"EndLine"`
 }
 
-func findFunctionsWithOverlappingChunks(functions []FunctionRange, chunkFilename string, chunkStart, chunkEnd int) []string {
-	var overlappingFunctions []string
-
-	for _, fn := range functions {
-		// log.Printf("Path comparison -> absChunkFilename: %q == absFuncFilename: %q, Equal: %v", chunkFilename,fn.Filename, absChunkFilename == fn.Filename)
-		if !strings.Contains(fn.Filename, chunkFilename) {
-			continue
-		}
-		if (chunkStart >= fn.StartLine && chunkStart <= fn.EndLine) ||
-			(chunkEnd >= fn.StartLine && chunkEnd <= fn.EndLine) ||
-			(chunkStart <= fn.StartLine && chunkEnd >= fn.EndLine) ||
-			(chunkStart >= fn.StartLine && chunkEnd <= fn.EndLine) {
-			overlappingFunctions = append(overlappingFunctions, fn.ControllerName)
-		}
+// commitComparison compares the commits named by baseRef and headRef. With
+// mergeBase the base side is the best common ancestor of the two instead, so
+// only changes made on head's side since they diverged are reported.
+func commitComparison(r *git.Repository, baseRef, headRef string, mergeBase bool) (*comparison, error) {
+	// Resolve head reference
+	headCommit, err := resolveGitRef(r, headRef)
+	if err != nil {
+		return nil, fmt.Errorf("resolving head ref '%s': %w", headRef, err)
 	}
 
-	return overlappingFunctions
-}
-
-// resolveGitRef resolves a git reference (commit, branch, tag) to a commit object
-func resolveGitRef(repo *git.Repository, refName string) (*object.Commit, error) {
-	// If it's "HEAD" or "HEAD^", handle specially
-	if refName == "HEAD" {
-		ref, err := repo.Head()
-		if err != nil {
-			return nil, fmt.Errorf("error getting HEAD: %w", err)
-		}
-		return repo.CommitObject(ref.Hash())
+	// Resolve base reference
+	baseCommit, err := resolveGitRef(r, baseRef)
+	if err != nil {
+		return nil, fmt.Errorf("resolving base ref '%s': %w", baseRef, err)
 	}
-	
-	if refName == "HEAD^" {
-		ref, err := repo.Head()
-		if err != nil {
-			return nil, fmt.Errorf("error getting HEAD: %w", err)
-		}
-		commit, err := repo.CommitObject(ref.Hash())
-		if err != nil {
-			return nil, fmt.Errorf("error getting HEAD commit: %w", err)
-		}
-		parent, err := commit.Parent(0)
+
+	if mergeBase {
+		bases, err := baseCommit.MergeBase(headCommit)
 		if err != nil {
-			return nil, fmt.Errorf("error getting parent commit: %w", err)
-		}
-		return parent, nil
-	}
-	
-	// Try to resolve as a hash
-	hash := plumbing.NewHash(refName)
-	if !hash.IsZero() {
-		commit, err := repo.CommitObject(hash)
-		if err == nil {
-			return commit, nil
+			return nil, fmt.Errorf("finding merge base of '%s' and '%s': %w", baseRef, headRef, err)
 		}
-	}
-	
-	// Try to resolve as a reference: first check exact ref
-	ref, err := repo.Reference(plumbing.ReferenceName(refName), true)
-	if err == nil {
-		return repo.CommitObject(ref.Hash())
-	}
-	
-	// Try with refs/heads/ prefix for branches
-	ref, err = repo.Reference(plumbing.ReferenceName("refs/heads/"+refName), true)
-	if err == nil {
-		return repo.CommitObject(ref.Hash())
-	}
-	
-	// Try with refs/tags/ prefix for tags
-	ref, err = repo.Reference(plumbing.ReferenceName("refs/tags/"+refName), true)
-	if err == nil {
-		return repo.CommitObject(ref.Hash())
-	}
-	
-	// Handle special revisions like HEAD~3, HEAD^2, etc.
-	if strings.Contains(refName, "~") || strings.Contains(refName, "^") {
-		parts := strings.Split(refName, "~")
-		baseName := parts[0]
-		
-		// Handle HEAD^ syntax
-		if strings.Contains(baseName, "^") {
-			baseParts := strings.Split(baseName, "^")
-			base := baseParts[0]
-			parentNum := 0
-			if len(baseParts) > 1 && baseParts[1] != "" {
-				fmt.Sscanf(baseParts[1], "%d", &parentNum)
-			}
-			
-			baseCommit, err := resolveGitRef(repo, base)
-			if err != nil {
-				return nil, err
-			}
-			
-			// Use the specified parent or default to first parent
-			if parentNum > 0 {
-				parent, err := baseCommit.Parent(parentNum - 1)
-				if err != nil {
-					return nil, err
-				}
-				baseCommit = parent
-			} else {
-				parent, err := baseCommit.Parent(0)
-				if err != nil {
-					return nil, err
-				}
-				baseCommit = parent
-			}
-			
-			// If there's a ~ part, traverse more ancestors
-			if len(parts) > 1 {
-				depth := 0
-				fmt.Sscanf(parts[1], "%d", &depth)
-				for i := 0; i < depth; i++ {
-					parent, err := baseCommit.Parent(0)
-					if err != nil {
-						return nil, err
-					}
-					baseCommit = parent
-				}
-			}
-			
-			return baseCommit, nil
+		if len(bases) == 0 {
+			return nil, fmt.Errorf("'%s' and '%s' have no common ancestor", baseRef, headRef)
 		}
+		baseCommit = bases[0]
 	}
-	
-	// Try resolving as a revision (this handles many cases like branch~3, HEAD~2, etc.)
-	revHash, err := repo.ResolveRevision(plumbing.Revision(refName))
-	if err == nil {
-		return repo.CommitObject(*revHash)
+
+	// Get the patch between the two commits
+	patch, err := diffCommits(baseCommit, headCommit)
+	if err != nil {
+		return nil, err
 	}
-	
-	return nil, fmt.Errorf("could not resolve git reference: %s", refName)
+	return &comparison{
+		patch: patch,
+		base:  snapshot{commit: baseCommit},
+		head:  snapshot{commit: headCommit},
+	}, nil
 }
 
-func handleRepo(repoPath string, functions []FunctionRange, baseRef, headRef string) {
+func handleRepo(ctx context.Context, repoPath string, cfg analyzerConfig, gitRefs GitRefs) {
 	r, err := git.PlainOpen(repoPath)
 	if err != nil {
 		fmt.Printf("Error opening repository: %v\n", err)
 		return
 	}
-	
-	// Resolve head reference
-	headCommit, err := resolveGitRef(r, headRef)
+
+	var cmp *comparison
+	if gitRefs.Uncommitted() {
+		cmp, err = uncommittedComparison(r, gitRefs.Staged, gitRefs.Unstaged)
+	} else {
+		cmp, err = commitComparison(r, gitRefs.BaseRef, gitRefs.HeadRef, gitRefs.MergeBase)
+	}
 	if err != nil {
-		fmt.Printf("Error resolving head ref '%s': %v\n", headRef, err)
+		fmt.Printf("Error computing diff: %v\n", err)
 		return
 	}
-	
-	// Resolve base reference
-	baseCommit, err := resolveGitRef(r, baseRef)
+
+	if gitRefs.MergeBase {
+		label := color.New(color.FgWhite, color.Bold)
+		value := color.New(color.FgCyan)
+		label.Print("Merge base: ")
+		value.Printf("%s\n", cmp.base.commit.Hash.String()[:7])
+	}
+
+	// Deleted lines carry base-side line numbers and added lines head-side
+	// ones, so each side is matched against its own call graph.
+	analyzer := newTreeAnalyzer(r, repoPath, cfg)
+	baseFunctions, err := analyzer.analyze(ctx, cmp.base, "base")
 	if err != nil {
-		fmt.Printf("Error resolving base ref '%s': %v\n", baseRef, err)
-		return
+		fmt.Printf("Error analyzing base: %v\n", err)
+		exit(exitStatus(err))
 	}
-	
-	// Get the patch between the two commits
-	patch, err := headCommit.Patch(baseCommit)
+	headFunctions, err := analyzer.analyze(ctx, cmp.head, "head")
 	if err != nil {
-		fmt.Printf("Error getting patch: %v\n", err)
-		return
+		fmt.Printf("Error analyzing head: %v\n", err)
+		exit(exitStatus(err))
 	}
 
+	printImpact(findChangedFunctions(cmp.patch, baseFunctions, headFunctions), gitRefs.Range())
+	printDiagnostics(analyzer.warnings, cfg.verbose)
+}
+
+// impact is the effect of a patch on the endpoints of a project.
+type impact struct {
+	Added   []string // endpoints with added lines
+	Removed []string // endpoints with deleted lines
+	Renames []rename
+}
+
+// rename is a file that moved without any change to its content.
+type rename struct {
+	From, To  string
+	Endpoints []string // head-side endpoints with functions in the file
+}
+
+// findChangedFunctions maps every added line in patch onto the head-side
+// functions it falls in and every deleted line onto the base-side ones.
+// Added lines are looked up under the file's new path and line numbers and
+// deleted lines under its old ones, so renamed and moved files and edits
+// after large deletions are attributed correctly.
+func findChangedFunctions(patch fdiff.Patch, baseFunctions, headFunctions []FunctionRange) impact {
+	baseIndex := newFunctionIndex(baseFunctions)
+	headIndex := newFunctionIndex(headFunctions)
 	addFunctions := make(map[string]bool)
 	removeFunctions := make(map[string]bool)
+	var renames []rename
 
 	for _, filePatch := range patch.FilePatches() {
 		from, to := filePatch.Files()
-		filename := "unknown"
+		var fromName, toName string
+		if from != nil {
+			fromName = from.Path()
+		}
 		if to != nil {
-			filename = to.Path()
-		} else if from != nil {
-			filename = from.Path()
+			toName = to.Path()
 		}
 
-		lineNo := 1
+		// Deleted lines are numbered in the base file and added lines in
+		// the head file, so track a line counter for each side
+		baseLine, headLine := 1, 1
+		changed := false
 
 		for _, chunk := range filePatch.Chunks() {
-			lines := strings.Split(chunk.Content(), "\n")
-			// Remove last empty line that comes from splitting
-			if len(lines) > 0 && lines[len(lines)-1] == "" {
-				lines = lines[:len(lines)-1]
+			n := countLines(chunk.Content())
+			if n == 0 {
+				continue
 			}
-			startLine := lineNo
-			endLine := lineNo + len(lines) - 1
 
 			switch chunk.Type() {
-			case 2: // Addition
-				// fmt.Printf("Added in %s (lines %d-%d):\n%s",
-				// 	filename, startLine, endLine, chunk.Content())
-
-				// Check for affected functions only on additions
-				chunkAffectedFunctions := findFunctionsWithOverlappingChunks(functions, filename, startLine, endLine)
-				for _, fn := range chunkAffectedFunctions {
+			case fdiff.Add:
+				for _, fn := range headIndex.overlapping(toName, headLine, headLine+n-1) {
 					addFunctions[fn] = true
 				}
+				headLine += n
+				changed = true
 
-				lineNo += len(lines)
-
-			case 1: // Deletion
-				// fmt.Printf("Deleted from %s (lines %d-%d):\n%s",
-				// 	filename, startLine, endLine, chunk.Content())
-				chunkAffectedFunctions := findFunctionsWithOverlappingChunks(functions, filename, startLine, endLine)
-				for _, fn := range chunkAffectedFunctions {
+			case fdiff.Delete:
+				for _, fn := range baseIndex.overlapping(fromName, baseLine, baseLine+n-1) {
 					removeFunctions[fn] = true
 				}
+				baseLine += n
+				changed = true
 
-				lineNo += len(lines)
-
-				// Don't increment lineNo for deletions as they don't affect the final line numbers
-
-			case 0: // Context
-				lineNo += len(lines)
+			case fdiff.Equal:
+				baseLine += n
+				headLine += n
 			}
 		}
+
+		if from != nil && to != nil && fromName != toName && !changed && !filePatch.IsBinary() {
+			renames = append(renames, rename{
+				From:      fromName,
+				To:        toName,
+				Endpoints: endpointsInFile(headIndex, toName),
+			})
+		}
 	}
 
 	// Convert map to slice for final result
@@ -475,9 +705,57 @@ func handleRepo(repoPath string, functions []FunctionRange, baseRef, headRef str
 	for fn := range removeFunctions {
 		removeResult = append(removeResult, fn)
 	}
+	sort.Strings(addResult)
+	sort.Strings(removeResult)
+
+	return impact{Added: addResult, Removed: removeResult, Renames: renames}
+}
+
+// countLines returns the number of lines in a chunk, counting a final line
+// without a trailing newline.
+func countLines(content string) int {
+	n := strings.Count(content, "\n")
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		n++
+	}
+	return n
+}
+
+// endpointsInFile returns the endpoints with at least one function in file.
+func endpointsInFile(idx *functionIndex, filename string) []string {
+	seen := make(map[string]bool)
+	var endpoints []string
+	for _, fn := range idx.inFile(filename) {
+		if !seen[fn.ControllerName] {
+			seen[fn.ControllerName] = true
+			endpoints = append(endpoints, fn.ControllerName)
+		}
+	}
+	sort.Strings(endpoints)
+	return endpoints
+}
 
-	printBothResults(addResult, removeResult, fmt.Sprintf("%s..%s", baseRef, headRef))
+// printImpact prints everything findChangedFunctions found for treeType.
+func printImpact(result impact, treeType string) {
+	printBothResults(result.Added, result.Removed, treeType)
+	if len(result.Renames) > 0 {
+		fmt.Println()
+		printRenames(result.Renames)
+	}
 }
+
+func printRenames(renames []rename) {
+	yellow := color.New(color.FgYellow)
+	fmt.Println("Files renamed without content changes:")
+	for _, r := range renames {
+		fmt.Print("\t")
+		yellow.Printf("%s -> %s\n", r.From, r.To)
+		for _, endpoint := range r.Endpoints {
+			fmt.Printf("\t\t%s\n", endpoint)
+		}
+	}
+}
+
 func printBothResults(adds, deletes []string, treeType string) {
 	addLen := len(adds)
 	delLen := len(deletes)
@@ -524,7 +802,7 @@ func createPipe(pipeName string) error {
 	}
 
 	// Create new pipe
-	err = syscall.Mkfifo(pipeName, 0666)
+	err = syscall.Mkfifo(pipeName, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to create pipe: %w", err)
 	}
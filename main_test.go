@@ -1,10 +1,17 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"io/ioutil"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
 func TestFindGitRoot_Directory(t *testing.T) {
@@ -62,3 +69,238 @@ func TestFindGitRoot_GitFile(t *testing.T) {
 		t.Errorf("expected root %q, got %q", tmpDir, root)
 	}
 }
+
+func TestSplitRange(t *testing.T) {
+	tests := []struct {
+		spec      string
+		base      string
+		head      string
+		mergeBase bool
+		ok        bool
+	}{
+		{"main", "", "", false, false},
+		{"HEAD~3", "", "", false, false},
+		{"main..feature", "main", "feature", false, true},
+		{"main...feature", "main", "feature", true, true},
+		{"main...", "main", "HEAD", true, true},
+		{"..feature", "HEAD", "feature", false, true},
+		{"v1.0..v2.0", "v1.0", "v2.0", false, true},
+	}
+
+	for _, tt := range tests {
+		base, head, mergeBase, ok := splitRange(tt.spec)
+		if base != tt.base || head != tt.head || mergeBase != tt.mergeBase || ok != tt.ok {
+			t.Errorf("splitRange(%q) = %q, %q, %v, %v; want %q, %q, %v, %v",
+				tt.spec, base, head, mergeBase, ok, tt.base, tt.head, tt.mergeBase, tt.ok)
+		}
+	}
+}
+
+func TestRangeArg(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "a..b"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+
+	base, head, mergeBase, ok := rangeArg("main...feature")
+	if !ok || base != "main" || head != "feature" || !mergeBase {
+		t.Errorf("rangeArg(main...feature) = %q, %q, %v, %v", base, head, mergeBase, ok)
+	}
+	// A directory named like a range is a path
+	if _, _, _, ok := rangeArg(filepath.Join(dir, "a..b")); ok {
+		t.Error("expected an existing path not to be a range")
+	}
+	if _, _, _, ok := rangeArg(dir); ok {
+		t.Error("expected a plain path not to be a range")
+	}
+}
+
+func TestCommitComparison_MergeBase(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	writeFile(t, dir, "a.ts", "one\n")
+	commitAll(t, wt, "fork point")
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("failed to get HEAD: %v", err)
+	}
+	forkPoint := head.Hash()
+
+	// feature diverges from the fork point, then main moves on
+	feature := plumbing.NewBranchReferenceName("feature")
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(feature, forkPoint)); err != nil {
+		t.Fatalf("failed to create branch: %v", err)
+	}
+	writeFile(t, dir, "a.ts", "one\nmain\n")
+	commitAll(t, wt, "on main")
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: feature}); err != nil {
+		t.Fatalf("failed to checkout feature: %v", err)
+	}
+	writeFile(t, dir, "b.ts", "feature\n")
+	commitAll(t, wt, "on feature")
+
+	cmp, err := commitComparison(repo, "master", "feature", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cmp.base.commit.Hash != forkPoint {
+		t.Errorf("expected base %s, got %s", forkPoint, cmp.base.commit.Hash)
+	}
+	// Only the feature side change is in the patch
+	if paths := patchedPaths(cmp.patch); len(paths) != 1 || paths[0] != "b.ts" {
+		t.Errorf("expected only b.ts in patch, got %v", paths)
+	}
+}
+
+func TestCommitComparison_Direction(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	writeFile(t, dir, "a.ts", "keep\nold\nkeep\n")
+	commitAll(t, wt, "base")
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("failed to get HEAD: %v", err)
+	}
+	base := head.Hash()
+
+	writeFile(t, dir, "a.ts", "keep\nnew\nkeep\n")
+	writeFile(t, dir, "b.ts", "added\n")
+	commitAll(t, wt, "head")
+
+	cmp, err := commitComparison(repo, base.String(), "HEAD", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Lines only in head are additions on head's functions, lines only in
+	// base are deletions on base's
+	baseFunctions := []FunctionRange{
+		{ControllerName: "GET old", Filename: "a.ts", StartLine: 2, EndLine: 2},
+	}
+	headFunctions := []FunctionRange{
+		{ControllerName: "GET new", Filename: "a.ts", StartLine: 2, EndLine: 2},
+		{ControllerName: "GET b", Filename: "b.ts", StartLine: 1, EndLine: 1},
+	}
+	result := findChangedFunctions(cmp.patch, baseFunctions, headFunctions)
+	if len(result.Added) != 2 || result.Added[0] != "GET b" || result.Added[1] != "GET new" {
+		t.Errorf("expected GET b and GET new added, got %v", result.Added)
+	}
+	if len(result.Removed) != 1 || result.Removed[0] != "GET old" {
+		t.Errorf("expected only GET old removed, got %v", result.Removed)
+	}
+}
+
+func TestFindChangedFunctions_Renames(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	if err := os.MkdirAll(filepath.Join(dir, "src", "users"), 0755); err != nil {
+		t.Fatalf("failed to create dirs: %v", err)
+	}
+	body := "line 1\nline 2\nline 3\nline 4\nline 5\nline 6\nline 7\nline 8\nline 9\nline 10\n"
+	writeFile(t, dir, "src/user.service.ts", body)
+	writeFile(t, dir, "src/other.ts", body)
+	commitAll(t, wt, "initial")
+	head := func() *object.Commit {
+		ref, err := repo.Head()
+		if err != nil {
+			t.Fatalf("failed to get HEAD: %v", err)
+		}
+		c, err := repo.CommitObject(ref.Hash())
+		if err != nil {
+			t.Fatalf("failed to get commit: %v", err)
+		}
+		return c
+	}
+	base := head()
+
+	// Move one file and drop a line from it, move the other untouched
+	if err := os.Remove(filepath.Join(dir, "src", "user.service.ts")); err != nil {
+		t.Fatalf("failed to remove: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "src", "other.ts")); err != nil {
+		t.Fatalf("failed to remove: %v", err)
+	}
+	writeFile(t, dir, "src/users/user.service.ts", strings.Replace(body, "line 8\n", "", 1))
+	writeFile(t, dir, "src/users/other.ts", body)
+	if _, err := wt.Add("src"); err != nil {
+		t.Fatalf("failed to stage: %v", err)
+	}
+	commitAll(t, wt, "move")
+
+	patch, err := diffCommits(base, head())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	baseFunctions := []FunctionRange{
+		{ControllerName: "GET users", Filename: "src/user.service.ts", StartLine: 1, EndLine: 4},
+		{ControllerName: "DELETE users", Filename: "src/user.service.ts", StartLine: 7, EndLine: 9},
+	}
+	headFunctions := []FunctionRange{
+		{ControllerName: "GET users", Filename: "src/users/user.service.ts", StartLine: 1, EndLine: 4},
+		{ControllerName: "DELETE users", Filename: "src/users/user.service.ts", StartLine: 7, EndLine: 8},
+		{ControllerName: "GET other", Filename: "src/users/other.ts", StartLine: 1, EndLine: 10},
+	}
+
+	result := findChangedFunctions(patch, baseFunctions, headFunctions)
+	if len(result.Added) != 0 {
+		t.Errorf("expected no additions, got %v", result.Added)
+	}
+	if len(result.Removed) != 1 || result.Removed[0] != "DELETE users" {
+		t.Errorf("expected only DELETE users removed, got %v", result.Removed)
+	}
+	if len(result.Renames) != 1 || result.Renames[0].From != "src/other.ts" || result.Renames[0].To != "src/users/other.ts" {
+		t.Fatalf("expected src/other.ts rename, got %+v", result.Renames)
+	}
+	if eps := result.Renames[0].Endpoints; len(eps) != 1 || eps[0] != "GET other" {
+		t.Errorf("expected GET other for rename, got %v", eps)
+	}
+}
+
+func TestFindChangedFunctions_SeparateLineCounters(t *testing.T) {
+	var base, head strings.Builder
+	for i := 1; i <= 30; i++ {
+		fmt.Fprintf(&base, "l%d\n", i)
+		switch {
+		case i >= 2 && i <= 11:
+			// Long block deleted near the top
+		case i == 15:
+			head.WriteString("L15\n")
+		default:
+			fmt.Fprintf(&head, "l%d\n", i)
+		}
+	}
+	patch := &uncommitted{filePatches: []fdiff.FilePatch{
+		newUncommittedFilePatch("a.ts", []byte(base.String()), true, []byte(head.String()), true),
+	}}
+
+	baseFunctions := []FunctionRange{
+		{ControllerName: "DELETED", Filename: "a.ts", StartLine: 2, EndLine: 11},
+		{ControllerName: "FIXED", Filename: "a.ts", StartLine: 15, EndLine: 15},
+	}
+	// l15 sits on head line 5; head line 16 is where a shared counter lands
+	headFunctions := []FunctionRange{
+		{ControllerName: "FIXED", Filename: "a.ts", StartLine: 5, EndLine: 5},
+		{ControllerName: "UNRELATED", Filename: "a.ts", StartLine: 16, EndLine: 18},
+	}
+
+	result := findChangedFunctions(patch, baseFunctions, headFunctions)
+	if len(result.Added) != 1 || result.Added[0] != "FIXED" {
+		t.Errorf("expected only FIXED added, got %v", result.Added)
+	}
+	if len(result.Removed) != 2 || result.Removed[0] != "DELETED" || result.Removed[1] != "FIXED" {
+		t.Errorf("expected DELETED and FIXED removed, got %v", result.Removed)
+	}
+}
+
+func TestCountLines(t *testing.T) {
+	tests := map[string]int{
+		"":       0,
+		"a\n":    1,
+		"a\nb\n": 2,
+		"a\nb":   2,
+		"\n\n":   2,
+	}
+	for content, want := range tests {
+		if got := countLines(content); got != want {
+			t.Errorf("countLines(%q) = %d, want %d", content, got, want)
+		}
+	}
+}
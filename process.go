@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+var (
+	errTimedOut    = errors.New("analysis timed out")
+	errInterrupted = errors.New("interrupted")
+)
+
+// Exit codes for runs that were stopped rather than failing, following
+// timeout(1) and the shell's 128+SIGINT.
+const (
+	exitTimedOut    = 124
+	exitInterrupted = 130
+)
+
+// exitStatus returns the exit code for a run that failed with err.
+func exitStatus(err error) int {
+	switch {
+	case errors.Is(err, errTimedOut):
+		return exitTimedOut
+	case errors.Is(err, errInterrupted):
+		return exitInterrupted
+	}
+	return 1
+}
+
+// analyzerWaitDelay is how long Wait gives the analyzer's output to drain
+// once it has been killed, in case something outside its process group
+// still holds the other end.
+const analyzerWaitDelay = 2 * time.Second
+
+// startInProcessGroup starts cmd, which must have been made with
+// exec.CommandContext, as the leader of a new process group. Cancelling the
+// context kills the whole group, so workers spawned by npx or bun do not
+// outlive the analyzer.
+func startInProcessGroup(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	cmd.WaitDelay = analyzerWaitDelay
+	return cmd.Start()
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// openPipe opens the read side of the named pipe, which blocks until the
+// analyzer opens the write side. If ctx ends first, because the analyzer
+// exited or the run was stopped, the write side is opened from here so the
+// open returns; reading the pipe then finds whatever the analyzer managed
+// to send, or nothing.
+func openPipe(ctx context.Context, pipeName string) (*os.File, error) {
+	type result struct {
+		file *os.File
+		err  error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		file, err := os.OpenFile(pipeName, os.O_RDONLY, os.ModeNamedPipe)
+		opened <- result{file, err}
+	}()
+
+	select {
+	case r := <-opened:
+		return r.file, r.err
+	case <-ctx.Done():
+	}
+
+	// A non-blocking open for writing fails until the reader is in open
+	// itself, so keep trying until it goes through
+	for {
+		if w, err := os.OpenFile(pipeName, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			w.Close()
+		}
+		select {
+		case r := <-opened:
+			return r.file, r.err
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("analyzer (head): %w", errTimedOut), exitTimedOut},
+		{fmt.Errorf("analyzer (base): %w", errInterrupted), exitInterrupted},
+		{errors.New("exit status 1"), 1},
+	}
+	for _, tt := range tests {
+		if got := exitStatus(tt.err); got != tt.want {
+			t.Errorf("exitStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestOpenPipe_Cancelled(t *testing.T) {
+	pipeName := filepath.Join(t.TempDir(), "analyzer.pipe")
+	if err := syscall.Mkfifo(pipeName, 0600); err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+
+	// Nothing ever opens the write side
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	pipe, err := openPipe(ctx, pipeName)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer pipe.Close()
+
+	data, err := io.ReadAll(pipe)
+	if err != nil || len(data) != 0 {
+		t.Errorf("expected an empty pipe, got %q, %v", data, err)
+	}
+}
+
+func TestStartInProcessGroup_KillsChildren(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// The shell's child keeps stdout open, so Wait only returns once the
+	// whole group is gone
+	cmd := exec.CommandContext(ctx, "sh", "-c", "sleep 60 & echo $!; wait")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("failed to get stdout: %v", err)
+	}
+	if err := startInProcessGroup(cmd); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	var child int
+	if _, err := fmt.Fscan(out, &child); err != nil {
+		t.Fatalf("failed to read child pid: %v", err)
+	}
+
+	start := time.Now()
+	cancel()
+	cmd.Wait()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the group to be killed promptly, took %v", elapsed)
+	}
+
+	// The grandchild is reparented once the shell dies and may linger as
+	// a zombie until reaped, which counts as killed
+	for i := 0; i < 50; i++ {
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", child))
+		if err != nil || bytes.Contains(stat, []byte(") Z ")) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if p, err := os.FindProcess(child); err == nil {
+		p.Kill()
+	}
+	t.Errorf("expected child %d to be killed with its group", child)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// protocolVersion is the version of the analyzer protocol this binary
+// speaks. Bump it together with PROTOCOL_VERSION in
+// ts_src/helpers/pipe-pusher.ts whenever a message changes shape.
+const protocolVersion = 2
+
+// The analyzer writes one JSON message per line. The stream opens with a
+// hello carrying the protocol version and ends with a done carrying the
+// number of routes and functions sent, so a crash part way through is never
+// mistaken for a project with fewer endpoints.
+const (
+	msgHello    = "hello"
+	msgRoute    = "route"
+	msgFunction = "function"
+	msgWarning  = "warning"
+	msgProgress = "progress"
+	msgError    = "error"
+	msgDone     = "done"
+
+	// dependencies lists the files an endpoint's call graph was built
+	// from, so a later run can tell whether it still holds
+	msgDependencies = "dependencies"
+)
+
+// analyzerMessage is a single line of analyzer output. Which fields are set
+// depends on Type.
+type analyzerMessage struct {
+	Type string `json:"type"`
+
+	// hello
+	Protocol int    `json:"protocol,omitempty"`
+	Analyzer string `json:"analyzer,omitempty"` // analyzer version, for error messages
+
+	// route
+	Endpoint   string `json:"endpoint,omitempty"`
+	Controller string `json:"controller,omitempty"`
+	Handler    string `json:"handler,omitempty"`
+	File       string `json:"file,omitempty"`
+
+	// function
+	Function *FunctionRange `json:"function,omitempty"`
+
+	// warning, progress and error
+	Message string `json:"message,omitempty"`
+
+	// warning, which also sets File and Endpoint
+	Kind   string `json:"kind,omitempty"`
+	Symbol string `json:"symbol,omitempty"`
+
+	// dependencies, which also sets Endpoint
+	Files []string `json:"files,omitempty"`
+
+	// progress
+	Current int `json:"current,omitempty"`
+	Total   int `json:"total,omitempty"`
+
+	// done
+	Routes    int `json:"routes,omitempty"`
+	Functions int `json:"functions,omitempty"`
+}
+
+// Kinds of warning that pit summarizes at the end of a run.
+const (
+	warnUnresolvedSymbol = "unresolved-symbol"
+	warnSkippedFile      = "skipped-file"
+)
+
+// analyzerWarning is a problem the analyzer worked around, such as a call it
+// could not resolve. Kind is empty for anything not worth summarizing, and
+// Endpoint for anything found outside a call graph.
+type analyzerWarning struct {
+	Kind     string
+	Message  string
+	File     string
+	Symbol   string
+	Endpoint string
+}
+
+// analyzerOutput is everything a complete analyzer run reported.
+type analyzerOutput struct {
+	Analyzer     string
+	Routes       []string // endpoints in the order they were discovered
+	Functions    []FunctionRange
+	Warnings     []analyzerWarning
+	Dependencies map[string][]string // endpoint to the files its call graph depends on
+}
+
+// ProtocolError is returned when the analyzer's output does not follow the
+// protocol, including when it stops before sending done.
+type ProtocolError struct {
+	Line int // 1-based line of the offending message, 0 at end of stream
+	Msg  string
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Line == 0 {
+		return "analyzer protocol: " + e.Msg
+	}
+	return fmt.Sprintf("analyzer protocol: line %d: %s", e.Line, e.Msg)
+}
+
+// readAnalyzerOutput reads and validates an analyzer stream from r.
+// notify, if not nil, is called as each progress and warning message
+// arrives. An error message from the analyzer is returned as an error.
+func readAnalyzerOutput(r io.Reader, notify func(analyzerMessage)) (*analyzerOutput, error) {
+	out := &analyzerOutput{}
+	reader := bufio.NewReader(r)
+	line := 0
+	done := false
+
+	for {
+		data, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			line++
+			if done {
+				return nil, &ProtocolError{line, "message after done"}
+			}
+
+			var msg analyzerMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return nil, &ProtocolError{line, fmt.Sprintf("invalid JSON: %v", err)}
+			}
+			if line == 1 && msg.Type != msgHello {
+				return nil, &ProtocolError{line, fmt.Sprintf("expected hello, got %q", msg.Type)}
+			}
+
+			switch msg.Type {
+			case msgHello:
+				if line != 1 {
+					return nil, &ProtocolError{line, "unexpected hello"}
+				}
+				if msg.Protocol != protocolVersion {
+					return nil, &ProtocolError{line, fmt.Sprintf("analyzer %s speaks protocol version %d, pit expects %d; reinstall pit so the binary and analyzer match", msg.Analyzer, msg.Protocol, protocolVersion)}
+				}
+				out.Analyzer = msg.Analyzer
+			case msgRoute:
+				if msg.Endpoint == "" {
+					return nil, &ProtocolError{line, "route without endpoint"}
+				}
+				out.Routes = append(out.Routes, msg.Endpoint)
+			case msgFunction:
+				if err := validateFunction(msg.Function); err != nil {
+					return nil, &ProtocolError{line, err.Error()}
+				}
+				out.Functions = append(out.Functions, *msg.Function)
+			case msgWarning:
+				out.Warnings = append(out.Warnings, analyzerWarning{
+					Kind:     msg.Kind,
+					Message:  msg.Message,
+					File:     msg.File,
+					Symbol:   msg.Symbol,
+					Endpoint: msg.Endpoint,
+				})
+				if notify != nil {
+					notify(msg)
+				}
+			case msgDependencies:
+				if msg.Endpoint == "" {
+					return nil, &ProtocolError{line, "dependencies without endpoint"}
+				}
+				if out.Dependencies == nil {
+					out.Dependencies = make(map[string][]string)
+				}
+				out.Dependencies[msg.Endpoint] = append(out.Dependencies[msg.Endpoint], msg.Files...)
+			case msgProgress:
+				if notify != nil {
+					notify(msg)
+				}
+			case msgError:
+				return nil, fmt.Errorf("analyzer failed: %s", msg.Message)
+			case msgDone:
+				if msg.Routes != len(out.Routes) || msg.Functions != len(out.Functions) {
+					return nil, &ProtocolError{line, fmt.Sprintf("done reports %d routes and %d functions, received %d and %d", msg.Routes, msg.Functions, len(out.Routes), len(out.Functions))}
+				}
+				done = true
+			default:
+				return nil, &ProtocolError{line, fmt.Sprintf("unknown message type %q", msg.Type)}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading analyzer output: %w", err)
+		}
+	}
+
+	if line == 0 {
+		return nil, &ProtocolError{0, "analyzer exited without output"}
+	}
+	if !done {
+		return nil, &ProtocolError{0, fmt.Sprintf("output ended before done after %d routes and %d functions", len(out.Routes), len(out.Functions))}
+	}
+	return out, nil
+}
+
+func validateFunction(fn *FunctionRange) error {
+	switch {
+	case fn == nil:
+		return fmt.Errorf("function message without function")
+	case fn.Filename == "":
+		return fmt.Errorf("function %s without filename", fn.FunctionName)
+	case fn.StartLine < 1 || fn.EndLine < fn.StartLine:
+		return fmt.Errorf("function %s has invalid range %d-%d", fn.FunctionName, fn.StartLine, fn.EndLine)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testHello = `{"type":"hello","protocol":2,"analyzer":"1.0.0"}`
+
+func TestReadAnalyzerOutput(t *testing.T) {
+	stream := strings.Join([]string{
+		testHello,
+		`{"type":"progress","current":1,"total":1,"message":"GET /users"}`,
+		`{"type":"route","endpoint":"GET /users","controller":"UsersController","handler":"findAll"}`,
+		`{"type":"function","function":{"ControllerName":"GET /users","FunctionName":"findAll","Filename":"/src/users.controller.ts","StartLine":10,"EndLine":14}}`,
+		`{"type":"warning","message":"could not resolve this.repo.find","kind":"unresolved-symbol","file":"/src/users.service.ts","symbol":"this.repo.find","endpoint":"GET /users"}`,
+		`{"type":"dependencies","endpoint":"GET /users","files":["/src/users.controller.ts","/src/users.service.ts"]}`,
+		`{"type":"done","routes":1,"functions":1}`,
+	}, "\n") + "\n"
+
+	var notified []string
+	out, err := readAnalyzerOutput(strings.NewReader(stream), func(msg analyzerMessage) {
+		notified = append(notified, msg.Type)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.Analyzer != "1.0.0" {
+		t.Errorf("expected analyzer version 1.0.0, got %q", out.Analyzer)
+	}
+	if len(out.Routes) != 1 || out.Routes[0] != "GET /users" {
+		t.Errorf("unexpected routes: %v", out.Routes)
+	}
+	if len(out.Functions) != 1 || out.Functions[0].FunctionName != "findAll" || out.Functions[0].EndLine != 14 {
+		t.Errorf("unexpected functions: %+v", out.Functions)
+	}
+	if len(out.Warnings) != 1 || out.Warnings[0].Kind != warnUnresolvedSymbol || out.Warnings[0].Symbol != "this.repo.find" || out.Warnings[0].Endpoint != "GET /users" {
+		t.Errorf("unexpected warnings: %+v", out.Warnings)
+	}
+	if deps := out.Dependencies["GET /users"]; len(deps) != 2 || deps[1] != "/src/users.service.ts" {
+		t.Errorf("unexpected dependencies: %v", out.Dependencies)
+	}
+	if strings.Join(notified, ",") != "progress,warning" {
+		t.Errorf("expected progress and warning notifications, got %v", notified)
+	}
+}
+
+func TestReadAnalyzerOutput_Invalid(t *testing.T) {
+	function := `{"type":"function","function":{"FunctionName":"f","Filename":"a.ts","StartLine":1,"EndLine":2}}`
+	tests := []struct {
+		name   string
+		lines  []string
+		expect string
+	}{
+		{"empty", nil, "without output"},
+		{"no hello", []string{`{"type":"done"}`}, "expected hello"},
+		{"version mismatch", []string{`{"type":"hello","protocol":1,"analyzer":"0.9.0"}`}, "protocol version 1"},
+		{"truncated", []string{testHello, function}, "ended before done"},
+		{"count mismatch", []string{testHello, function, `{"type":"done","functions":2}`}, "received 0 and 1"},
+		{"after done", []string{testHello, `{"type":"done"}`, function}, "after done"},
+		{"unknown type", []string{testHello, `{"type":"mystery"}`}, "unknown message type"},
+		{"bad range", []string{testHello, `{"type":"function","function":{"Filename":"a.ts","StartLine":5,"EndLine":2}}`}, "invalid range"},
+		{"dependencies without endpoint", []string{testHello, `{"type":"dependencies","files":["a.ts"]}`}, "without endpoint"},
+		{"bad json", []string{testHello, `{"type":`}, "invalid JSON"},
+		{"analyzer error", []string{testHello, `{"type":"error","message":"no entrypoint"}`}, "no entrypoint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := strings.Join(tt.lines, "\n")
+			_, err := readAnalyzerOutput(strings.NewReader(stream), nil)
+			if err == nil {
+				t.Fatalf("expected error containing %q", tt.expect)
+			}
+			if !strings.Contains(err.Error(), tt.expect) {
+				t.Errorf("expected error containing %q, got %v", tt.expect, err)
+			}
+		})
+	}
+
+	// Anything but an error message from the analyzer is a protocol error
+	_, err := readAnalyzerOutput(strings.NewReader(testHello), nil)
+	var protoErr *ProtocolError
+	if !errors.As(err, &protoErr) {
+		t.Errorf("expected a ProtocolError, got %v", err)
+	}
+}
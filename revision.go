@@ -0,0 +1,609 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// RevisionError reports which part of a revision could not be resolved.
+type RevisionError struct {
+	Revision string // the full revision as given
+	Part     string // the part that failed, e.g. "main@{5}" or "^{tree}"
+	Err      error
+}
+
+func (e *RevisionError) Error() string {
+	if e.Part == e.Revision {
+		return fmt.Sprintf("cannot resolve %q: %v", e.Revision, e.Err)
+	}
+	return fmt.Sprintf("cannot resolve %q in %q: %v", e.Part, e.Revision, e.Err)
+}
+
+func (e *RevisionError) Unwrap() error {
+	return e.Err
+}
+
+// resolveGitRef resolves a git revision to the commit it names. Tags are
+// peeled; revisions naming a tree or blob are an error.
+func resolveGitRef(repo *git.Repository, refName string) (*object.Commit, error) {
+	hash, err := resolveRevision(repo, refName)
+	if err != nil {
+		return nil, err
+	}
+	hash, err = peelTo(repo, hash, plumbing.CommitObject)
+	if err != nil {
+		return nil, &RevisionError{Revision: refName, Part: refName, Err: err}
+	}
+	return repo.CommitObject(hash)
+}
+
+// resolveRevision resolves the subset of gitrevisions(7) that pit supports
+// to an object hash:
+//
+//	<sha1>, <short-sha1>, <refname>, @
+//	<refname>@{<n>}, @{<n>}, @{-<n>}, <branch>@{upstream}, @{u}
+//	<rev>^<n>, <rev>~<n>, <rev>^{<type>}, <rev>^{}, <rev>^{/<regex>}
+//	:/<regex>
+func resolveRevision(repo *git.Repository, rev string) (plumbing.Hash, error) {
+	fail := func(part string, err error) (plumbing.Hash, error) {
+		return plumbing.ZeroHash, &RevisionError{Revision: rev, Part: part, Err: err}
+	}
+
+	if rev == "" {
+		return fail(rev, errors.New("empty revision"))
+	}
+
+	// :/<regex> searches every ref for the youngest matching commit
+	if strings.HasPrefix(rev, ":/") {
+		hash, err := searchAllRefs(repo, rev[2:])
+		if err != nil {
+			return fail(rev, err)
+		}
+		return hash, nil
+	}
+
+	// The base name runs until the first suffix operator
+	end := len(rev)
+	for i := 0; i < len(rev); i++ {
+		if rev[i] == '^' || rev[i] == '~' || rev[i] == ':' || strings.HasPrefix(rev[i:], "@{") {
+			end = i
+			break
+		}
+	}
+	name, rest := rev[:end], rev[end:]
+	if name == "@" {
+		name = "HEAD"
+	}
+
+	var hash plumbing.Hash
+	if strings.HasPrefix(rest, "@{") {
+		close := closingBrace(rest)
+		if close < 0 {
+			return fail(rev, errors.New("unterminated @{...} selector"))
+		}
+		selector := rest[2:close]
+		part := name + rest[:close+1]
+		rest = rest[close+1:]
+
+		var err error
+		hash, err = resolveSelector(repo, name, selector)
+		if err != nil {
+			return fail(part, err)
+		}
+	} else {
+		var err error
+		hash, err = resolveName(repo, name)
+		if err != nil {
+			return fail(name, err)
+		}
+	}
+
+	for rest != "" {
+		var part string
+		var err error
+		switch {
+		case strings.HasPrefix(rest, "^{"):
+			close := closingBrace(rest)
+			if close < 0 {
+				return fail(rest, errors.New("unterminated ^{...}"))
+			}
+			part, rest = rest[:close+1], rest[close+1:]
+			hash, err = applyPeel(repo, hash, part[2:len(part)-1])
+		case rest[0] == '^':
+			var n int
+			part, rest, n = splitCount(rest)
+			hash, err = nthParent(repo, hash, n)
+		case rest[0] == '~':
+			var n int
+			part, rest, n = splitCount(rest)
+			hash, err = nthAncestor(repo, hash, n)
+		default:
+			part = rest
+			err = errors.New("unsupported revision syntax")
+			rest = ""
+		}
+		if err != nil {
+			return fail(part, err)
+		}
+	}
+
+	return hash, nil
+}
+
+// closingBrace returns the index of the } closing the @{ or ^{ that rest
+// starts with, or -1. Like git, which reads suffixes from the end, it takes
+// the last } before the next ^{, so ^{/a{2}} keeps the braces of its regex.
+func closingBrace(rest string) int {
+	end := len(rest)
+	if next := strings.Index(rest[2:], "^{"); next >= 0 {
+		end = next + 2
+	}
+	return strings.LastIndexByte(rest[:end], '}')
+}
+
+// splitCount splits a "^<n>" or "~<n>" operator off the front of s. A
+// missing count means 1.
+func splitCount(s string) (part, rest string, n int) {
+	i := 1
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n = 1
+	if i > 1 {
+		n, _ = strconv.Atoi(s[1:i])
+	}
+	return s[:i], s[i:], n
+}
+
+// resolveName resolves a bare hash, short hash or ref name using git's
+// ref lookup order.
+func resolveName(repo *git.Repository, name string) (plumbing.Hash, error) {
+	if len(name) == 40 && isHex(name) {
+		hash := plumbing.NewHash(name)
+		if _, err := repo.Storer.EncodedObject(plumbing.AnyObject, hash); err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("object %s not found", name)
+		}
+		return hash, nil
+	}
+
+	if ref, err := expandRef(repo, name); err == nil {
+		return ref.Hash(), nil
+	}
+
+	if len(name) >= 4 && isHex(name) {
+		return resolveShortHash(repo, name)
+	}
+	return plumbing.ZeroHash, errors.New("unknown revision or ref")
+}
+
+// expandRef finds the ref a short name refers to, following symbolic refs.
+func expandRef(repo *git.Repository, name string) (*plumbing.Reference, error) {
+	for _, rule := range plumbing.RefRevParseRules {
+		ref, err := repo.Reference(plumbing.ReferenceName(fmt.Sprintf(rule, name)), true)
+		if err == nil {
+			return ref, nil
+		}
+	}
+	return nil, plumbing.ErrReferenceNotFound
+}
+
+// hashPrefixStorer is implemented by go-git's filesystem storage, which
+// finds short hashes in the loose object directory and pack indexes
+// without reading every object.
+type hashPrefixStorer interface {
+	HashesWithPrefix(prefix []byte) ([]plumbing.Hash, error)
+}
+
+// resolveShortHash resolves an abbreviated object name, failing if more
+// than one object starts with it.
+func resolveShortHash(repo *git.Repository, prefix string) (plumbing.Hash, error) {
+	st, ok := repo.Storer.(hashPrefixStorer)
+	if !ok {
+		// Other storages can only resolve commits by short hash
+		hash, err := repo.ResolveRevision(plumbing.Revision(prefix))
+		if err != nil {
+			return plumbing.ZeroHash, errors.New("unknown revision or ref")
+		}
+		return *hash, nil
+	}
+
+	// Only whole bytes can be searched for; an odd last digit is checked
+	// against each candidate
+	b, err := hex.DecodeString(prefix[:len(prefix)&^1])
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	candidates, err := st.HashesWithPrefix(b)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	// An object can be both loose and packed, or in more than one pack
+	matches := make(map[plumbing.Hash]bool)
+	var match plumbing.Hash
+	for _, hash := range candidates {
+		if strings.HasPrefix(hash.String(), prefix) {
+			matches[hash] = true
+			match = hash
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return plumbing.ZeroHash, errors.New("unknown revision or ref")
+	case 1:
+		return match, nil
+	}
+	return plumbing.ZeroHash, fmt.Errorf("short hash %s is ambiguous", prefix)
+}
+
+// resolveSelector resolves the @{...} selector that follows name.
+func resolveSelector(repo *git.Repository, name, selector string) (plumbing.Hash, error) {
+	switch {
+	case strings.HasPrefix(selector, "-"):
+		if name != "" {
+			return plumbing.ZeroHash, errors.New("@{-<n>} cannot follow a ref name")
+		}
+		n, err := strconv.Atoi(selector[1:])
+		if err != nil || n < 1 {
+			return plumbing.ZeroHash, fmt.Errorf("invalid previous-branch selector %q", selector)
+		}
+		branch, err := previousBranch(repo, n)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return resolveName(repo, branch)
+
+	case strings.EqualFold(selector, "upstream") || strings.EqualFold(selector, "u"):
+		ref, err := upstreamRef(repo, name)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return ref.Hash(), nil
+	}
+
+	n, err := strconv.Atoi(selector)
+	if err != nil || n < 0 {
+		return plumbing.ZeroHash, fmt.Errorf("unsupported selector @{%s}; only reflog indexes, @{-<n>} and @{upstream} are supported", selector)
+	}
+
+	// @{<n>} on its own means the current branch, not HEAD
+	var refName plumbing.ReferenceName
+	if name == "" {
+		head, err := repo.Reference(plumbing.HEAD, false)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		refName = plumbing.HEAD
+		if head.Type() == plumbing.SymbolicReference {
+			refName = head.Target()
+		}
+	} else if name == "HEAD" {
+		refName = plumbing.HEAD
+	} else {
+		ref, err := expandRef(repo, name)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("unknown ref %q", name)
+		}
+		refName = ref.Name()
+	}
+
+	entries, err := readReflog(repo, refName)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if n >= len(entries) {
+		return plumbing.ZeroHash, fmt.Errorf("reflog of %s has only %d entries", refName, len(entries))
+	}
+	return entries[len(entries)-1-n].newHash, nil
+}
+
+// upstreamRef returns the remote-tracking ref configured as the upstream of
+// branch, or of the current branch when branch is empty.
+func upstreamRef(repo *git.Repository, branch string) (*plumbing.Reference, error) {
+	if branch == "" || branch == "HEAD" {
+		head, err := repo.Reference(plumbing.HEAD, false)
+		if err != nil {
+			return nil, err
+		}
+		if head.Type() != plumbing.SymbolicReference {
+			return nil, errors.New("HEAD is detached and has no upstream")
+		}
+		branch = head.Target().Short()
+	}
+	branch = strings.TrimPrefix(branch, "refs/heads/")
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	b, ok := cfg.Branches[branch]
+	if !ok || b.Merge == "" {
+		return nil, fmt.Errorf("no upstream configured for branch %q", branch)
+	}
+
+	// A remote of "." tracks another local branch
+	target := b.Merge
+	if b.Remote != "." {
+		remote, ok := cfg.Remotes[b.Remote]
+		if !ok {
+			return nil, fmt.Errorf("upstream remote %q of branch %q does not exist", b.Remote, branch)
+		}
+		mapped := false
+		for _, spec := range remote.Fetch {
+			if spec.Match(b.Merge) {
+				target = spec.Dst(b.Merge)
+				mapped = true
+				break
+			}
+		}
+		if !mapped {
+			return nil, fmt.Errorf("upstream %s of branch %q is not fetched by remote %q", b.Merge, branch, b.Remote)
+		}
+	}
+
+	ref, err := repo.Reference(target, true)
+	if err != nil {
+		return nil, fmt.Errorf("upstream %s of branch %q: %w", target, branch, err)
+	}
+	return ref, nil
+}
+
+// previousBranch returns the nth branch checked out before the current one,
+// as recorded by checkouts in the HEAD reflog.
+func previousBranch(repo *git.Repository, n int) (string, error) {
+	entries, err := readReflog(repo, plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+
+	const prefix = "checkout: moving from "
+	seen := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		msg := entries[i].message
+		if !strings.HasPrefix(msg, prefix) {
+			continue
+		}
+		seen++
+		if seen == n {
+			from, _, _ := strings.Cut(strings.TrimPrefix(msg, prefix), " to ")
+			return from, nil
+		}
+	}
+	return "", fmt.Errorf("only %d previous checkouts in the HEAD reflog", seen)
+}
+
+type reflogEntry struct {
+	oldHash, newHash plumbing.Hash
+	message          string
+}
+
+// readReflog reads the reflog of refName, oldest entry first. go-git does
+// not maintain reflogs, so this reads the files git itself writes.
+func readReflog(repo *git.Repository, refName plumbing.ReferenceName) ([]reflogEntry, error) {
+	fsStorer, ok := repo.Storer.(interface{ Filesystem() billy.Filesystem })
+	if !ok {
+		return nil, errors.New("reflogs are only available for on-disk repositories")
+	}
+
+	file, err := fsStorer.Filesystem().Open("logs/" + refName.String())
+	if err != nil {
+		return nil, fmt.Errorf("no reflog for %s", refName)
+	}
+	defer file.Close()
+
+	var entries []reflogEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 82 {
+			continue
+		}
+		entry := reflogEntry{
+			oldHash: plumbing.NewHash(line[:40]),
+			newHash: plumbing.NewHash(line[41:81]),
+		}
+		if _, msg, ok := strings.Cut(line, "\t"); ok {
+			entry.message = msg
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// applyPeel implements ^{<type>}, ^{} and ^{/<regex>}.
+func applyPeel(repo *git.Repository, hash plumbing.Hash, spec string) (plumbing.Hash, error) {
+	switch {
+	case spec == "":
+		return peelTags(repo, hash)
+	case spec == "object":
+		_, err := repo.Storer.EncodedObject(plumbing.AnyObject, hash)
+		return hash, err
+	case strings.HasPrefix(spec, "/"):
+		commitHash, err := peelTo(repo, hash, plumbing.CommitObject)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return searchMessages(repo, []plumbing.Hash{commitHash}, spec[1:])
+	}
+
+	objType, err := plumbing.ParseObjectType(spec)
+	if err != nil || objType == plumbing.OFSDeltaObject || objType == plumbing.REFDeltaObject {
+		return plumbing.ZeroHash, fmt.Errorf("unknown object type %q", spec)
+	}
+	return peelTo(repo, hash, objType)
+}
+
+// peelTags follows tag objects, including tags of tags, to what they point at.
+func peelTags(repo *git.Repository, hash plumbing.Hash) (plumbing.Hash, error) {
+	for {
+		obj, err := object.GetObject(repo.Storer, hash)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		tag, ok := obj.(*object.Tag)
+		if !ok {
+			return hash, nil
+		}
+		hash = tag.Target
+	}
+}
+
+// peelTo peels hash until it reaches an object of type want.
+func peelTo(repo *git.Repository, hash plumbing.Hash, want plumbing.ObjectType) (plumbing.Hash, error) {
+	for {
+		obj, err := object.GetObject(repo.Storer, hash)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if obj.Type() == want {
+			return hash, nil
+		}
+		switch o := obj.(type) {
+		case *object.Tag:
+			hash = o.Target
+		case *object.Commit:
+			if want != plumbing.TreeObject {
+				return plumbing.ZeroHash, fmt.Errorf("%s is a commit, not a %s", shortHash(hash), want)
+			}
+			hash = o.TreeHash
+		default:
+			return plumbing.ZeroHash, fmt.Errorf("%s is a %s, not a %s", shortHash(hash), obj.Type(), want)
+		}
+	}
+}
+
+func nthParent(repo *git.Repository, hash plumbing.Hash, n int) (plumbing.Hash, error) {
+	hash, err := peelTo(repo, hash, plumbing.CommitObject)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if n == 0 {
+		return hash, nil
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if n > commit.NumParents() {
+		return plumbing.ZeroHash, fmt.Errorf("%s has %d parent(s)", shortHash(hash), commit.NumParents())
+	}
+	return commit.ParentHashes[n-1], nil
+}
+
+func nthAncestor(repo *git.Repository, hash plumbing.Hash, n int) (plumbing.Hash, error) {
+	hash, err := peelTo(repo, hash, plumbing.CommitObject)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	for i := 0; i < n; i++ {
+		hash, err = nthParent(repo, hash, 1)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	return hash, nil
+}
+
+// searchAllRefs implements :/<regex>, searching from every ref and HEAD.
+func searchAllRefs(repo *git.Repository, pattern string) (plumbing.Hash, error) {
+	refs, err := repo.References()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	var starts []plumbing.Hash
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		if hash, err := peelTo(repo, ref.Hash(), plumbing.CommitObject); err == nil {
+			starts = append(starts, hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if head, err := repo.Head(); err == nil {
+		starts = append(starts, head.Hash())
+	}
+	return searchMessages(repo, starts, pattern)
+}
+
+// searchMessages returns the youngest commit reachable from starts whose
+// message matches pattern. A leading "!!" matches a literal "!", and "!-"
+// inverts the match, as in git.
+func searchMessages(repo *git.Repository, starts []plumbing.Hash, pattern string) (plumbing.Hash, error) {
+	negate := false
+	switch {
+	case strings.HasPrefix(pattern, "!-"):
+		negate = true
+		pattern = pattern[2:]
+	case strings.HasPrefix(pattern, "!!"):
+		pattern = pattern[1:]
+	case strings.HasPrefix(pattern, "!"):
+		return plumbing.ZeroHash, fmt.Errorf("unsupported message search modifier in %q", pattern)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("invalid message pattern: %w", err)
+	}
+	if len(starts) == 0 {
+		return plumbing.ZeroHash, errors.New("no commits to search")
+	}
+
+	start, err := repo.CommitObject(starts[0])
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	iter := object.NewCommitIterCTime(start, nil, nil)
+	if len(starts) > 1 {
+		// Walk every start in committer-time order, like git log --all
+		iter, err = repo.Log(&git.LogOptions{All: true, Order: git.LogOrderCommitterTime})
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	defer iter.Close()
+
+	found := plumbing.ZeroHash
+	err = iter.ForEach(func(c *object.Commit) error {
+		if re.MatchString(c.Message) != negate {
+			found = c.Hash
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if found.IsZero() {
+		return plumbing.ZeroHash, fmt.Errorf("no commit message matches %q", pattern)
+	}
+	return found, nil
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s + strings.Repeat("0", len(s)%2))
+	return err == nil
+}
+
+func shortHash(hash plumbing.Hash) string {
+	return hash.String()[:7]
+}
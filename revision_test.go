@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// revisionTestRepo builds three commits on master plus tags, a
+// remote-tracking branch with upstream config, and a reflog.
+func revisionTestRepo(t *testing.T) (*git.Repository, []plumbing.Hash) {
+	t.Helper()
+	dir, repo, wt := initTestRepo(t)
+
+	var commits []plumbing.Hash
+	for i, msg := range []string{"initial", "fix login bug", "add feature"} {
+		writeFile(t, dir, "a.ts", fmt.Sprintf("version %d\n", i))
+		commitAll(t, wt, msg)
+		head, err := repo.Head()
+		if err != nil {
+			t.Fatalf("failed to get HEAD: %v", err)
+		}
+		commits = append(commits, head.Hash())
+	}
+
+	tagger := &object.Signature{Name: "pit", Email: "pit@example.com", When: time.Now()}
+	v1, err := repo.CreateTag("v1", commits[1], &git.CreateTagOptions{Tagger: tagger, Message: "v1"})
+	if err != nil {
+		t.Fatalf("failed to create tag: %v", err)
+	}
+	if _, err := repo.CreateTag("v1-wrapper", v1.Hash(), &git.CreateTagOptions{Tagger: tagger, Message: "tag of a tag"}); err != nil {
+		t.Fatalf("failed to create tag of tag: %v", err)
+	}
+
+	remoteRef := plumbing.NewRemoteReferenceName("origin", "main")
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(remoteRef, commits[0])); err != nil {
+		t.Fatalf("failed to create remote ref: %v", err)
+	}
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{"https://example.com/repo.git"}}); err != nil {
+		t.Fatalf("failed to create remote: %v", err)
+	}
+	if err := repo.CreateBranch(&config.Branch{Name: "master", Remote: "origin", Merge: "refs/heads/main"}); err != nil {
+		t.Fatalf("failed to configure upstream: %v", err)
+	}
+
+	// go-git does not write reflogs, so write one the way git would
+	var reflog strings.Builder
+	prev := plumbing.ZeroHash
+	for _, c := range commits {
+		fmt.Fprintf(&reflog, "%s %s pit <pit@example.com> 1700000000 +0000\tcommit: x\n", prev, c)
+		prev = c
+	}
+	logPath := filepath.Join(dir, ".git", "logs", "refs", "heads", "master")
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		t.Fatalf("failed to create reflog dir: %v", err)
+	}
+	if err := os.WriteFile(logPath, []byte(reflog.String()), 0644); err != nil {
+		t.Fatalf("failed to write reflog: %v", err)
+	}
+
+	return repo, commits
+}
+
+func TestResolveGitRef(t *testing.T) {
+	repo, commits := revisionTestRepo(t)
+
+	tests := []struct {
+		rev  string
+		want plumbing.Hash
+	}{
+		{"HEAD", commits[2]},
+		{"@", commits[2]},
+		{"HEAD^", commits[1]},
+		{"HEAD~2", commits[0]},
+		{"master~1", commits[1]},
+		{"HEAD^1^", commits[0]},
+		{commits[1].String(), commits[1]},
+		{commits[1].String()[:8], commits[1]},
+		{commits[1].String()[:7], commits[1]},
+		{"origin/main", commits[0]},
+		{"@{u}", commits[0]},
+		{"master@{upstream}", commits[0]},
+		{"master@{1}", commits[1]},
+		{"@{2}", commits[0]},
+		{":/fix login", commits[1]},
+		{"HEAD^{/^fix}", commits[1]},
+		{"HEAD^{/^fix lo{1}gin}", commits[1]},
+		{`HEAD^{/login( \{x\})? bug}~1`, commits[0]},
+		{"HEAD^{/^fix}^{commit}", commits[1]},
+		{"master@{1}^{/^init}", commits[0]},
+		{"v1", commits[1]},
+		{"v1-wrapper", commits[1]},
+		{"v1-wrapper^{}", commits[1]},
+		{"v1-wrapper^{commit}~1", commits[0]},
+	}
+
+	for _, tt := range tests {
+		commit, err := resolveGitRef(repo, tt.rev)
+		if err != nil {
+			t.Errorf("resolveGitRef(%q): unexpected error: %v", tt.rev, err)
+			continue
+		}
+		if commit.Hash != tt.want {
+			t.Errorf("resolveGitRef(%q) = %s, want %s", tt.rev, commit.Hash, tt.want)
+		}
+	}
+}
+
+func TestResolveRevision_Tree(t *testing.T) {
+	repo, commits := revisionTestRepo(t)
+	commit, err := repo.CommitObject(commits[2])
+	if err != nil {
+		t.Fatalf("failed to get commit: %v", err)
+	}
+
+	hash, err := resolveRevision(repo, "HEAD^{tree}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hash != commit.TreeHash {
+		t.Errorf("expected tree %s, got %s", commit.TreeHash, hash)
+	}
+
+	// A tree cannot be diffed as a commit
+	if _, err := resolveGitRef(repo, "HEAD^{tree}"); err == nil {
+		t.Errorf("expected error resolving a tree as a commit")
+	}
+}
+
+func TestResolveRevision_Errors(t *testing.T) {
+	repo, _ := revisionTestRepo(t)
+
+	tests := []struct {
+		rev  string
+		part string
+	}{
+		{"nope", "nope"},
+		{"master@{9}", "master@{9}"},
+		{"HEAD~9", "~9"},
+		{"HEAD^{bogus}", "^{bogus}"},
+		{":/no such message", ":/no such message"},
+		{"master~1^3", "^3"},
+	}
+
+	for _, tt := range tests {
+		_, err := resolveRevision(repo, tt.rev)
+		var revErr *RevisionError
+		if !errors.As(err, &revErr) {
+			t.Errorf("resolveRevision(%q): expected RevisionError, got %v", tt.rev, err)
+			continue
+		}
+		if revErr.Part != tt.part {
+			t.Errorf("resolveRevision(%q): expected failing part %q, got %q (%v)", tt.rev, tt.part, revErr.Part, err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+)
+
+// jsRuntime is a way of running the TypeScript analyzer.
+type jsRuntime struct {
+	Name    string
+	Path    string // resolved executable
+	Version string
+	Source  string // how it was chosen, for pit doctor
+}
+
+// runtimeNames lists the supported runtimes in order of preference. Bun
+// runs TypeScript directly and installs missing dependencies itself.
+var runtimeNames = []string{"bun", "node", "deno"}
+
+// findRuntime looks up name on PATH. Node also needs npm, which installs
+// the analyzer's dependencies and the tsx loader.
+func findRuntime(name string) (*jsRuntime, error) {
+	if !isRuntimeName(name) {
+		return nil, fmt.Errorf("unknown runtime %q (supported: %s)", name, strings.Join(runtimeNames, ", "))
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found on PATH", name)
+	}
+	if name == "node" {
+		if _, err := exec.LookPath("npm"); err != nil {
+			return nil, fmt.Errorf("node needs npm, which was not found on PATH")
+		}
+	}
+
+	out, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %s --version: %w", name, err)
+	}
+	// deno prints its TypeScript and V8 versions on the following lines
+	version, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	version = strings.TrimPrefix(strings.TrimPrefix(version, "deno "), "v")
+	return &jsRuntime{Name: name, Path: path, Version: version}, nil
+}
+
+func isRuntimeName(name string) bool {
+	for _, n := range runtimeNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// selectRuntime picks the runtime to analyze with: the --runtime flag, then
+// PIT_RUNTIME, then the pit.runtime git config key, then the first runtime
+// found in order of preference.
+func selectRuntime(flagValue, gitRoot string) (*jsRuntime, error) {
+	name, source := flagValue, "--runtime"
+	if name == "" {
+		name, source = os.Getenv("PIT_RUNTIME"), "PIT_RUNTIME"
+	}
+	if name == "" {
+		name, source = pitConfig(gitRoot, "runtime"), "git config pit.runtime"
+	}
+	if name != "" {
+		rt, err := findRuntime(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		rt.Source = source
+		return rt, nil
+	}
+
+	for _, name := range runtimeNames {
+		if rt, err := findRuntime(name); err == nil {
+			rt.Source = "first available"
+			return rt, nil
+		}
+	}
+	return nil, fmt.Errorf("no JavaScript runtime found; install one of %s", strings.Join(runtimeNames, ", "))
+}
+
+// pitConfig returns pit.<key> from the repository's git config, falling
+// back to the user's global config. ConfigScoped does not merge raw
+// sections, so the global config is read on its own.
+func pitConfig(gitRoot, key string) string {
+	if gitRoot != "" {
+		if r, err := git.PlainOpen(gitRoot); err == nil {
+			if cfg, err := r.Config(); err == nil {
+				if value := cfg.Raw.Section("pit").Option(key); value != "" {
+					return value
+				}
+			}
+		}
+	}
+	if cfg, err := config.LoadConfig(config.GlobalScope); err == nil {
+		return cfg.Raw.Section("pit").Option(key)
+	}
+	return ""
+}
+
+// setupRuntime selects the runtime for a command, exiting if there is none.
+func setupRuntime(flagValue, gitRoot string) *jsRuntime {
+	rt, err := selectRuntime(flagValue, gitRoot)
+	if err != nil {
+		fmt.Printf("Error selecting JavaScript runtime: %v\n", err)
+		os.Exit(1)
+	}
+	return rt
+}
+
+// command returns the command that runs script with args under rt.
+func (rt *jsRuntime) command(ctx context.Context, script string, args ...string) *exec.Cmd {
+	var argv []string
+	switch rt.Name {
+	case "node":
+		// tsx is installed alongside the analyzer's dependencies
+		argv = append(argv, "--import", "tsx", script)
+	case "deno":
+		// deno.json maps the analyzer's bare ts_src/ imports; sloppy
+		// imports allow the extensionless relative ones
+		argv = append(argv, "run", "--allow-all", "--unstable-sloppy-imports", script)
+	default:
+		argv = append(argv, script)
+	}
+	return exec.CommandContext(ctx, rt.Path, append(argv, args...)...)
+}
+
+// prepare installs the analyzer's dependencies into dir if rt cannot do so
+// by itself. Bun and deno fetch missing packages on demand; node needs an
+// npm install first.
+func (rt *jsRuntime) prepare(ctx context.Context, dir string, output io.Writer) error {
+	if rt.Name != "node" {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, "node_modules", "tsx")); err == nil {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "npm", "install", "--no-audit", "--no-fund", "--loglevel=error")
+	cmd.Dir = dir
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("installing analyzer dependencies with npm: %w", err)
+	}
+	return nil
+}
+
+// executeTypeScriptProcess starts the analyzer under rt in its own process
+// group, which is killed when ctx ends. Its stdout and stderr, which are
+// only ever for humans, go to output.
+func executeTypeScriptProcess(ctx context.Context, rt *jsRuntime, req analysisRequest, pipeName string, output io.Writer) (*exec.Cmd, error) {
+	dir, err := analyzerRoot()
+	if err != nil {
+		return nil, err
+	}
+	if err := rt.prepare(ctx, dir, output); err != nil {
+		return nil, err
+	}
+
+	// Running from the analyzer's own directory picks up its tsconfig paths
+	args := []string{req.mainPath, pipeName, "--framework", req.framework}
+	if req.reusePath != "" {
+		args = append(args, "--reuse", req.reusePath)
+	}
+	cmd := rt.command(ctx, filepath.Join(dir, analyzerEntrypoint), args...)
+	cmd.Dir = dir
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := startInProcessGroup(cmd); err != nil {
+		return nil, fmt.Errorf("starting TypeScript process with %s: %w", rt.Name, err)
+	}
+	return cmd, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeRuntimes puts executables that print a version for each of names on
+// an otherwise empty PATH.
+func fakeRuntimes(t *testing.T, names ...string) {
+	t.Helper()
+	bin := t.TempDir()
+	for _, name := range names {
+		script := "#!/bin/sh\necho v1.2.3\n"
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			t.Fatalf("failed to write fake %s: %v", name, err)
+		}
+	}
+	t.Setenv("PATH", bin)
+	t.Setenv("PIT_RUNTIME", "")
+	// Keep the user's global git config out of the way
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+}
+
+func TestSelectRuntime(t *testing.T) {
+	fakeRuntimes(t, "node", "npm", "deno")
+
+	// bun is preferred but missing, so node is the first available
+	rt, err := selectRuntime("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rt.Name != "node" || rt.Version != "1.2.3" {
+		t.Errorf("expected node 1.2.3, got %s %s", rt.Name, rt.Version)
+	}
+
+	dir, repo, _ := initTestRepo(t)
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	cfg.Raw.Section("pit").SetOption("runtime", "deno")
+	if err := repo.SetConfig(cfg); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if rt, err := selectRuntime("", dir); err != nil || rt.Name != "deno" {
+		t.Errorf("expected git config to select deno, got %v, %v", rt, err)
+	}
+
+	t.Setenv("PIT_RUNTIME", "node")
+	if rt, err := selectRuntime("", dir); err != nil || rt.Name != "node" {
+		t.Errorf("expected PIT_RUNTIME to override git config, got %v, %v", rt, err)
+	}
+	if rt, err := selectRuntime("deno", dir); err != nil || rt.Name != "deno" || rt.Source != "--runtime" {
+		t.Errorf("expected --runtime to override PIT_RUNTIME, got %v, %v", rt, err)
+	}
+
+	if _, err := selectRuntime("bun", dir); err == nil || !strings.Contains(err.Error(), "bun not found") {
+		t.Errorf("expected a missing bun to be reported, got %v", err)
+	}
+	if _, err := selectRuntime("python", dir); err == nil || !strings.Contains(err.Error(), "unknown runtime") {
+		t.Errorf("expected an unknown runtime to be reported, got %v", err)
+	}
+}
+
+func TestPitConfig_Global(t *testing.T) {
+	fakeRuntimes(t, "node", "npm", "deno")
+	global := "[pit]\n\truntime = deno\n\tdaemon = true\n"
+	if err := os.WriteFile(filepath.Join(os.Getenv("HOME"), ".gitconfig"), []byte(global), 0644); err != nil {
+		t.Fatalf("failed to write global config: %v", err)
+	}
+
+	// Nothing is set in the repository, so the global values apply
+	dir, repo, _ := initTestRepo(t)
+	if rt, err := selectRuntime("", dir); err != nil || rt.Name != "deno" {
+		t.Errorf("expected global git config to select deno, got %v, %v", rt, err)
+	}
+	if got := pitConfig(dir, "daemon"); got != "true" {
+		t.Errorf("expected global pit.daemon true, got %q", got)
+	}
+
+	// The repository's own value wins
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	cfg.Raw.Section("pit").SetOption("runtime", "node")
+	if err := repo.SetConfig(cfg); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if rt, err := selectRuntime("", dir); err != nil || rt.Name != "node" {
+		t.Errorf("expected local git config to select node, got %v, %v", rt, err)
+	}
+}
+
+func TestSelectRuntime_NodeNeedsNpm(t *testing.T) {
+	fakeRuntimes(t, "node")
+	if _, err := selectRuntime("", ""); err == nil {
+		t.Error("expected node without npm not to be selected")
+	}
+}
+
+func TestRuntimeCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"bun", "/bin/bun called.ts main.ts pipe"},
+		{"node", "/bin/node --import tsx called.ts main.ts pipe"},
+		{"deno", "/bin/deno run --allow-all --unstable-sloppy-imports called.ts main.ts pipe"},
+	}
+	for _, tt := range tests {
+		rt := &jsRuntime{Name: tt.name, Path: "/bin/" + tt.name}
+		cmd := rt.command(context.Background(), "called.ts", "main.ts", "pipe")
+		if got := strings.Join(cmd.Args, " "); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
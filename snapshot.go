@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// snapshot is one side of a comparison: a commit, the index, or the working
+// tree when neither is set. An empty snapshot stands for the side before a
+// root commit.
+type snapshot struct {
+	commit *object.Commit
+	index  bool
+	empty  bool
+}
+
+// comparison is a patch together with the snapshots on either side of it.
+type comparison struct {
+	patch fdiff.Patch
+	base  snapshot
+	head  snapshot
+}
+
+// treeAnalyzer analyzes snapshots, remembering the result for each commit
+// tree so that walking history runs the analyzer once per distinct tree.
+// Results for commits are also kept in the on-disk cache, so later runs
+// skip trees analyzed before, and trees close to one analyzed before only
+// have the call graphs touched by the difference rebuilt. Warnings from
+// every run are kept for the summary at the end.
+type treeAnalyzer struct {
+	repo     *git.Repository
+	gitRoot  string
+	cfg      analyzerConfig
+	cache    *resultCache
+	trees    map[plumbing.Hash]*analyzerOutput
+	last     plumbing.Hash // tree analyzed most recently
+	warnings []analyzerWarning
+}
+
+func newTreeAnalyzer(r *git.Repository, gitRoot string, cfg analyzerConfig) *treeAnalyzer {
+	return &treeAnalyzer{
+		repo:    r,
+		gitRoot: gitRoot,
+		cfg:     cfg,
+		cache:   openResultCache(r, gitRoot),
+		trees:   make(map[plumbing.Hash]*analyzerOutput),
+	}
+}
+
+func (a *treeAnalyzer) analyze(ctx context.Context, snap snapshot, label string) ([]FunctionRange, error) {
+	if snap.commit == nil {
+		output, err := analyzeSnapshot(ctx, a.repo, a.gitRoot, snap, a.cfg, label, nil)
+		if err != nil {
+			return nil, err
+		}
+		a.warnings = append(a.warnings, output.Warnings...)
+		return output.Functions, nil
+	}
+
+	tree := snap.commit.TreeHash
+	if output, ok := a.trees[tree]; ok {
+		return output.Functions, nil
+	}
+	output, ok := a.cache.get(tree)
+	if ok {
+		if a.cfg.verbose {
+			fmt.Fprintf(os.Stderr, "Using cached analysis of %s (tree %s)\n", label, shortHash(tree))
+		}
+	} else {
+		var err error
+		output, err = a.analyzeCommit(ctx, snap.commit, label)
+		if err != nil {
+			return nil, err
+		}
+		// Failing to cache only costs the next run time
+		if err := a.cache.put(snap.commit, output); err != nil && a.cfg.verbose {
+			fmt.Fprintf(os.Stderr, "Warning: not caching analysis of %s: %v\n", label, err)
+		}
+	}
+	a.warnings = append(a.warnings, output.Warnings...)
+	a.trees[tree] = output
+	a.last = tree
+	return output.Functions, nil
+}
+
+// analyzeSnapshot runs the analyzer against the project as it exists in snap.
+// Commits and the index are checked out first, see checkoutSnapshot. A
+// snapshot without a supported framework has no functions.
+func analyzeSnapshot(ctx context.Context, r *git.Repository, gitRoot string, snap snapshot, cfg analyzerConfig, label string, reuse []string) (*analyzerOutput, error) {
+	root := gitRoot
+	switch {
+	case snap.empty:
+		return &analyzerOutput{}, nil
+	case snap.commit != nil, snap.index:
+		dir, release, err := checkoutSnapshot(r, gitRoot, snap)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		root = dir
+	}
+
+	mainPath, framework, err := DetectFramework(root)
+	if errors.Is(err, ErrFrameworkNotFound) || os.IsNotExist(err) {
+		return &analyzerOutput{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	output, err := runAnalyzer(ctx, mainPath, framework, cfg, label, reuse)
+	if err != nil {
+		return nil, err
+	}
+
+	normalizeFunctionPaths(output.Functions, root)
+	normalizeWarningPaths(output.Warnings, root)
+	normalizeDependencyPaths(output.Dependencies, root)
+	return output, nil
+}
+
+// normalizeFunctionPaths rewrites the absolute paths the analyzer reports
+// into slash-separated paths relative to root, the form git uses in patches,
+// so they can be compared exactly. Symlinks are resolved on both sides first
+// so a link to a file, or a root under a linked directory such as macOS's
+// /var, still lines up. Files outside root keep their absolute path and
+// never match a patch.
+func normalizeFunctionPaths(functions []FunctionRange, root string) {
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	// Many ranges share a file, so resolve each path once
+	resolved := make(map[string]string)
+	for i, fn := range functions {
+		rel, ok := resolved[fn.Filename]
+		if !ok {
+			rel = relativeToRoot(root, fn.Filename)
+			resolved[fn.Filename] = rel
+		}
+		functions[i].Filename = rel
+	}
+}
+
+// normalizeWarningPaths rewrites warning paths like normalizeFunctionPaths,
+// so files in a temporary tree are reported as they appear in the repository.
+func normalizeWarningPaths(warnings []analyzerWarning, root string) {
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	for i, w := range warnings {
+		if w.File != "" {
+			warnings[i].File = relativeToRoot(root, w.File)
+		}
+	}
+}
+
+// normalizeDependencyPaths rewrites dependency paths like
+// normalizeFunctionPaths, so they can be compared with the paths of a diff.
+func normalizeDependencyPaths(dependencies map[string][]string, root string) {
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	for _, files := range dependencies {
+		for i, file := range files {
+			files[i] = relativeToRoot(root, file)
+		}
+	}
+}
+
+// relativeToRoot returns path relative to root, which must already have its
+// symlinks resolved, or path unchanged if it lies outside root.
+func relativeToRoot(root, path string) string {
+	real := path
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		real = resolved
+	}
+	if r, err := filepath.Rel(root, real); err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(r)
+	}
+	return path
+}
+
+// parentComparison compares commit against its first parent, or against
+// nothing for a root commit.
+func parentComparison(commit *object.Commit) (*comparison, error) {
+	if commit.NumParents() == 0 {
+		patch, err := diffCommits(nil, commit)
+		if err != nil {
+			return nil, err
+		}
+		return &comparison{
+			patch: patch,
+			base:  snapshot{empty: true},
+			head:  snapshot{commit: commit},
+		}, nil
+	}
+
+	parent, err := commit.Parent(0)
+	if err != nil {
+		return nil, fmt.Errorf("error getting parent of %s: %w", commit.Hash, err)
+	}
+	patch, err := diffCommits(parent, commit)
+	if err != nil {
+		return nil, err
+	}
+	return &comparison{
+		patch: patch,
+		base:  snapshot{commit: parent},
+		head:  snapshot{commit: commit},
+	}, nil
+}
+
+// diffCommits returns the patch from base to head with rename detection, so
+// a moved file shows up as one file patch carrying both paths rather than
+// a deletion and an unrelated addition. A nil base diffs against nothing.
+func diffCommits(base, head *object.Commit) (fdiff.Patch, error) {
+	var baseTree *object.Tree
+	if base != nil {
+		var err error
+		baseTree, err = base.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("error getting tree of %s: %w", base.Hash, err)
+		}
+	}
+	headTree, err := head.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("error getting tree of %s: %w", head.Hash, err)
+	}
+
+	changes, err := object.DiffTreeWithOptions(context.Background(), baseTree, headTree, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return nil, fmt.Errorf("diffing trees: %w", err)
+	}
+	patch, err := changes.Patch()
+	if err != nil {
+		return nil, fmt.Errorf("getting patch: %w", err)
+	}
+	return patch, nil
+}
+
+// materializeCommit writes the tree of commit into a new temporary directory
+// and returns its path. The caller is responsible for removing it.
+func materializeCommit(commit *object.Commit, gitRoot string) (string, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", fmt.Errorf("error getting tree of %s: %w", commit.Hash, err)
+	}
+
+	dir, err := os.MkdirTemp("", "pit-tree-")
+	if err != nil {
+		return "", fmt.Errorf("creating temporary tree: %w", err)
+	}
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		reader, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		return writeTreeFile(dir, f.Name, f.Mode, reader)
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("writing tree of %s: %w", commit.Hash, err)
+	}
+
+	linkNodeModules(dir, gitRoot)
+	return dir, nil
+}
+
+// materializeIndex writes the staged content of every file in the index into
+// a new temporary directory and returns its path.
+func materializeIndex(r *git.Repository, gitRoot string) (string, error) {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return "", fmt.Errorf("reading index: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "pit-index-")
+	if err != nil {
+		return "", fmt.Errorf("creating temporary tree: %w", err)
+	}
+
+	for _, entry := range idx.Entries {
+		if entry.Mode == filemode.Submodule {
+			continue
+		}
+		blob, err := r.BlobObject(entry.Hash)
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("reading %s from index: %w", entry.Name, err)
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("reading %s from index: %w", entry.Name, err)
+		}
+		err = writeTreeFile(dir, entry.Name, entry.Mode, reader)
+		reader.Close()
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("writing %s: %w", entry.Name, err)
+		}
+	}
+
+	linkNodeModules(dir, gitRoot)
+	return dir, nil
+}
+
+func writeTreeFile(dir, name string, mode filemode.FileMode, reader io.Reader) error {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Symlink blobs hold the link target
+	if mode == filemode.Symlink {
+		target, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(string(target), path)
+	}
+
+	perm := os.FileMode(0644)
+	if mode == filemode.Executable {
+		perm = 0755
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// linkNodeModules points the temporary tree at the installed dependencies of
+// the real checkout, which are never committed, so imports of packages such
+// as @nestjs/common still resolve during analysis.
+func linkNodeModules(dir, gitRoot string) {
+	src := filepath.Join(gitRoot, "node_modules")
+	dst := filepath.Join(dir, "node_modules")
+	if _, err := os.Stat(src); err != nil {
+		return
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return
+	}
+	os.Symlink(src, dst)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaterializeCommit(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	if err := os.MkdirAll(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatalf("failed to create src dir: %v", err)
+	}
+	writeFile(t, dir, "src/main.ts", "committed\n")
+	commitAll(t, wt, "initial")
+
+	// Uncommitted edits must not leak into the materialized tree
+	writeFile(t, dir, "src/main.ts", "edited\n")
+
+	ref, err := repo.Head()
+	if err != nil {
+		t.Fatalf("failed to get HEAD: %v", err)
+	}
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		t.Fatalf("failed to get HEAD commit: %v", err)
+	}
+
+	tree, err := materializeCommit(commit, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tree)
+
+	contents, err := os.ReadFile(filepath.Join(tree, "src", "main.ts"))
+	if err != nil {
+		t.Fatalf("expected src/main.ts in materialized tree: %v", err)
+	}
+	if string(contents) != "committed\n" {
+		t.Errorf("expected committed contents, got %q", contents)
+	}
+}
+
+func TestNormalizeFunctionPaths(t *testing.T) {
+	real := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(real, "src", "admin"), 0755); err != nil {
+		t.Fatalf("failed to create dirs: %v", err)
+	}
+	writeFile(t, real, "src/user.service.ts", "x\n")
+	writeFile(t, real, "src/admin/user.service.ts", "x\n")
+	if err := os.Symlink("user.service.ts", filepath.Join(real, "src", "alias.ts")); err != nil {
+		t.Fatalf("failed to create file symlink: %v", err)
+	}
+
+	// Analyze through a symlinked root, as with /var on macOS
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(real, link); err != nil {
+		t.Fatalf("failed to create root symlink: %v", err)
+	}
+
+	functions := []FunctionRange{
+		{Filename: filepath.Join(link, "src", "user.service.ts")},
+		{Filename: filepath.Join(real, "src", "admin", "user.service.ts")},
+		{Filename: filepath.Join(link, "src", "alias.ts")},
+		{Filename: "/elsewhere/lib.ts"},
+	}
+	normalizeFunctionPaths(functions, link)
+
+	want := []string{"src/user.service.ts", "src/admin/user.service.ts", "src/user.service.ts", "/elsewhere/lib.ts"}
+	for i, fn := range functions {
+		if fn.Filename != want[i] {
+			t.Errorf("function %d: expected %q, got %q", i, want[i], fn.Filename)
+		}
+	}
+}
@@ -3,18 +3,14 @@
 package main
 
 import (
-	"fmt"
-	"io"
-	"os"
-	"os/exec"
+	"path/filepath"
+	"runtime"
 )
 
-func executeTypeScriptProcess(absPath, pipeName string) *exec.Cmd {
-	cmd := exec.Command("npx", "ts-node", "/Users/prasshan/Desktop/Repos/pit/ts_src/ffi/called.ts", absPath, pipeName)
-	cmd.Stderr = io.Discard
-	if err := cmd.Start(); err != nil {
-		fmt.Printf("Error starting TypeScript process: %s\n", err)
-		os.Exit(1)
-	}
-	return cmd
+// analyzerRoot returns the directory holding the analyzer to run. Dev
+// builds use the pit checkout they were built from, so TypeScript changes
+// apply without rebuilding.
+func analyzerRoot() (string, error) {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file), nil
 }
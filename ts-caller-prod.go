@@ -2,20 +2,8 @@
 
 package main
 
-import (
-	"fmt"
-	"io"
-	"os"
-	"os/exec"
-)
-
-func executeTypeScriptProcess(absPath, pipeName string) *exec.Cmd {
-
-	cmd := exec.Command("bun", "./ts_src/ffi/called.ts", absPath, pipeName)
-	cmd.Stderr = io.Discard
-	if err := cmd.Start(); err != nil {
-		fmt.Printf("Error starting TypeScript process: %s\n", err)
-		os.Exit(1)
-	}
-	return cmd
+// analyzerRoot returns the directory holding the analyzer to run: the copy
+// embedded in this binary, extracted to the cache.
+func analyzerRoot() (string, error) {
+	return analyzerDir()
 }
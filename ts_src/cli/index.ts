@@ -1,12 +1,7 @@
 import chalk from 'chalk';
 import path from 'path';
 import { extractController } from '../route-extractor/nestjs';
-import {
-    analyzeFunction,
-    processControllerFunctions,
-    processFunctions,
-    validFuncDeclarations
-} from '../../ts_src/common/analyzer';
+import { processFunctions, returnFunctions } from '../../ts_src/common/analyzer';
 import { Project, SourceFile } from 'ts-morph';
 
 async function main(filePath: string, functionName: string) {
@@ -23,12 +18,8 @@ async function main(filePath: string, functionName: string) {
             const files: SourceFile[] = project.getSourceFiles();
             processFunctions(files, [functionName]);
         } else {
-            const controllers: {
-                declaration: validFuncDeclarations;
-                controller: string;
-                published_path: string;
-            }[] = extractController(absolutePath);
-            processControllerFunctions(controllers);
+            const controllers = extractController(absolutePath);
+            console.log(returnFunctions(controllers, absolutePath));
         }
     } catch (error) {
         console.error(chalk.red('Error analyzing function:'), error);
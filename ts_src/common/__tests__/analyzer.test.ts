@@ -0,0 +1,181 @@
+import assert from 'node:assert/strict';
+import * as fs from 'fs';
+import * as os from 'os';
+import path from 'path';
+import { test } from 'node:test';
+import { Project } from 'ts-morph';
+import { loadProject, streamFunctions } from '../analyzer';
+import { AnalyzerPipe, FunctionRange } from '../../helpers/pipe-pusher';
+import { ExtractedRoute } from '../../route-extractor/common';
+
+const CONTROLLER = '/app/src/users.controller.ts';
+
+function project(): Project {
+    const project = new Project({ useInMemoryFileSystem: true });
+    project.createSourceFile(
+        CONTROLLER,
+        `
+        import { formatUser } from './format';
+        import { UsersService } from './users.service';
+
+        export class UsersController {
+            constructor(private readonly users: UsersService) {}
+
+            show() {
+                return formatUser(this.users.find());
+            }
+        }
+        `
+    );
+    project.createSourceFile(
+        '/app/src/format.ts',
+        `
+        export function formatUser(user) {
+            return describe(user);
+        }
+
+        function describe(user) {
+            return { user };
+        }
+        `
+    );
+    project.createSourceFile(
+        '/app/src/users.service.ts',
+        `
+        export class UsersService {
+            find() {
+                return lookup();
+            }
+        }
+
+        function lookup() {
+            return {};
+        }
+        `
+    );
+    return project;
+}
+
+// functionNames streams route and returns the names of the functions in its
+// call graph.
+function functionNames(project: Project, route: ExtractedRoute): string[] {
+    const ranges: FunctionRange[] = [];
+    const pipe = {
+        progress() {},
+        route() {},
+        warning() {},
+        dependencies() {},
+        functionRange(range: FunctionRange) {
+            ranges.push(range);
+        }
+    } as unknown as AnalyzerPipe;
+    streamFunctions([route], CONTROLLER, pipe, project);
+    return ranges.map(range => range.FunctionName);
+}
+
+test('NestJS routes do not follow imported functions', () => {
+    // As extractControllerFromProject reports them, by controller and method
+    const names = functionNames(project(), {
+        published_path: 'GET /users/:id',
+        function_name: 'show',
+        file: CONTROLLER,
+        controller: 'UsersController'
+    });
+
+    assert.deepEqual(names, ['show', 'formatUser', 'this.users.find', 'lookup']);
+});
+
+test('routes with handlers follow imported functions', () => {
+    const p = project();
+    const controller = p.getSourceFileOrThrow(CONTROLLER).getClassOrThrow('UsersController');
+    const names = functionNames(p, {
+        published_path: 'GET /users/:id',
+        function_name: 'show',
+        file: CONTROLLER,
+        controller: 'users.controller',
+        handlers: [controller.getMethodOrThrow('show')]
+    });
+
+    assert.deepEqual(names, ['show', 'formatUser', 'describe', 'this.users.find', 'lookup']);
+});
+
+test('only non-NestJS projects load JavaScript', () => {
+    const dir = fs.mkdtempSync(path.join(os.tmpdir(), 'pit-analyzer-'));
+    try {
+        const main = path.join(dir, 'main.ts');
+        fs.writeFileSync(main, '');
+        assert.ok(!loadProject(main).getCompilerOptions().allowJs);
+        assert.ok(!loadProject(main, 'nestjs').getCompilerOptions().allowJs);
+        assert.ok(loadProject(main, 'express').getCompilerOptions().allowJs);
+    } finally {
+        fs.rmSync(dir, { recursive: true });
+    }
+});
+
+test('dependencies include files imported through other imports', () => {
+    const p = new Project({ useInMemoryFileSystem: true });
+    const routes = p.createSourceFile(
+        '/app/src/routes.ts',
+        `
+        import { LIMIT } from './services';
+
+        export function list() {
+            return LIMIT;
+        }
+        `
+    );
+    p.createSourceFile('/app/src/services/index.ts', `export * from './limits';`);
+    p.createSourceFile('/app/src/services/limits.ts', `export const LIMIT = 10;`);
+
+    const dependencies: string[] = [];
+    const pipe = {
+        progress() {},
+        route() {},
+        warning() {},
+        functionRange() {},
+        dependencies(endpoint: string, files: string[]) {
+            dependencies.push(...files);
+        }
+    } as unknown as AnalyzerPipe;
+    const route = {
+        published_path: 'GET /items',
+        function_name: 'list',
+        file: '/app/src/routes.ts',
+        controller: 'routes',
+        handlers: [routes.getFunctionOrThrow('list')]
+    };
+    streamFunctions([route], '/app/src/routes.ts', pipe, p);
+
+    // limits.ts is reached only through the barrel
+    assert.deepEqual(dependencies.sort(), [
+        '/app/src/routes.ts',
+        '/app/src/services/index.ts',
+        '/app/src/services/limits.ts'
+    ]);
+});
+
+test('a route missing its handler is reported even with middleware', () => {
+    const p = project();
+    const format = p.getSourceFileOrThrow('/app/src/format.ts');
+    const warnings: string[] = [];
+    const pipe = {
+        progress() {},
+        route() {},
+        functionRange() {},
+        dependencies() {},
+        warning(message: string) {
+            warnings.push(message);
+        }
+    } as unknown as AnalyzerPipe;
+    const route = {
+        published_path: 'GET /users',
+        function_name: 'index',
+        file: CONTROLLER,
+        controller: 'UsersController',
+        handlers: [format.getFunctionOrThrow('formatUser')],
+        unresolved: true
+    };
+    streamFunctions([route], CONTROLLER, pipe, p);
+
+    assert.deepEqual(warnings, ['GET /users: handler UsersController.index not found']);
+});
@@ -1,9 +1,11 @@
 import chalk from 'chalk';
+import * as fs from 'fs';
 import {
     Node,
     CallExpression,
     FunctionDeclaration,
     ArrowFunction,
+    FunctionExpression,
     MethodDeclaration,
     ts,
     SourceFile,
@@ -12,9 +14,19 @@ import {
 } from 'ts-morph';
 import { findTargetFunction, findTargetFunctionFromFileString } from './utils';
 import { printResults } from '../cli/print';
-import { FunctionRange, writeToNamedPipe } from '../../ts_src/helpers/pipe-pusher';
+import { AnalyzerPipe, FunctionRange, Warning } from '../../ts_src/helpers/pipe-pusher';
+import { ExtractedRoute, resolveFunction } from '../route-extractor/common';
+import { extractRoutes } from '../route-extractor/index';
 
-export type validFuncDeclarations = FunctionDeclaration | ArrowFunction | MethodDeclaration;
+export type validFuncDeclarations =
+    | FunctionDeclaration
+    | ArrowFunction
+    | FunctionExpression
+    | MethodDeclaration;
+
+// Receives problems found while walking a call graph, such as calls whose
+// target could not be resolved
+export type WarningSink = (message: string, warning: Warning) => void;
 
 export function returnFunctions(
     params: {
@@ -64,14 +76,140 @@ export function returnFunctions(
 //     });
 //     return callsArr;
 // }
-export function processControllerFunctions(
-    params: { published_path: string; declaration: validFuncDeclarations; controller: string }[],
-    pipeName?: string
+
+// loadProject loads the entrypoint of a project and everything it imports.
+// Apps of frameworks other than NestJS are often written in JavaScript, so
+// for them JavaScript is loaded too.
+export function loadProject(main: string, framework = 'nestjs'): Project {
+    const project = new Project({ compilerOptions: { allowJs: framework !== 'nestjs' } });
+    project.addSourceFileAtPath(main);
+    project.resolveSourceFileDependencies();
+    return project;
+}
+
+// readReuseList reads the endpoints pit asks not to analyze again, a JSON
+// array of endpoints in the file at path.
+export function readReuseList(path?: string): Set<string> {
+    if (!path) {
+        return new Set();
+    }
+    return new Set(JSON.parse(fs.readFileSync(path, 'utf8')) as string[]);
+}
+
+// analyzeProject reads the routes of project as framework declares them and
+// sends their call graphs over the pipe. A framework whose routes cannot be
+// read yet is reported as a warning with no routes.
+export function analyzeProject(
+    framework: string,
+    main: string,
+    pipe: AnalyzerPipe,
+    project: Project,
+    reuse: Set<string> = new Set()
+) {
+    const routes = extractRoutes(framework, project);
+    if (!routes) {
+        pipe.warning(`routes of ${framework} projects cannot be read yet`, { file: main });
+        return;
+    }
+    streamFunctions(routes, main, pipe, project, reuse);
+}
+
+// streamFunctions sends the call graph of every route over the pipe. Routes
+// in reuse are announced but not analyzed; pit already has their call graphs
+// from an earlier run and knows none of the files they depend on changed.
+// Routes that come with their handlers are analyzed from those, following
+// calls through variables, imports and require() as apps registering routes
+// by calling functions need; the rest, NestJS routes, are looked up by
+// controller and method name.
+export function streamFunctions(
+    params: ExtractedRoute[],
+    main: string,
+    pipe: AnalyzerPipe,
+    project: Project = loadProject(main),
+    reuse: Set<string> = new Set()
 ) {
-    params.forEach(({ declaration, controller, published_path }) => {
-        writeToNamedPipe(analyzeFunction(declaration, controller), pipeName, published_path);
+    params.forEach((route, i) => {
+        const { file, controller, published_path, function_name, handlers } = route;
+        pipe.progress(i + 1, params.length, published_path);
+        pipe.route({
+            endpoint: published_path,
+            controller,
+            handler: function_name,
+            file
+        });
+        if (reuse.has(published_path)) {
+            return;
+        }
+
+        const files = new Set([file]);
+        const warn = (message: string, warning: Warning) =>
+            pipe.warning(message, { ...warning, endpoint: published_path });
+        const followValues = handlers !== undefined;
+        let declarations = handlers;
+        try {
+            if (!declarations) {
+                const declaration = findTargetFunctionFromFileString(project, file, function_name);
+                declarations = declaration ? [declaration] : [];
+            }
+        } catch (error) {
+            warn(`${published_path}: could not load ${file}: ${error.message}`, {
+                kind: 'skipped-file',
+                file
+            });
+            pipe.dependencies(published_path, [...files]);
+            return;
+        }
+        // Middleware alone is still analyzed, but the handler is missing
+        if (declarations.length === 0 || route.unresolved) {
+            warn(`${published_path}: handler ${controller}.${function_name} not found`, {
+                kind: 'unresolved-symbol',
+                file,
+                symbol: `${controller}.${function_name}`
+            });
+        }
+        if (declarations.length === 0) {
+            pipe.dependencies(published_path, [...files]);
+            return;
+        }
+        // Middleware shared by several of the handlers is walked only once
+        const visited = new Set<string>();
+        for (const declaration of declarations) {
+            const options = { visited, warn, followValues };
+            analyzeFunction(declaration, controller, options).forEach(callInfo => {
+                files.add(callInfo.location.filePath);
+                pipe.functionRange({
+                    ControllerName: published_path ?? undefined,
+                    FunctionName: callInfo.name.replaceAll('\n', ''),
+                    Filename: callInfo.location.filePath,
+                    StartLine: callInfo.location.startLine,
+                    EndLine: callInfo.location.endLine
+                });
+            });
+        }
+        pipe.dependencies(published_path, dependencyFiles(project, files));
     });
 }
+
+// dependencyFiles returns files along with everything they import, directly
+// or through other imports, since a change to an imported file, such as a
+// barrel re-exporting a service, can change what a call resolves to without
+// touching the call graph itself.
+function dependencyFiles(project: Project, files: Set<string>): string[] {
+    const all = new Set(files);
+    const pending = [...files];
+    while (pending.length > 0) {
+        const sourceFile = project.getSourceFile(pending.pop()!);
+        for (const referenced of sourceFile?.getReferencedSourceFiles() ?? []) {
+            const path = referenced.getFilePath();
+            if (!path.includes('/node_modules/') && !all.has(path)) {
+                all.add(path);
+                pending.push(path);
+            }
+        }
+    }
+    return [...all];
+}
+
 export function processFunctions(files: SourceFile[], functionNames: string[]) {
     functionNames.forEach(functionName => {
         let i = 1;
@@ -132,6 +270,12 @@ function getNodeStartPosition(node: Node): number {
         return node.getNameNode()?.getStart() ?? node.getStart();
     } else if (Node.isFunctionDeclaration(node)) {
         return node.getNameNode()?.getStart() ?? node.getStart();
+    } else if (Node.isArrowFunction(node) || Node.isFunctionExpression(node)) {
+        // Start at const handler = ... rather than at the parameters
+        const parent = node.getParent();
+        if (Node.isVariableDeclaration(parent) || Node.isPropertyAssignment(parent)) {
+            return parent.getStart();
+        }
     }
     return node.getStart();
 }
@@ -147,7 +291,12 @@ function getNodeLocation(node: Node) {
 
 function extractDeclarationInfo(node: Node, controller: string): CallInfo | null {
     try {
-        if (!Node.isFunctionDeclaration(node) && !Node.isMethodDeclaration(node)) {
+        if (
+            !Node.isFunctionDeclaration(node) &&
+            !Node.isMethodDeclaration(node) &&
+            !Node.isArrowFunction(node) &&
+            !Node.isFunctionExpression(node)
+        ) {
             return null;
         }
 
@@ -156,7 +305,7 @@ function extractDeclarationInfo(node: Node, controller: string): CallInfo | null
         const typeChecker = node.getProject().getTypeChecker();
 
         return {
-            name: node.getName() || 'anonymous',
+            name: functionName(node),
             line,
             column,
             type: node.getType().getText(),
@@ -172,7 +321,25 @@ function extractDeclarationInfo(node: Node, controller: string): CallInfo | null
     }
 }
 
-function extractCallInfo(node: CallExpression, controller: string): CallInfo | null {
+// functionName names a function by its declaration, or by the variable or
+// property it is assigned to.
+function functionName(node: validFuncDeclarations): string {
+    if (!Node.isArrowFunction(node) && node.getName()) {
+        return node.getName()!;
+    }
+    const parent = node.getParent();
+    if (Node.isVariableDeclaration(parent) || Node.isPropertyAssignment(parent)) {
+        return parent.getName();
+    }
+    return 'anonymous';
+}
+
+function extractCallInfo(
+    node: CallExpression,
+    controller: string,
+    warn?: WarningSink,
+    followValues = false
+): CallInfo | null {
     try {
         const expression = node.getExpression();
 
@@ -183,10 +350,22 @@ function extractCallInfo(node: CallExpression, controller: string): CallInfo | n
 
         const typeChecker = node.getProject().getTypeChecker();
         const symbol = typeChecker.getSymbolAtLocation(expression);
-        if (!symbol) return null;
+        if (!symbol) {
+            warn?.(`could not resolve ${expression.getText()}`, {
+                kind: 'unresolved-symbol',
+                file: node.getSourceFile().getFilePath(),
+                symbol: expression.getText()
+            });
+            return null;
+        }
 
-        const declaration = symbol.getDeclarations()?.[0];
+        let declaration: Node | undefined = symbol.getDeclarations()?.[0];
         if (!declaration) return null;
+        if (followValues && !Node.isFunctionLikeDeclaration(declaration)) {
+            // Imported functions and functions held in variables, as in
+            // const findUser = async (id) => ...
+            declaration = resolveFunction(expression) ?? declaration;
+        }
 
         const type = node.getType().getText();
         if (type.endsWith('Decorator')) {
@@ -208,17 +387,28 @@ function extractCallInfo(node: CallExpression, controller: string): CallInfo | n
             location: declaration ? getNodeLocation(declaration) : getNodeLocation(node)
         };
     } catch (error) {
-        console.warn(`Warning: Could not analyze call expression: ${node.getText()}`, error);
+        warn?.(`could not analyze call expression ${node.getText()}: ${error}`, {
+            file: node.getSourceFile().getFilePath()
+        });
         return null;
     }
 }
 
+// analyzeFunction lists the calls made by node and, recursively, by the
+// functions they resolve to. With followValues, callees the type checker
+// finds only as a variable or an import are followed to the function they
+// hold.
 export function analyzeFunction(
     node: Node<ts.FunctionLikeDeclaration>,
     controller: string,
-    options: { includeDeclaration?: boolean; visited?: Set<string> } = {}
+    options: {
+        includeDeclaration?: boolean;
+        visited?: Set<string>;
+        warn?: WarningSink;
+        followValues?: boolean;
+    } = {}
 ): CallInfo[] {
-    const { includeDeclaration = true, visited = new Set<string>() } = options;
+    const { includeDeclaration = true, visited = new Set<string>(), warn, followValues } = options;
     const calls: CallInfo[] = [];
 
     // Prevent infinite recursion
@@ -237,7 +427,7 @@ export function analyzeFunction(
     // Analyze all call expressions within the function
     node.forEachDescendant(descendant => {
         if (Node.isCallExpression(descendant)) {
-            const callInfo = extractCallInfo(descendant, controller);
+            const callInfo = extractCallInfo(descendant, controller, warn, followValues);
             if (callInfo) {
                 calls.push(callInfo);
 
@@ -249,7 +439,9 @@ export function analyzeFunction(
                 ) {
                     const nestedCalls = analyzeFunction(callInfo.node, controller, {
                         includeDeclaration: false,
-                        visited
+                        visited,
+                        warn,
+                        followValues
                     });
                     calls.push(...nestedCalls);
                 }
@@ -1,27 +1,40 @@
-import { Project } from 'ts-morph';
-import { extractController } from '../../ts_src/route-extractor/nestjs';
-import chalk from 'chalk';
 import path from 'path';
-import { processControllerFunctions } from 'ts_src/common/analyzer';
+import { analyzeProject, loadProject, readReuseList } from 'ts_src/common/analyzer';
+import { AnalyzerPipe } from 'ts_src/helpers/pipe-pusher';
 
+// Invoked by pit as:
+//
+//     called.ts <entrypoint> <pipe> [--framework <name>] [--reuse <file>]
+//
+// Everything pit needs is sent over the pipe; stdout and stderr are for
+// humans only. framework says how routes are declared, nestjs if not given,
+// and reuse names a file listing endpoints pit already has call graphs for.
 async function main() {
-    const pipePath = process.argv[3];
-    const filePath = process.argv[2];
+    const [filePath, pipePath, ...options] = process.argv.slice(2);
+    let framework = 'nestjs';
+    let reusePath: string | undefined;
+    for (let i = 0; i < options.length; i += 2) {
+        if (options[i] === '--framework') {
+            framework = options[i + 1];
+        } else if (options[i] === '--reuse') {
+            reusePath = options[i + 1];
+        }
+    }
 
-    if (!pipePath) {
-        console.error('Please provide the named pipe path as an argument');
+    if (!filePath || !pipePath || !framework) {
+        console.error('Usage: called.ts <entrypoint> <pipe> [--framework <name>] [--reuse <file>]');
         process.exit(1);
-    } else {
-        console.log(pipePath);
     }
 
+    const pipe = new AnalyzerPipe(pipePath);
     try {
         const absolutePath = path.resolve(process.cwd(), filePath);
-        const controllers = extractController(absolutePath);
-        processControllerFunctions(controllers, pipePath);
-        console.error('Finished TS');
+        const project = loadProject(absolutePath, framework);
+        analyzeProject(framework, absolutePath, pipe, project, readReuseList(reusePath));
+        pipe.done();
     } catch (error) {
-        console.error(chalk.red('Error analyzing function:'), error);
+        pipe.error(error instanceof Error ? error.message : String(error));
+        console.error('Error analyzing function:', error);
         process.exit(1);
     }
 }
@@ -0,0 +1,233 @@
+import * as fs from 'fs';
+import * as net from 'net';
+import path from 'path';
+import { Project } from 'ts-morph';
+import { analyzeProject, loadProject, readReuseList } from 'ts_src/common/analyzer';
+import { AnalyzerPipe, PROTOCOL_VERSION } from 'ts_src/helpers/pipe-pusher';
+
+// Started by pit as: daemon.ts <socket> <version>. Listens on the Unix socket
+// for JSON-RPC 2.0 requests, one per line, and keeps the ts-morph project of
+// every entrypoint it analyzes loaded so later requests only re-read the
+// files that changed. Results go over the pipe named in each request, exactly
+// as called.ts sends them.
+
+// How many projects to keep loaded; the least recently used is dropped. pit
+// analyzes the working tree and checkouts of the index and of either side of
+// a comparison, each at a path of its own that stays the same between runs.
+const MAX_PROJECTS = 4;
+
+// Exit after this long without a request
+const IDLE_TIMEOUT_MS = 30 * 60 * 1000;
+
+interface WarmProject {
+    project: Project;
+    framework: string;
+    mtimes: Map<string, number>;
+}
+
+interface RpcRequest {
+    jsonrpc: '2.0';
+    id: number;
+    method: string;
+    params?: { entrypoint?: string; framework?: string; pipe?: string; reuse?: string };
+}
+
+// Insertion order doubles as recency: entries are re-inserted on use
+const projects = new Map<string, WarmProject>();
+
+function sourceMtimes(project: Project) {
+    const mtimes = new Map<string, number>();
+    for (const sourceFile of project.getSourceFiles()) {
+        const file = sourceFile.getFilePath();
+        if (file.includes('/node_modules/')) {
+            continue;
+        }
+        try {
+            mtimes.set(file, fs.statSync(file).mtimeMs);
+        } catch {
+            // Removed since it was loaded; dropped on the next refresh
+        }
+    }
+    return mtimes;
+}
+
+// getProject returns the loaded project for entrypoint, re-reading files that
+// changed on disk and dropping files that are gone since the last request. A
+// project loaded for another framework is loaded afresh, since the framework
+// decides whether JavaScript is loaded.
+function getProject(entrypoint: string, framework: string): Project {
+    const warm = projects.get(entrypoint);
+    if (!warm || warm.framework !== framework) {
+        const project = loadProject(entrypoint, framework);
+        remember(entrypoint, { project, framework, mtimes: sourceMtimes(project) });
+        return project;
+    }
+
+    const { project, mtimes } = warm;
+    for (const [file, mtime] of mtimes) {
+        const sourceFile = project.getSourceFile(file);
+        let current: number;
+        try {
+            current = fs.statSync(file).mtimeMs;
+        } catch {
+            if (sourceFile) {
+                project.removeSourceFile(sourceFile);
+            }
+            continue;
+        }
+        if (current !== mtime) {
+            sourceFile?.refreshFromFileSystemSync();
+        }
+    }
+    // Edits may have added imports of files not loaded yet
+    project.resolveSourceFileDependencies();
+    remember(entrypoint, { project, framework, mtimes: sourceMtimes(project) });
+    return project;
+}
+
+function remember(entrypoint: string, warm: WarmProject) {
+    projects.delete(entrypoint);
+    projects.set(entrypoint, warm);
+    while (projects.size > MAX_PROJECTS) {
+        projects.delete(projects.keys().next().value);
+    }
+}
+
+function analyze(entrypoint: string, framework: string, pipePath: string, reusePath?: string) {
+    const absolutePath = path.resolve(entrypoint);
+    const pipe = new AnalyzerPipe(pipePath);
+    try {
+        const project = getProject(absolutePath, framework);
+        analyzeProject(framework, absolutePath, pipe, project, readReuseList(reusePath));
+        pipe.done();
+    } catch (error) {
+        // A project that failed part way may be inconsistent; load it afresh
+        projects.delete(absolutePath);
+        const message = error instanceof Error ? error.message : String(error);
+        try {
+            pipe.error(message);
+        } catch {
+            // pit stopped reading
+        }
+        throw error;
+    } finally {
+        // Never leave the pipe open in the daemon, whatever happened
+        pipe.close();
+    }
+}
+
+function main() {
+    const socketPath = process.argv[2];
+    const version = process.argv[3];
+    if (!socketPath || !version) {
+        console.error('Usage: daemon.ts <socket> <version>');
+        process.exit(1);
+    }
+
+    const started = Date.now();
+    let shuttingDown = false;
+    let idleTimer: NodeJS.Timeout;
+    const resetIdleTimer = () => {
+        clearTimeout(idleTimer);
+        idleTimer = setTimeout(() => shutdown('idle'), IDLE_TIMEOUT_MS);
+    };
+
+    const handle = (request: RpcRequest) => {
+        switch (request.method) {
+            case 'ping':
+                return {
+                    protocol: PROTOCOL_VERSION,
+                    analyzer: version,
+                    pid: process.pid,
+                    uptime: (Date.now() - started) / 1000,
+                    projects: [...projects.keys()]
+                };
+            case 'analyze': {
+                const { entrypoint, framework = 'nestjs', pipe, reuse } = request.params ?? {};
+                if (!entrypoint || !pipe) {
+                    throw Object.assign(new Error('analyze needs entrypoint and pipe'), {
+                        code: -32602
+                    });
+                }
+                analyze(entrypoint, framework, pipe, reuse);
+                return {};
+            }
+            case 'shutdown':
+                shuttingDown = true;
+                return {};
+            default:
+                throw Object.assign(new Error(`unknown method ${request.method}`), {
+                    code: -32601
+                });
+        }
+    };
+
+    const server = net.createServer(socket => {
+        let buffered = '';
+        socket.setEncoding('utf8');
+        socket.on('error', () => socket.destroy());
+        socket.on('data', chunk => {
+            buffered += chunk;
+            let newline: number;
+            while ((newline = buffered.indexOf('\n')) >= 0) {
+                const line = buffered.slice(0, newline);
+                buffered = buffered.slice(newline + 1);
+                if (line.trim() === '') {
+                    continue;
+                }
+                resetIdleTimer();
+
+                // Requests are handled synchronously, so they run one at a
+                // time even across connections
+                let request: RpcRequest;
+                try {
+                    request = JSON.parse(line);
+                } catch {
+                    reply(socket, { id: null, error: { code: -32700, message: 'parse error' } });
+                    continue;
+                }
+                try {
+                    reply(socket, { id: request.id, result: handle(request) });
+                } catch (error) {
+                    console.error(`${new Date().toISOString()} ${request.method} failed:`, error);
+                    reply(socket, {
+                        id: request.id,
+                        error: {
+                            // System errors such as EPIPE carry string codes
+                            code: typeof error?.code === 'number' ? error.code : -32000,
+                            message: error instanceof Error ? error.message : String(error)
+                        }
+                    });
+                }
+                if (shuttingDown) {
+                    // Exit once the reply has been flushed
+                    socket.end(() => shutdown('requested'));
+                    return;
+                }
+            }
+        });
+    });
+
+    const shutdown = (reason: string) => {
+        console.error(`${new Date().toISOString()} shutting down (${reason})`);
+        server.close();
+        fs.rmSync(socketPath, { force: true });
+        process.exit(0);
+    };
+
+    fs.rmSync(socketPath, { force: true });
+    server.listen(socketPath, () => {
+        console.error(`${new Date().toISOString()} pid ${process.pid} listening on ${socketPath}`);
+        resetIdleTimer();
+    });
+    process.on('SIGTERM', () => shutdown('SIGTERM'));
+    process.on('SIGINT', () => shutdown('SIGINT'));
+}
+
+function reply(socket: net.Socket, response: object) {
+    if (!socket.destroyed) {
+        socket.write(JSON.stringify({ jsonrpc: '2.0', ...response }) + '\n');
+    }
+}
+
+main();
@@ -0,0 +1,61 @@
+import assert from 'node:assert/strict';
+import { execFileSync, spawn } from 'node:child_process';
+import { once } from 'node:events';
+import * as fs from 'node:fs';
+import * as os from 'node:os';
+import * as path from 'node:path';
+import { test } from 'node:test';
+import { AnalyzerPipe } from '../pipe-pusher';
+
+// fifo makes a named pipe in a directory of its own, as pit does
+function fifo(): string {
+    const dir = fs.mkdtempSync(path.join(os.tmpdir(), 'pit-pipe-'));
+    const pipePath = path.join(dir, 'pipe');
+    execFileSync('mkfifo', [pipePath]);
+    return pipePath;
+}
+
+// Open descriptors of this process, where the platform lists them
+function openFds(): number {
+    return fs.readdirSync('/proc/self/fd').length;
+}
+const hasProcFds = fs.existsSync('/proc/self/fd');
+
+test('gives up when pit never opens the pipe to read', () => {
+    const started = Date.now();
+    assert.throws(() => new AnalyzerPipe(fifo(), 200), /not opened for reading/);
+    assert.ok(Date.now() - started < 5000);
+});
+
+test('fails and closes the pipe when pit goes away before reading', { skip: !hasProcFds }, () => {
+    const pipePath = fifo();
+    const before = openFds();
+
+    // pit opens its end, then exits without reading the hello
+    const reader = fs.openSync(pipePath, fs.constants.O_RDONLY | fs.constants.O_NONBLOCK);
+    const pipe = new AnalyzerPipe(pipePath, 1000);
+    fs.closeSync(reader);
+
+    assert.throws(() => pipe.route({ endpoint: 'GET /users' }), { code: 'EPIPE' });
+    assert.throws(() => pipe.done(), { code: 'EPIPE' });
+    assert.equal(openFds(), before);
+    pipe.close();
+});
+
+test('messages larger than the pipe buffer arrive whole', async () => {
+    const pipePath = fifo();
+    const out = path.join(path.dirname(pipePath), 'out');
+    const reader = spawn('sh', ['-c', 'cat "$0" > "$1"', pipePath, out]);
+    const exited = once(reader, 'exit');
+
+    // Far more than a pipe holds, so writing has to wait for cat
+    const message = 'x'.repeat(1 << 20);
+    const pipe = new AnalyzerPipe(pipePath);
+    pipe.warning(message);
+    pipe.done();
+    await exited;
+
+    const lines = fs.readFileSync(out, 'utf8').trim().split('\n').map(line => JSON.parse(line));
+    assert.deepEqual(lines.map(line => line.type), ['hello', 'warning', 'done']);
+    assert.equal(lines[1].message, message);
+});
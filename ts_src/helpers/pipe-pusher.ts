@@ -1,5 +1,14 @@
 import * as fs from 'fs';
-import { CallInfo } from 'ts_src/common/analyzer';
+import { version as ANALYZER_VERSION } from '../../package.json';
+
+// Must match protocolVersion in protocol.go
+export const PROTOCOL_VERSION = 2;
+
+// How long to wait for pit to open the read end of the pipe. pit opens it
+// as soon as it has asked for an analysis, so running out means it is gone.
+const OPEN_TIMEOUT_MS = 10_000;
+const RETRY_MS = 10;
+
 export interface FunctionRange {
     ControllerName: string;
     FunctionName: string;
@@ -7,46 +16,143 @@ export interface FunctionRange {
     StartLine: number;
     EndLine: number;
 }
-export async function writeToNamedPipe(
-    callInfoArray: CallInfo[],
-    pipePath: string,
-    published_path: string
-): Promise<void> {
-    // Transform CallInfo array to FunctionRange array
-    const functionRanges: FunctionRange[] = callInfoArray.map(callInfo => ({
-        ControllerName: published_path ?? undefined,
-        FunctionName: callInfo.name.replaceAll('\n', ''),
-        Filename: callInfo.location.filePath,
-        StartLine: callInfo.location.startLine,
-        EndLine: callInfo.location.endLine
-    }));
-
-    // Convert to JSON string
-    const jsonData = JSON.stringify(functionRanges);
-
-    try {
-        // Check if pipe exists
+
+export interface RouteMessage {
+    endpoint: string;
+    controller?: string;
+    handler?: string;
+    file?: string;
+}
+
+export interface Warning {
+    // 'unresolved-symbol' and 'skipped-file' are summarized by pit at the end
+    // of a run; anything else is shown as is
+    kind?: 'unresolved-symbol' | 'skipped-file';
+    file?: string;
+    symbol?: string;
+    // The route whose call graph the problem was found in
+    endpoint?: string;
+}
+
+/**
+ * Writes the analyzer protocol to the named pipe pit reads from: one JSON
+ * message per line, opening with hello and closing with done. done carries
+ * the number of routes and functions sent so pit can tell complete output
+ * from a run that died part way through.
+ */
+export class AnalyzerPipe {
+    private fd?: number;
+    private routes = 0;
+    private functions = 0;
+
+    constructor(pipePath: string, timeoutMs = OPEN_TIMEOUT_MS) {
         if (!fs.existsSync(pipePath)) {
             throw new Error(`Named pipe does not exist at path: ${pipePath}`);
         }
+        this.fd = openWriter(pipePath, timeoutMs);
+        try {
+            this.send({ type: 'hello', protocol: PROTOCOL_VERSION, analyzer: ANALYZER_VERSION });
+        } catch (error) {
+            this.close();
+            throw error;
+        }
+    }
 
-        // Open the pipe for writing
-        const writeStream = fs.createWriteStream(pipePath);
+    route(route: RouteMessage) {
+        this.routes++;
+        this.send({ type: 'route', ...route });
+    }
 
-        return new Promise((resolve, reject) => {
-            writeStream.on('error', error => {
-                reject(error);
-            });
+    functionRange(range: FunctionRange) {
+        this.functions++;
+        this.send({ type: 'function', function: range });
+    }
 
-            writeStream.on('finish', () => {
-                resolve();
-            });
+    warning(message: string, warning: Warning = {}) {
+        this.send({ type: 'warning', message, ...warning });
+    }
 
-            // Write the JSON data
-            writeStream.write(jsonData);
-            writeStream.end();
-        });
-    } catch (error) {
-        throw new Error(`Failed to write to named pipe: ${error.message}`);
+    // Lists the files the call graph of endpoint was built from
+    dependencies(endpoint: string, files: string[]) {
+        this.send({ type: 'dependencies', endpoint, files });
     }
+
+    progress(current: number, total: number, message: string) {
+        this.send({ type: 'progress', current, total, message });
+    }
+
+    error(message: string) {
+        try {
+            this.send({ type: 'error', message });
+        } finally {
+            this.close();
+        }
+    }
+
+    done() {
+        try {
+            this.send({ type: 'done', routes: this.routes, functions: this.functions });
+        } finally {
+            this.close();
+        }
+    }
+
+    // Safe to call more than once, and after done or error
+    close() {
+        if (this.fd !== undefined) {
+            fs.closeSync(this.fd);
+            this.fd = undefined;
+        }
+    }
+
+    // Writes are synchronous so messages reach pit in order even if the
+    // process exits straight after. The pipe is non-blocking, so a write
+    // may go through in parts, or not at all while pit catches up. If pit
+    // has gone away the write fails with EPIPE.
+    private send(message: object) {
+        if (this.fd === undefined) {
+            throw new Error('Named pipe is closed');
+        }
+        const data = Buffer.from(JSON.stringify(message) + '\n');
+        let written = 0;
+        while (written < data.length) {
+            try {
+                written += fs.writeSync(this.fd, data, written);
+            } catch (error) {
+                if ((error as NodeJS.ErrnoException).code !== 'EAGAIN') {
+                    throw error;
+                }
+                sleep(RETRY_MS);
+            }
+        }
+    }
+}
+
+// openWriter opens the write end of the pipe without blocking. A plain open
+// would wait for a reader forever, hanging a daemon whose pit died before
+// opening its end.
+function openWriter(pipePath: string, timeoutMs: number): number {
+    const deadline = Date.now() + timeoutMs;
+    for (;;) {
+        try {
+            return fs.openSync(pipePath, fs.constants.O_WRONLY | fs.constants.O_NONBLOCK);
+        } catch (error) {
+            // ENXIO means nobody has the pipe open for reading yet
+            if ((error as NodeJS.ErrnoException).code !== 'ENXIO') {
+                throw error;
+            }
+            if (Date.now() >= deadline) {
+                throw new Error(
+                    `Named pipe at ${pipePath} was not opened for reading within ${timeoutMs}ms`
+                );
+            }
+            sleep(RETRY_MS);
+        }
+    }
+}
+
+// sleep blocks the thread, keeping writes synchronous like the rest of the
+// protocol.
+function sleep(ms: number) {
+    Atomics.wait(new Int32Array(new SharedArrayBuffer(4)), 0, 0, ms);
 }
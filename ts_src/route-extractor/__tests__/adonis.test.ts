@@ -0,0 +1,94 @@
+import assert from 'node:assert/strict';
+import { test } from 'node:test';
+import { extractAdonisRoutes } from '../adonis';
+import { fixture, handlersByPath } from './fixture';
+
+// A middleware class of app/Middleware with a handle method
+function middleware(name: string): string {
+    return `
+        export default class ${name} {
+            public async handle(ctx, next) {
+                await next();
+            }
+        }
+    `;
+}
+
+test('groups, controller references and middleware resolve', () => {
+    const routes = extractAdonisRoutes(
+        fixture({
+            '/app/start/routes.ts': `
+                import Route from '@ioc:Adonis/Core/Route';
+
+                Route.get('/health', 'HealthController.index');
+
+                Route.group(() => {
+                    Route.get('/users', 'UsersController.index');
+                    Route.get('/users/:id', 'UsersController.show').middleware('admin');
+                })
+                    .prefix('/api')
+                    .middleware('auth');
+            `,
+            '/app/start/kernel.ts': `
+                import Server from '@ioc:Adonis/Core/Server';
+
+                Server.middleware.register([() => import('App/Middleware/BodyParser')]);
+                Server.middleware.registerNamed({
+                    auth: () => import('App/Middleware/Auth'),
+                    admin: () => import('App/Middleware/Admin')
+                });
+            `,
+            '/app/app/Middleware/BodyParser.ts': middleware('BodyParser'),
+            '/app/app/Middleware/Auth.ts': middleware('Auth'),
+            '/app/app/Middleware/Admin.ts': middleware('Admin'),
+            '/app/app/Controllers/Http/HealthController.ts': `
+                export default class HealthController {
+                    public async index() {}
+                }
+            `,
+            '/app/app/Controllers/Http/UsersController.ts': `
+                export default class UsersController {
+                    public async index() {}
+                    public async show() {}
+                }
+            `
+        })
+    );
+
+    // Global middleware, then the group's, then the route's own
+    assert.deepEqual(handlersByPath(routes), {
+        'GET /health': ['BodyParser.handle', 'HealthController.index'],
+        'GET /api/users': ['BodyParser.handle', 'Auth.handle', 'UsersController.index'],
+        'GET /api/users/:id': [
+            'BodyParser.handle',
+            'Auth.handle',
+            'Admin.handle',
+            'UsersController.show'
+        ]
+    });
+    const show = routes.find(route => route.published_path === 'GET /api/users/:id');
+    assert.equal(show?.function_name, 'show');
+    assert.equal(show?.controller, 'UsersController');
+    assert.equal(show?.file, '/app/start/routes.ts');
+});
+
+test('a missing controller is flagged even when the route has middleware', () => {
+    const routes = extractAdonisRoutes(
+        fixture({
+            '/app/start/routes.ts': `
+                import Route from '@ioc:Adonis/Core/Route';
+
+                Route.get('/orders', 'OrdersController.index').middleware('auth');
+            `,
+            '/app/start/kernel.ts': `
+                import Server from '@ioc:Adonis/Core/Server';
+
+                Server.middleware.registerNamed({ auth: () => import('App/Middleware/Auth') });
+            `,
+            '/app/app/Middleware/Auth.ts': middleware('Auth')
+        })
+    );
+
+    assert.deepEqual(handlersByPath(routes), { 'GET /orders': ['Auth.handle'] });
+    assert.equal(routes[0].unresolved, true);
+});
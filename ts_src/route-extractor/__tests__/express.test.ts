@@ -0,0 +1,187 @@
+import assert from 'node:assert/strict';
+import * as fs from 'node:fs';
+import * as os from 'node:os';
+import * as path from 'node:path';
+import { test } from 'node:test';
+import { Project } from 'ts-morph';
+import { extractExpressRoutes } from '../express';
+import { fixture, handlersByPath } from './fixture';
+
+test('use() mounts compose prefixes and middleware', () => {
+    const routes = extractExpressRoutes(
+        fixture({
+            '/app/src/app.ts': `
+                import express from 'express';
+                import usersRouter from './users';
+
+                const app = express();
+                function logger(req, res, next) { next(); }
+                function auth(req, res, next) { next(); }
+
+                app.use(logger);
+                app.use('/users', auth, usersRouter);
+                app.get('/health', function health(req, res) { res.send('ok'); });
+
+                export default app;
+            `,
+            '/app/src/users.ts': `
+                import { Router } from 'express';
+
+                const router = Router();
+                export function list(req, res) {}
+                export function show(req, res) {}
+
+                router.get('/', list);
+                router.get('/:id', show);
+
+                export default router;
+            `
+        })
+    );
+
+    assert.deepEqual(handlersByPath(routes), {
+        'GET /health': ['logger', 'health'],
+        'GET /users': ['logger', 'auth', 'list'],
+        'GET /users/:id': ['logger', 'auth', 'show']
+    });
+    const show = routes.find(route => route.published_path === 'GET /users/:id');
+    assert.equal(show?.function_name, 'show');
+    assert.equal(show?.controller, 'users');
+    assert.equal(show?.file, '/app/src/users.ts');
+});
+
+test('route() chains add a route per method', () => {
+    const routes = extractExpressRoutes(
+        fixture({
+            '/app/src/app.ts': `
+                import express from 'express';
+
+                const app = express();
+                const auth = (req, res, next) => next();
+                function show(req, res) {}
+                function update(req, res) {}
+
+                app.route('/items/:id').get(auth, show).put(auth, update);
+            `
+        })
+    );
+
+    assert.deepEqual(handlersByPath(routes), {
+        'GET /items/:id': ['auth', 'show'],
+        'PUT /items/:id': ['auth', 'update']
+    });
+});
+
+test('routers returned from factories are mounted', () => {
+    const routes = extractExpressRoutes(
+        fixture({
+            '/app/src/app.ts': `
+                import express from 'express';
+                import { createUsersRouter } from './users';
+
+                const app = express();
+                app.use('/v1/users', createUsersRouter());
+            `,
+            '/app/src/users.ts': `
+                import { Router } from 'express';
+
+                export function list(req, res) {}
+
+                export function createUsersRouter() {
+                    const router = Router();
+                    router.get('/', list);
+                    return router;
+                }
+            `
+        })
+    );
+
+    assert.deepEqual(handlersByPath(routes), {
+        'GET /v1/users': ['list']
+    });
+});
+
+test('require() route modules are bound to the app passed in', () => {
+    const routes = extractExpressRoutes(
+        fixture({
+            '/app/server.js': `
+                const express = require('express');
+
+                const app = express();
+                require('./routes')(app);
+                app.listen(3000);
+            `,
+            '/app/routes.js': `
+                function list(req, res) {}
+
+                module.exports = function (app) {
+                    app.get('/users', list);
+                };
+            `
+        })
+    );
+
+    assert.deepEqual(handlersByPath(routes), {
+        'GET /users': ['list']
+    });
+    assert.equal(routes[0].controller, 'server');
+    assert.equal(routes[0].file, '/app/routes.js');
+});
+
+test('app.get() reading a setting is not a route', () => {
+    const routes = extractExpressRoutes(
+        fixture({
+            '/app/src/app.ts': `
+                import express from 'express';
+
+                const app = express();
+                function status(req, res) {}
+
+                app.set('port', 3000);
+                app.get('/status', status);
+                app.listen(app.get('port'));
+            `
+        })
+    );
+
+    assert.deepEqual(handlersByPath(routes), {
+        'GET /status': ['status']
+    });
+});
+
+test('routes in files only required are read', () => {
+    // require() in a TypeScript file is not loaded with the project, so the
+    // app is read from disk as loadProject would
+    const dir = fs.mkdtempSync(path.join(os.tmpdir(), 'pit-express-'));
+    try {
+        const app = path.join(dir, 'app.ts');
+        fs.writeFileSync(
+            app,
+            `
+            import express from 'express';
+
+            const app = express();
+            require('./routes')(app);
+            `
+        );
+        fs.writeFileSync(
+            path.join(dir, 'routes.js'),
+            `
+            function list(req, res) {}
+
+            module.exports = function (app) {
+                app.get('/users', list);
+            };
+            `
+        );
+        const project = new Project({ compilerOptions: { allowJs: true } });
+        project.addSourceFileAtPath(app);
+        project.resolveSourceFileDependencies();
+
+        assert.deepEqual(handlersByPath(extractExpressRoutes(project)), {
+            'GET /users': ['list']
+        });
+    } finally {
+        fs.rmSync(dir, { recursive: true });
+    }
+});
@@ -0,0 +1,99 @@
+import assert from 'node:assert/strict';
+import { test } from 'node:test';
+import { extractFastifyRoutes } from '../fastify';
+import { fixture, handlersByPath } from './fixture';
+
+test('plugins are prefixed, encapsulated and share hooks through fastify-plugin', () => {
+    const routes = extractFastifyRoutes(
+        fixture({
+            '/app/src/server.ts': `
+                import Fastify from 'fastify';
+                import usersRoutes from './users';
+                import { adminRoutes } from './admin';
+
+                const app = Fastify();
+                async function requestId(request, reply) {}
+                async function health(request, reply) { return 'ok'; }
+
+                app.addHook('onRequest', requestId);
+                app.get('/health', health);
+                app.register(usersRoutes, { prefix: '/users' });
+                app.register(adminRoutes, { prefix: '/admin' });
+            `,
+            '/app/src/users.ts': `
+                async function loadUser(request, reply) {}
+                async function list(request, reply) {}
+                async function show(request, reply) {}
+
+                export default async function usersRoutes(fastify) {
+                    fastify.get('/', list);
+                    fastify.get('/:id', { preHandler: [loadUser] }, show);
+                }
+            `,
+            '/app/src/admin.ts': `
+                import fp from 'fastify-plugin';
+
+                async function authenticate(request, reply) {}
+                async function audit(request, reply) {}
+                async function auditLog(request, reply) {}
+                async function dashboard(request, reply) {}
+
+                // Adds its hook to the instance registering it
+                const auth = fp(async function (fastify) {
+                    fastify.addHook('preHandler', authenticate);
+                });
+
+                async function auditRoutes(fastify) {
+                    fastify.addHook('onRequest', audit);
+                    fastify.get('/', auditLog);
+                }
+
+                export async function adminRoutes(fastify) {
+                    fastify.register(auth);
+                    fastify.get('/', dashboard);
+                    fastify.register(auditRoutes, { prefix: '/audit' });
+                }
+            `
+        })
+    );
+
+    // authenticate reaches the admin plugin and the plugin nested in it, but
+    // not its sibling users
+    assert.deepEqual(handlersByPath(routes), {
+        'GET /health': ['requestId', 'health'],
+        'GET /users': ['requestId', 'list'],
+        'GET /users/:id': ['requestId', 'loadUser', 'show'],
+        'GET /admin': ['requestId', 'authenticate', 'dashboard'],
+        'GET /admin/audit': ['requestId', 'authenticate', 'audit', 'auditLog']
+    });
+    const show = routes.find(route => route.published_path === 'GET /users/:id');
+    assert.equal(show?.function_name, 'show');
+    assert.equal(show?.controller, 'users');
+    assert.equal(show?.file, '/app/src/users.ts');
+});
+
+test('route() definitions attach their hooks to every method', () => {
+    const routes = extractFastifyRoutes(
+        fixture({
+            '/app/src/server.ts': `
+                import fastify from 'fastify';
+
+                const app = fastify();
+                async function check(request, reply) {}
+                async function list(request, reply) {}
+
+                app.route({
+                    method: ['GET', 'HEAD'],
+                    url: '/items',
+                    onRequest: check,
+                    handler: list
+                });
+            `
+        })
+    );
+
+    assert.deepEqual(handlersByPath(routes), {
+        'GET /items': ['check', 'list'],
+        'HEAD /items': ['check', 'list']
+    });
+});
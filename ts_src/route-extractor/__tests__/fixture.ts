@@ -0,0 +1,46 @@
+import { Node, Project, SyntaxKind } from 'ts-morph';
+import { ExtractedRoute } from '../common';
+
+// Helpers for the extractor tests, which read routes from small apps held in
+// memory rather than from projects on disk.
+
+// fixture loads files, keyed by absolute path, into a project the way
+// loadProject loads an app: TypeScript and JavaScript alike.
+export function fixture(files: Record<string, string>): Project {
+    const project = new Project({
+        useInMemoryFileSystem: true,
+        compilerOptions: { allowJs: true }
+    });
+    for (const [file, text] of Object.entries(files)) {
+        project.createSourceFile(file, text);
+    }
+    return project;
+}
+
+// handlersByPath lists the handlers of every route by name, middleware
+// first, keyed by published path.
+export function handlersByPath(routes: ExtractedRoute[]): Record<string, string[]> {
+    const byPath: Record<string, string[]> = {};
+    for (const route of routes) {
+        byPath[route.published_path] = (route.handlers ?? []).map(nameOf);
+    }
+    return byPath;
+}
+
+// nameOf names a function by its declaration, or by the variable or property
+// it is assigned to. Methods are named along with their class, as in
+// UsersController.show.
+export function nameOf(fn: Node): string {
+    if (Node.isMethodDeclaration(fn)) {
+        const cls = fn.getParentIfKind(SyntaxKind.ClassDeclaration);
+        return `${cls?.getName() ?? 'anonymous'}.${fn.getName()}`;
+    }
+    if ((Node.isFunctionDeclaration(fn) || Node.isFunctionExpression(fn)) && fn.getName()) {
+        return fn.getName()!;
+    }
+    const parent = fn.getParent();
+    if (Node.isVariableDeclaration(parent) || Node.isPropertyAssignment(parent)) {
+        return parent.getName();
+    }
+    return 'anonymous';
+}
@@ -0,0 +1,81 @@
+import assert from 'node:assert/strict';
+import { test } from 'node:test';
+import { extractHapiRoutes } from '../hapi';
+import { fixture, handlersByPath } from './fixture';
+
+test('plugin prefixes, pre methods and extensions run before the handler', () => {
+    const routes = extractHapiRoutes(
+        fixture({
+            '/app/src/server.ts': `
+                import Hapi from '@hapi/hapi';
+                import { usersPlugin } from './users';
+
+                function requestId(request, h) { return h.continue; }
+                function formatErrors(request, h) { return h.continue; }
+                function health(request, h) { return 'ok'; }
+
+                async function start() {
+                    const server = Hapi.server({ port: 3000 });
+                    server.ext('onRequest', requestId);
+                    server.ext('onPreResponse', formatErrors);
+                    await server.register({ plugin: usersPlugin, routes: { prefix: '/v1' } });
+                    server.route({ method: 'GET', path: '/health', handler: health });
+                    await server.start();
+                }
+
+                start();
+            `,
+            '/app/src/users.ts': `
+                function authorize(request, h) { return h.continue; }
+                function audit(request, h) { return h.continue; }
+                function trace(request, h) { return h.continue; }
+                function loadUser(request, h) { return {}; }
+                function checkOwner(request, h) { return h.continue; }
+                function show(request, h) { return request.pre.user; }
+                function update(request, h) { return null; }
+
+                export const usersPlugin = {
+                    name: 'users',
+                    register: async function (server, options) {
+                        // Applies to every route of the root server
+                        server.ext('onPostAuth', authorize);
+                        // Applies to this plugin's routes only
+                        server.ext('onPreHandler', audit, { sandbox: 'plugin' });
+                        server.ext({ type: 'onPostHandler', method: trace });
+                        server.route([
+                            {
+                                method: 'GET',
+                                path: '/users/{id}',
+                                handler: show,
+                                options: { pre: [{ method: loadUser, assign: 'user' }] }
+                            },
+                            {
+                                method: ['PUT', 'PATCH'],
+                                path: '/users/{id}',
+                                options: {
+                                    handler: update,
+                                    ext: {
+                                        onPreHandler: { method: checkOwner },
+                                        onPreResponse: { method: trace }
+                                    }
+                                }
+                            }
+                        ]);
+                    }
+                };
+            `
+        })
+    );
+
+    // formatErrors and trace run after the handler and are left out
+    assert.deepEqual(handlersByPath(routes), {
+        'GET /health': ['requestId', 'authorize', 'health'],
+        'GET /v1/users/{id}': ['requestId', 'authorize', 'audit', 'loadUser', 'show'],
+        'PUT /v1/users/{id}': ['requestId', 'authorize', 'audit', 'checkOwner', 'update'],
+        'PATCH /v1/users/{id}': ['requestId', 'authorize', 'audit', 'checkOwner', 'update']
+    });
+    const show = routes.find(route => route.published_path === 'GET /v1/users/{id}');
+    assert.equal(show?.function_name, 'show');
+    assert.equal(show?.controller, 'users');
+    assert.equal(show?.file, '/app/src/users.ts');
+});
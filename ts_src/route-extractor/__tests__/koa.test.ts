@@ -0,0 +1,51 @@
+import assert from 'node:assert/strict';
+import { test } from 'node:test';
+import { extractKoaRoutes } from '../koa';
+import { fixture, handlersByPath } from './fixture';
+
+test('prefixes and nested routers compose, middleware runs app first', () => {
+    const routes = extractKoaRoutes(
+        fixture({
+            '/app/src/app.ts': `
+                import Koa from 'koa';
+                import Router from '@koa/router';
+                import users from './users';
+
+                const app = new Koa();
+                const api = new Router();
+                api.prefix('/api');
+
+                async function logger(ctx, next) { await next(); }
+                async function auth(ctx, next) { await next(); }
+
+                app.use(logger);
+                api.use(auth);
+                api.use('/v1', users.routes());
+                app.use(api.routes());
+            `,
+            '/app/src/users.ts': `
+                import Router from '@koa/router';
+
+                const router = new Router({ prefix: '/users' });
+
+                async function load(ctx, next) { await next(); }
+                async function list(ctx) {}
+                async function show(ctx) {}
+
+                router.get('/', list).get('/:id', load, show);
+
+                export default router;
+            `
+        })
+    );
+
+    // The app's middleware, then the router's, then the route's own chain
+    assert.deepEqual(handlersByPath(routes), {
+        'GET /api/v1/users': ['logger', 'auth', 'list'],
+        'GET /api/v1/users/:id': ['logger', 'auth', 'load', 'show']
+    });
+    const show = routes.find(route => route.published_path === 'GET /api/v1/users/:id');
+    assert.equal(show?.function_name, 'show');
+    assert.equal(show?.controller, 'users');
+    assert.equal(show?.file, '/app/src/users.ts');
+});
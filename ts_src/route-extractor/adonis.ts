@@ -0,0 +1,464 @@
+import * as fs from 'fs';
+import path from 'path';
+import { CallExpression, ClassDeclaration, Node, Project, SourceFile, SyntaxKind } from 'ts-morph';
+import { validFuncDeclarations } from 'ts_src/common/analyzer';
+import {
+    ExtractedRoute,
+    elementsOf,
+    handlerName,
+    joinPaths,
+    moduleFile,
+    moduleOf,
+    projectSourceFiles,
+    resolveExpression,
+    resolveFunction,
+    returnedValues,
+    stringValue,
+    stringValues
+} from './common';
+
+// AdonisJS declares routes in start/routes.ts on the router service, by
+// controller reference rather than by import:
+//
+//     Route.get('/users/:id', 'UsersController.show').middleware('auth')
+//     router.get('/users/:id', [UsersController, 'show']).use(middleware.auth())
+//     router.group(() => { ... }).prefix('/api').use(middleware.auth())
+//
+// String references name a controller under app/Controllers/Http (Adonis 5)
+// or app/controllers (Adonis 6); tuples name a controller class, usually
+// imported lazily. Named middleware is looked up in start/kernel.ts, and its
+// handle method counts as part of every route it guards, as does the global
+// middleware registered there.
+
+const ROUTER_MODULES = ['@ioc:Adonis/Core/Route', '@adonisjs/core/services/router'];
+
+const METHODS = new Map([
+    ['get', 'GET'],
+    ['post', 'POST'],
+    ['put', 'PUT'],
+    ['patch', 'PATCH'],
+    ['delete', 'DELETE'],
+    ['any', 'ALL']
+]);
+
+// Routes Route.resource() adds, relative to the resource path
+const RESOURCE_ACTIONS = [
+    { action: 'index', method: 'GET', path: '' },
+    { action: 'create', method: 'GET', path: '/create' },
+    { action: 'store', method: 'POST', path: '' },
+    { action: 'show', method: 'GET', path: '/:id' },
+    { action: 'edit', method: 'GET', path: '/:id/edit' },
+    { action: 'update', method: 'PUT', path: '/:id' },
+    { action: 'update', method: 'PATCH', path: '/:id' },
+    { action: 'destroy', method: 'DELETE', path: '/:id' }
+];
+
+// Where string controller references are looked up, relative to the app
+const CONTROLLER_DIRS = ['app/Controllers/Http', 'app/controllers'];
+
+interface Modifier {
+    name: string;
+    args: Node[];
+}
+
+class AdonisRouteExtractor {
+    private root = '';
+    private readonly named = new Map<string, validFuncDeclarations>();
+    private readonly global: validFuncDeclarations[] = [];
+
+    constructor(private readonly project: Project) {}
+
+    extract(): ExtractedRoute[] {
+        const routesFile = this.project
+            .getSourceFiles()
+            .find(sf => /\/start\/routes\.[jt]s$/.test(sf.getFilePath()));
+        if (!routesFile) {
+            return [];
+        }
+        this.root = path.dirname(path.dirname(routesFile.getFilePath()));
+        this.readKernel();
+
+        const routes: ExtractedRoute[] = [];
+        for (const sourceFile of projectSourceFiles(this.project)) {
+            sourceFile.forEachDescendant(node => {
+                if (Node.isCallExpression(node)) {
+                    this.visitCall(node, routes);
+                }
+            });
+        }
+        // Controllers and middleware added along the way import files of
+        // their own
+        this.project.resolveSourceFileDependencies();
+        return routes;
+    }
+
+    private isRouter(node: Node): boolean {
+        const module = Node.isIdentifier(node) ? moduleOf(node) : undefined;
+        return module !== undefined && ROUTER_MODULES.includes(module);
+    }
+
+    // readKernel reads the named and global middleware from start/kernel.ts:
+    // Server.middleware.registerNamed({ ... }) and register([...]) in Adonis
+    // 5, router.named({ ... }), router.use([...]) and server.use([...]) in
+    // Adonis 6.
+    private readKernel() {
+        const kernel = moduleFile(this.project, path.join(this.root, 'start', 'kernel.ts'));
+        for (const call of kernel?.getDescendantsOfKind(SyntaxKind.CallExpression) ?? []) {
+            const callee = call.getExpression();
+            if (!Node.isPropertyAccessExpression(callee)) {
+                continue;
+            }
+            const [arg] = call.getArguments();
+            const value = arg && resolveExpression(arg);
+            switch (callee.getName()) {
+                case 'registerNamed':
+                case 'named':
+                    if (Node.isObjectLiteralExpression(value)) {
+                        for (const property of value.getProperties()) {
+                            if (!Node.isPropertyAssignment(property)) {
+                                continue;
+                            }
+                            const name = property.getName().replace(/^['"]|['"]$/g, '');
+                            const initializer = property.getInitializerOrThrow();
+                            const handle = this.middlewareHandle(initializer, kernel);
+                            if (handle) {
+                                this.named.set(name, handle);
+                            }
+                        }
+                    }
+                    break;
+                case 'register':
+                case 'use':
+                    for (const element of elementsOf(arg)) {
+                        const handle = this.middlewareHandle(element, kernel);
+                        if (handle) {
+                            this.global.push(handle);
+                        }
+                    }
+                    break;
+            }
+        }
+    }
+
+    // middlewareHandle returns the handle method of a middleware class given
+    // as () => import('...'), or the function given instead. Middleware from
+    // installed packages is left out.
+    private middlewareHandle(node: Node, from: SourceFile): validFuncDeclarations | undefined {
+        const cls = this.classOf(node, from);
+        if (cls) {
+            return cls.getMethod('handle');
+        }
+        return this.lazyImport(node) === undefined ? resolveFunction(node) : undefined;
+    }
+
+    // classOf returns the class node refers to: a class, a module whose
+    // default export is one, or a lazy () => import('...') of such a module.
+    private classOf(node: Node, from: SourceFile): ClassDeclaration | undefined {
+        const value = resolveExpression(node);
+        if (Node.isClassDeclaration(value)) {
+            return value;
+        }
+        if (Node.isIdentifier(value)) {
+            // Imported through an alias the compiler cannot resolve, such
+            // as #controllers/users_controller
+            const specifier = moduleOf(value);
+            return specifier ? this.defaultClass(this.resolveModule(specifier, from)) : undefined;
+        }
+        const specifier = this.lazyImport(value);
+        return specifier ? this.defaultClass(this.resolveModule(specifier, from)) : undefined;
+    }
+
+    // lazyImport returns the module a function such as
+    // () => import('#controllers/users_controller') imports.
+    private lazyImport(node: Node): string | undefined {
+        const value = resolveExpression(node);
+        if (!Node.isArrowFunction(value) && !Node.isFunctionExpression(value)) {
+            return undefined;
+        }
+        for (const returned of returnedValues(value)) {
+            if (
+                Node.isCallExpression(returned) &&
+                returned.getExpression().getKind() === SyntaxKind.ImportKeyword
+            ) {
+                return stringValue(returned.getArguments()[0]);
+            }
+        }
+        return undefined;
+    }
+
+    private defaultClass(sourceFile: SourceFile | undefined): ClassDeclaration | undefined {
+        if (!sourceFile) {
+            return undefined;
+        }
+        const exported = sourceFile.getExportedDeclarations().get('default')?.[0];
+        return Node.isClassDeclaration(exported) ? exported : sourceFile.getClasses()[0];
+    }
+
+    // resolveModule returns the file an import specifier refers to from
+    // from: relative paths, Adonis 5 App/ paths and Adonis 6 # subpath
+    // imports declared in package.json.
+    private resolveModule(specifier: string, from: SourceFile): SourceFile | undefined {
+        if (specifier.startsWith('.')) {
+            const dir = path.dirname(from.getFilePath());
+            return moduleFile(this.project, path.resolve(dir, specifier));
+        }
+        if (specifier.startsWith('App/')) {
+            const rest = specifier.slice('App/'.length);
+            return moduleFile(this.project, path.join(this.root, 'app', rest));
+        }
+        if (specifier.startsWith('#')) {
+            for (const [pattern, target] of Object.entries(this.subpathImports())) {
+                const prefix = pattern.replace(/\*$/, '');
+                if (pattern.endsWith('*') ? specifier.startsWith(prefix) : specifier === pattern) {
+                    const file = target.replace('*', specifier.slice(prefix.length));
+                    return moduleFile(this.project, path.join(this.root, file));
+                }
+            }
+        }
+        return undefined;
+    }
+
+    private subpathImports(): Record<string, string> {
+        try {
+            const pkg = JSON.parse(fs.readFileSync(path.join(this.root, 'package.json'), 'utf8'));
+            return typeof pkg.imports === 'object' && pkg.imports ? pkg.imports : {};
+        } catch {
+            return {};
+        }
+    }
+
+    // handlerOf resolves a route handler: 'UsersController.show',
+    // [UsersController, 'show'] or a function.
+    private handlerOf(
+        node: Node | undefined,
+        from: SourceFile,
+        action?: string
+    ): { fn?: validFuncDeclarations; controller: string; name: string } {
+        if (!node) {
+            return { controller: 'routes', name: 'handler' };
+        }
+        const reference = stringValue(node);
+        if (reference !== undefined) {
+            // Resources name just the controller; routes add .method, or
+            // leave it out for a single action controller
+            const dot = reference.lastIndexOf('.');
+            const [controller, method] =
+                action || dot < 0
+                    ? [reference, action ?? 'handle']
+                    : [reference.slice(0, dot), reference.slice(dot + 1)];
+            const cls = this.controllerClass(controller, from);
+            const label = path.basename(controller);
+            return { fn: cls?.getMethod(method), controller: label, name: method };
+        }
+
+        const value = resolveExpression(node);
+        if (Node.isArrayLiteralExpression(value) || action) {
+            const [controllerRef, methodRef] = Node.isArrayLiteralExpression(value)
+                ? value.getElements()
+                : [node];
+            const name = action ?? stringValue(methodRef) ?? 'handle';
+            const cls = controllerRef && this.classOf(controllerRef, from);
+            const controller = cls?.getName() ?? controllerRef?.getText() ?? 'controller';
+            return { fn: cls?.getMethod(name), controller, name };
+        }
+
+        const fn = resolveFunction(node);
+        return { fn, controller: 'routes', name: handlerName(node, fn) };
+    }
+
+    // controllerClass finds the controller a string reference names.
+    private controllerClass(reference: string, from: SourceFile): ClassDeclaration | undefined {
+        if (['App/', '#', '.'].some(prefix => reference.startsWith(prefix))) {
+            return this.defaultClass(this.resolveModule(reference, from));
+        }
+        for (const dir of CONTROLLER_DIRS) {
+            const file = moduleFile(this.project, path.join(this.root, dir, reference));
+            if (file) {
+                return this.defaultClass(file);
+            }
+        }
+        return undefined;
+    }
+
+    // middlewareOf resolves the middleware given to middleware() or use():
+    // names such as 'auth' or 'auth:web', calls such as middleware.auth(),
+    // and functions.
+    private middlewareOf(args: Node[], from: SourceFile): validFuncDeclarations[] {
+        return args.flatMap(arg => elementsOf(arg)).flatMap(element => {
+            const name = stringValue(element);
+            if (name !== undefined) {
+                const handle = this.named.get(name.split(':')[0]);
+                return handle ? [handle] : [];
+            }
+            const value = resolveExpression(element);
+            if (Node.isCallExpression(value)) {
+                const callee = value.getExpression();
+                if (Node.isPropertyAccessExpression(callee) && this.named.has(callee.getName())) {
+                    return [this.named.get(callee.getName())!];
+                }
+            }
+            const handle = this.middlewareHandle(element, from);
+            return handle ? [handle] : [];
+        });
+    }
+
+    // modifiers returns the calls chained onto call, as in
+    // Route.get(...).prefix('/v1').middleware('auth').
+    private modifiers(call: CallExpression): Modifier[] {
+        const modifiers: Modifier[] = [];
+        let node: Node = call;
+        for (;;) {
+            const access = node.getParent();
+            const chained = access?.getParent();
+            if (!Node.isPropertyAccessExpression(access) || !Node.isCallExpression(chained)) {
+                return modifiers;
+            }
+            if (chained.getExpression() !== access) {
+                return modifiers;
+            }
+            modifiers.push({ name: access.getName(), args: chained.getArguments() });
+            node = chained;
+        }
+    }
+
+    // groups returns the modifiers of the groups call is declared in,
+    // outermost first.
+    private groups(call: CallExpression): Modifier[][] {
+        const groups: Modifier[][] = [];
+        let node: Node = call;
+        for (;;) {
+            const fn = node.getFirstAncestor(
+                a => Node.isArrowFunction(a) || Node.isFunctionExpression(a)
+            );
+            if (!fn) {
+                return groups;
+            }
+            const group = fn.getParent();
+            if (Node.isCallExpression(group)) {
+                const callee = group.getExpression();
+                if (
+                    Node.isPropertyAccessExpression(callee) &&
+                    callee.getName() === 'group' &&
+                    this.isRouter(callee.getExpression())
+                ) {
+                    groups.unshift(this.modifiers(group));
+                }
+            }
+            node = fn;
+        }
+    }
+
+    private visitCall(call: CallExpression, routes: ExtractedRoute[]) {
+        const callee = call.getExpression();
+        if (!Node.isPropertyAccessExpression(callee) || !this.isRouter(callee.getExpression())) {
+            return;
+        }
+        const name = callee.getName();
+        const args = call.getArguments();
+        const from = call.getSourceFile();
+
+        // Prefixes and middleware from enclosing groups, then the route's own
+        let prefix = '';
+        const middleware = [...this.global];
+        for (const modifiers of [...this.groups(call), this.modifiers(call)]) {
+            for (const modifier of modifiers) {
+                if (modifier.name === 'prefix') {
+                    prefix = joinPaths(prefix, stringValue(modifier.args[0]) ?? '');
+                } else if (modifier.name === 'middleware' || modifier.name === 'use') {
+                    middleware.push(...this.middlewareFor(modifier.args, from));
+                }
+            }
+        }
+
+        const add = (method: string, routePath: string, handler?: Node, action?: string) => {
+            const resolved = this.handlerOf(handler, from, action);
+            const { fn, controller } = resolved;
+            routes.push({
+                published_path: `${method} ${joinPaths(prefix, routePath)}`,
+                function_name: resolved.name,
+                file: from.getFilePath(),
+                controller,
+                handlers: fn ? [...middleware, fn] : middleware,
+                unresolved: !fn
+            });
+        };
+
+        const method = METHODS.get(name);
+        if (method) {
+            const routePath = stringValue(args[0]);
+            if (routePath !== undefined) {
+                add(method, routePath, args[1]);
+            }
+        } else if (name === 'route') {
+            // Route.route('/path', ['GET', 'POST'], handler)
+            const routePath = stringValue(args[0]);
+            if (routePath !== undefined) {
+                for (const method of stringValues(args[1])) {
+                    add(method.toUpperCase(), routePath, args[2]);
+                }
+            }
+        } else if (name === 'resource') {
+            const resource = stringValue(args[0]);
+            if (resource !== undefined) {
+                const actions = this.resourceActions(this.modifiers(call));
+                for (const { action, method, path: suffix } of RESOURCE_ACTIONS) {
+                    if (actions.has(action)) {
+                        add(method, this.resourcePath(resource) + suffix, args[1], action);
+                    }
+                }
+            }
+        }
+    }
+
+    private middlewareFor(args: Node[], from: SourceFile): validFuncDeclarations[] {
+        // Resources take middleware per action, as { '*': ['auth'] }; every
+        // action's middleware is counted for the whole resource
+        const [first] = args;
+        const value = first && resolveExpression(first);
+        if (Node.isObjectLiteralExpression(value)) {
+            const values = value
+                .getProperties()
+                .flatMap(p => (Node.isPropertyAssignment(p) ? [p.getInitializerOrThrow()] : []));
+            return this.middlewareOf(values, from);
+        }
+        // router.resource(...).use(['store'], middleware.auth())
+        if (args.length > 1 && stringValues(first).length > 0) {
+            return this.middlewareOf(args.slice(1), from);
+        }
+        return this.middlewareOf(args, from);
+    }
+
+    // resourceActions returns the actions a resource keeps after apiOnly(),
+    // only() and except().
+    private resourceActions(modifiers: Modifier[]): Set<string> {
+        let actions = new Set(RESOURCE_ACTIONS.map(a => a.action));
+        for (const { name, args } of modifiers) {
+            if (name === 'apiOnly') {
+                actions.delete('create');
+                actions.delete('edit');
+            } else if (name === 'only') {
+                const only = new Set(stringValues(args[0]));
+                actions = new Set([...actions].filter(a => only.has(a)));
+            } else if (name === 'except') {
+                stringValues(args[0]).forEach(a => actions.delete(a));
+            }
+        }
+        return actions;
+    }
+
+    // resourcePath turns a resource name such as posts.comments into
+    // /posts/:post_id/comments.
+    private resourcePath(resource: string): string {
+        const parts = resource.split('.');
+        const nested = parts.slice(0, -1).map(p => `${p}/:${p.replace(/s$/, '')}_id`);
+        return joinPaths(...nested, parts[parts.length - 1]);
+    }
+}
+
+// extractAdonisRoutes finds the routes declared in start/routes.ts of an
+// AdonisJS app loaded into project.
+function extractAdonisRoutes(project: Project): ExtractedRoute[] {
+    return new AdonisRouteExtractor(project).extract();
+}
+
+export { AdonisRouteExtractor, extractAdonisRoutes };
@@ -0,0 +1,536 @@
+import * as fs from 'fs';
+import path from 'path';
+import {
+    CallExpression,
+    Node,
+    Project,
+    PropertyDeclaration,
+    SourceFile,
+    SyntaxKind
+} from 'ts-morph';
+import { validFuncDeclarations } from 'ts_src/common/analyzer';
+
+// Shared by the extractors of frameworks that register routes by calling
+// functions, such as Express, rather than with decorators: following values
+// across variables, imports and require() to the router or handler they
+// name, and reading route paths.
+
+// ExtractedRoute is a route found by an extractor, in the form the analyzer
+// streams to pit.
+export interface ExtractedRoute {
+    published_path: string;
+    function_name: string;
+    file: string;
+    controller: string;
+    // Functions run for the route, middleware first. Routes found by class
+    // and method name, as in NestJS, leave this out.
+    handlers?: validFuncDeclarations[];
+    // Set when the route's own handler could not be found, even though its
+    // middleware was
+    unresolved?: boolean;
+}
+
+// How far resolveExpression follows references before giving up, which also
+// stops cycles such as a = b; b = a
+const MAX_RESOLVE_DEPTH = 12;
+
+// projectSourceFiles returns the project's own source files, without
+// declaration files and installed packages.
+export function projectSourceFiles(project: Project): SourceFile[] {
+    return project
+        .getSourceFiles()
+        .filter(sf => !sf.isDeclarationFile() && !sf.getFilePath().includes('/node_modules/'));
+}
+
+// loadRequiredModules adds the project files reached through require() to
+// project, along with whatever they import in turn. Imports are loaded with
+// the project, but require() in a TypeScript file is not, and extractors
+// read calls from the files that are loaded before they start.
+export function loadRequiredModules(project: Project) {
+    const scanned = new Set<SourceFile>();
+    for (;;) {
+        const pending = projectSourceFiles(project).filter(sf => !scanned.has(sf));
+        if (pending.length === 0) {
+            return;
+        }
+        for (const sourceFile of pending) {
+            scanned.add(sourceFile);
+            sourceFile.forEachDescendant(node => {
+                if (Node.isCallExpression(node) && isRequire(node)) {
+                    requiredModule(node);
+                }
+            });
+        }
+        project.resolveSourceFileDependencies();
+    }
+}
+
+function unwrap(node: Node): Node {
+    while (
+        Node.isParenthesizedExpression(node) ||
+        Node.isAsExpression(node) ||
+        Node.isNonNullExpression(node) ||
+        Node.isAwaitExpression(node) ||
+        Node.isSatisfiesExpression(node)
+    ) {
+        node = node.getExpression();
+    }
+    return node;
+}
+
+// resolveExpression follows node through variables, imports, require() and
+// property accesses to the expression that produces its value: a function, a
+// call, an object literal, or a source file for a module without a default
+// export. Anything it cannot follow is returned as is.
+export function resolveExpression(node: Node, depth = 0): Node {
+    node = unwrap(node);
+    if (depth > MAX_RESOLVE_DEPTH) {
+        return node;
+    }
+
+    if (Node.isIdentifier(node)) {
+        const declaration = declarationOf(node);
+        return declaration ? valueOfDeclaration(declaration, depth + 1) : node;
+    }
+    if (Node.isPropertyAccessExpression(node)) {
+        const declaration = declarationOf(node.getNameNode());
+        if (declaration) {
+            return valueOfDeclaration(declaration, depth + 1);
+        }
+        const member = memberOf(resolveExpression(node.getExpression(), depth + 1), node.getName());
+        return member ? valueOfDeclaration(member, depth + 1) : node;
+    }
+    if (Node.isCallExpression(node) && isRequire(node)) {
+        const module = requiredModule(node);
+        if (!module) {
+            return node;
+        }
+        const exported = moduleExport(module);
+        return exported ? resolveExpression(exported, depth + 1) : module;
+    }
+    return node;
+}
+
+// declarationOf returns the declaration an identifier refers to, looking
+// through imports.
+function declarationOf(node: Node): Node | undefined {
+    let symbol = node.getSymbol();
+    if (!symbol) {
+        return undefined;
+    }
+    if (symbol.isAlias()) {
+        symbol = symbol.getAliasedSymbol() ?? symbol;
+    }
+    const declaration = symbol.getValueDeclaration() ?? symbol.getDeclarations()[0];
+    if (declaration && Node.isShorthandPropertyAssignment(declaration)) {
+        // { list } names the variable list, not the property
+        const checker = node.getProject().getTypeChecker();
+        const value = checker.getShorthandAssignmentValueSymbol(declaration);
+        return value?.getValueDeclaration() ?? value?.getDeclarations()[0];
+    }
+    return declaration;
+}
+
+function valueOfDeclaration(declaration: Node, depth: number): Node {
+    if (Node.isVariableDeclaration(declaration) || Node.isPropertyAssignment(declaration)) {
+        const initializer = declaration.getInitializer();
+        return initializer ? resolveExpression(initializer, depth) : declaration;
+    }
+    if (Node.isPropertyDeclaration(declaration)) {
+        // Class fields, whether initialized in place or in the constructor
+        // as this.app = express()
+        const initializer = declaration.getInitializer() ?? constructorAssignment(declaration);
+        return initializer ? resolveExpression(initializer, depth) : declaration;
+    }
+    if (Node.isExportAssignment(declaration)) {
+        return resolveExpression(declaration.getExpression(), depth);
+    }
+    if (Node.isBinaryExpression(declaration)) {
+        // module.exports = router and exports.list = function () {} in
+        // CommonJS modules
+        return resolveExpression(declaration.getRight(), depth);
+    }
+    const parent = declaration.getParent();
+    if (parent && Node.isBinaryExpression(parent) && parent.getLeft() === declaration) {
+        return resolveExpression(parent.getRight(), depth);
+    }
+    return declaration;
+}
+
+function constructorAssignment(property: PropertyDeclaration): Node | undefined {
+    const target = `this.${property.getName()}`;
+    const constructor = property.getParentIfKind(SyntaxKind.ClassDeclaration)?.getConstructors()[0];
+    for (const statement of constructor?.getStatements() ?? []) {
+        const assignment = commonJsAssignment(statement);
+        if (assignment && assignment.target === target) {
+            return assignment.value;
+        }
+    }
+    return undefined;
+}
+
+// memberOf finds name on a resolved object literal or module.
+function memberOf(value: Node, name: string): Node | undefined {
+    if (Node.isObjectLiteralExpression(value)) {
+        const property = value.getProperty(name);
+        if (property && Node.isShorthandPropertyAssignment(property)) {
+            return declarationOf(property.getNameNode()) ?? property;
+        }
+        return property;
+    }
+    if (Node.isSourceFile(value)) {
+        const exported = value.getExportedDeclarations().get(name)?.[0];
+        if (exported) {
+            return exported;
+        }
+        // exports.name = ... in a CommonJS module
+        for (const statement of value.getStatements()) {
+            const assignment = commonJsAssignment(statement);
+            if (assignment && assignment.target === `exports.${name}`) {
+                return assignment.value;
+            }
+        }
+    }
+    return undefined;
+}
+
+function isRequire(call: CallExpression): boolean {
+    const args = call.getArguments();
+    return (
+        call.getExpression().getText() === 'require' &&
+        args.length === 1 &&
+        stringValue(args[0]) !== undefined
+    );
+}
+
+const MODULE_EXTENSIONS = [
+    '',
+    '.ts',
+    '.tsx',
+    '.js',
+    '.jsx',
+    '.mjs',
+    '.cjs',
+    '/index.ts',
+    '/index.js'
+];
+
+// requiredModule returns the project file a require() call loads, if it is
+// one of the project's own.
+function requiredModule(call: CallExpression): SourceFile | undefined {
+    const specifier = stringValue(call.getArguments()[0]);
+    if (!specifier || !specifier.startsWith('.')) {
+        return undefined;
+    }
+    const base = path.resolve(path.dirname(call.getSourceFile().getFilePath()), specifier);
+    return moduleFile(call.getProject(), base);
+}
+
+// moduleFile returns the source file a module path without its extension,
+// or with a .js extension standing for a .ts file, refers to, adding it to
+// project if it is not loaded yet.
+export function moduleFile(project: Project, base: string): SourceFile | undefined {
+    const bases = base.endsWith('.js') ? [base, base.slice(0, -3)] : [base];
+    for (const candidate of bases.flatMap(b => MODULE_EXTENSIONS.map(extension => b + extension))) {
+        const existing = project.getSourceFile(candidate);
+        if (existing) {
+            return existing;
+        }
+        if (fs.existsSync(candidate) && fs.statSync(candidate).isFile()) {
+            return project.addSourceFileAtPath(candidate);
+        }
+    }
+    return undefined;
+}
+
+// moduleExport returns the expression a module exports as a whole: export
+// default, export = or module.exports =.
+function moduleExport(module: SourceFile): Node | undefined {
+    const assignment = module.getExportAssignments()[0];
+    if (assignment) {
+        return assignment.getExpression();
+    }
+    for (const statement of module.getStatements()) {
+        const exported = commonJsAssignment(statement);
+        if (exported && exported.target === 'module.exports') {
+            return exported.value;
+        }
+    }
+    return undefined;
+}
+
+// commonJsAssignment reads a statement of the form target = value, as in
+// module.exports = router.
+function commonJsAssignment(statement: Node): { target: string; value: Node } | undefined {
+    if (!Node.isExpressionStatement(statement)) {
+        return undefined;
+    }
+    const expression = statement.getExpression();
+    if (
+        !Node.isBinaryExpression(expression) ||
+        expression.getOperatorToken().getKind() !== SyntaxKind.EqualsToken
+    ) {
+        return undefined;
+    }
+    return {
+        target: expression.getLeft().getText().replace(/^module\.exports\./, 'exports.'),
+        value: expression.getRight()
+    };
+}
+
+// moduleOf returns the module specifier an identifier was imported or
+// required from, such as 'express' for both import express from 'express'
+// and const { Router } = require('express').
+export function moduleOf(identifier: Node): string | undefined {
+    const symbol = identifier.getSymbol();
+    for (const declaration of symbol?.getDeclarations() ?? []) {
+        const importDeclaration = declaration.getFirstAncestorByKind(SyntaxKind.ImportDeclaration);
+        if (importDeclaration) {
+            return importDeclaration.getModuleSpecifierValue();
+        }
+        const variable = Node.isVariableDeclaration(declaration)
+            ? declaration
+            : declaration.getFirstAncestorByKind(SyntaxKind.VariableDeclaration);
+        const initializer = variable?.getInitializer();
+        if (Node.isCallExpression(initializer) && isRequire(initializer)) {
+            return stringValue(initializer.getArguments()[0]);
+        }
+    }
+    return undefined;
+}
+
+// ParameterBindings records what value each parameter of a function receives
+// where the function is called, so routes registered inside
+// module.exports = app => { app.get(...) } are attached to the app it is
+// called with. Only the first value seen for a parameter is kept.
+export class ParameterBindings<T> {
+    private readonly bindings = new Map<Node, T>();
+
+    get(parameter: Node): T | undefined {
+        return this.bindings.get(parameter);
+    }
+
+    // bind binds parameter to value unless it is bound already, reporting
+    // whether it was.
+    bind(parameter: Node, value: T): boolean {
+        if (this.bindings.has(parameter)) {
+            return false;
+        }
+        this.bindings.set(parameter, value);
+        return true;
+    }
+
+    // bindCalls binds the parameters of every function called in calls to
+    // the arguments valueOf recognizes. Binding one parameter can let
+    // valueOf recognize an argument passed further down, so this repeats
+    // until nothing new is bound.
+    bindCalls(calls: CallExpression[], valueOf: (arg: Node) => T | undefined) {
+        for (let round = 0; round < 4; round++) {
+            let bound = false;
+            for (const call of calls) {
+                const args = call.getArguments();
+                const values = args.map(arg =>
+                    Node.isIdentifier(arg) || Node.isPropertyAccessExpression(arg)
+                        ? valueOf(arg)
+                        : undefined
+                );
+                if (values.every(v => v === undefined)) {
+                    continue;
+                }
+                const fn = resolveFunction(call.getExpression());
+                const parameters = fn?.getParameters() ?? [];
+                values.forEach((value, i) => {
+                    if (value !== undefined && parameters[i] && this.bind(parameters[i], value)) {
+                        bound = true;
+                    }
+                });
+            }
+            if (!bound) {
+                return;
+            }
+        }
+    }
+}
+
+function isFunction(node: Node): node is validFuncDeclarations {
+    return (
+        Node.isFunctionDeclaration(node) ||
+        Node.isArrowFunction(node) ||
+        Node.isFunctionExpression(node) ||
+        Node.isMethodDeclaration(node)
+    );
+}
+
+// resolveFunction returns the function node refers to, looking through
+// wrappers such as asyncHandler(fn) and fn.bind(this). Functions without a
+// body, from declaration files, are not returned.
+export function resolveFunction(node: Node, depth = 0): validFuncDeclarations | undefined {
+    if (depth > MAX_RESOLVE_DEPTH) {
+        return undefined;
+    }
+    const value = resolveExpression(node);
+    if (isFunction(value)) {
+        return value.getSourceFile().isDeclarationFile() ? undefined : value;
+    }
+    if (Node.isCallExpression(value)) {
+        const callee = unwrap(value.getExpression());
+        if (Node.isPropertyAccessExpression(callee) && callee.getName() === 'bind') {
+            return resolveFunction(callee.getExpression(), depth + 1);
+        }
+        for (const arg of value.getArguments()) {
+            const fn = resolveFunction(arg, depth + 1);
+            if (fn) {
+                return fn;
+            }
+        }
+    }
+    return undefined;
+}
+
+// returnedValues returns what a function can return, for routers built by
+// factory functions such as createUsersRouter().
+export function returnedValues(fn: Node): Node[] {
+    if (!isFunction(fn)) {
+        return [];
+    }
+    if (Node.isArrowFunction(fn)) {
+        const body = fn.getBody();
+        if (!Node.isBlock(body)) {
+            return [body];
+        }
+    }
+    return fn
+        .getDescendantsOfKind(SyntaxKind.ReturnStatement)
+        .filter(ret => ret.getFirstAncestor(a => isFunction(a)) === fn)
+        .map(ret => ret.getExpression())
+        .filter((e): e is NonNullable<typeof e> => e !== undefined);
+}
+
+// propertyValue returns the value of property name on the object literal
+// or module node evaluates to, as in { prefix: '/v1' } or { handler }.
+export function propertyValue(node: Node | undefined, name: string): Node | undefined {
+    const value = node && resolveExpression(node);
+    if (Node.isSourceFile(value)) {
+        const member = memberOf(value, name);
+        return member && valueOfDeclaration(member, 0);
+    }
+    if (!Node.isObjectLiteralExpression(value)) {
+        return undefined;
+    }
+    const property = value.getProperty(name);
+    if (Node.isPropertyAssignment(property)) {
+        return property.getInitializer();
+    }
+    if (Node.isShorthandPropertyAssignment(property)) {
+        return property.getNameNode();
+    }
+    if (Node.isMethodDeclaration(property)) {
+        return property;
+    }
+    return undefined;
+}
+
+// elementsOf returns the elements of the array node evaluates to, or node
+// itself, for options that take one function or a list of them. Arrays
+// spread into the array are expanded.
+export function elementsOf(node: Node | undefined, depth = 0): Node[] {
+    if (!node || depth > MAX_RESOLVE_DEPTH) {
+        return [];
+    }
+    const value = resolveExpression(node);
+    if (!Node.isArrayLiteralExpression(value)) {
+        return [node];
+    }
+    return value.getElements().flatMap(element => {
+        if (Node.isSpreadElement(element)) {
+            return elementsOf(element.getExpression(), depth + 1);
+        }
+        return [element];
+    });
+}
+
+// handlerName names a route handler after the function or, for an inline
+// function, after the argument it was passed as.
+export function handlerName(arg: Node | undefined, fn: validFuncDeclarations | undefined): string {
+    if (fn && !Node.isArrowFunction(fn) && fn.getName()) {
+        return fn.getName()!;
+    }
+    const parent = fn?.getParent();
+    if (parent && (Node.isVariableDeclaration(parent) || Node.isPropertyAssignment(parent))) {
+        return parent.getName();
+    }
+    if (arg && (Node.isIdentifier(arg) || Node.isPropertyAccessExpression(arg))) {
+        return arg.getText();
+    }
+    return 'handler';
+}
+
+// stringValue evaluates a route path: string literals, constants, plain
+// templates and concatenations of those. Anything else is undefined.
+export function stringValue(node: Node | undefined, depth = 0): string | undefined {
+    if (!node || depth > MAX_RESOLVE_DEPTH) {
+        return undefined;
+    }
+    node = unwrap(node);
+    if (Node.isStringLiteral(node) || Node.isNoSubstitutionTemplateLiteral(node)) {
+        return node.getLiteralValue();
+    }
+    if (Node.isTemplateExpression(node)) {
+        let value = node.getHead().getLiteralText();
+        for (const span of node.getTemplateSpans()) {
+            const part = stringValue(span.getExpression(), depth + 1);
+            if (part === undefined) {
+                return undefined;
+            }
+            value += part + span.getLiteral().getLiteralText();
+        }
+        return value;
+    }
+    if (Node.isBinaryExpression(node)) {
+        if (node.getOperatorToken().getKind() !== SyntaxKind.PlusToken) {
+            return undefined;
+        }
+        const left = stringValue(node.getLeft(), depth + 1);
+        const right = stringValue(node.getRight(), depth + 1);
+        return left !== undefined && right !== undefined ? left + right : undefined;
+    }
+    if (Node.isIdentifier(node) || Node.isPropertyAccessExpression(node)) {
+        const value = resolveExpression(node);
+        return value === node ? undefined : stringValue(value, depth + 1);
+    }
+    return undefined;
+}
+
+// stringValues evaluates a path argument that may be a single path or an
+// array of them, as in app.get(['/users', '/people'], list).
+export function stringValues(node: Node | undefined): string[] {
+    return elementsOf(node).flatMap(element => {
+        const value = stringValue(element);
+        return value === undefined ? [] : [value];
+    });
+}
+
+// joinPaths joins route path segments the way routers mount them, without
+// doubled or trailing slashes.
+export function joinPaths(...segments: string[]): string {
+    const joined = segments
+        .filter(s => s !== '')
+        .join('/')
+        .replace(/\/+/g, '/')
+        .replace(/(.)\/$/, '$1');
+    return joined.startsWith('/') ? joined : '/' + joined;
+}
+
+// pathUnder reports whether a route path falls under a mount prefix.
+export function pathUnder(route: string, prefix: string): boolean {
+    const p = joinPaths(prefix);
+    const r = joinPaths(route);
+    return p === '/' || r === p || r.startsWith(p + '/');
+}
+
+// fileLabel names a route's controller after its file: users for both
+// src/routes/users.js and src/users/index.js.
+export function fileLabel(file: string): string {
+    const name = path.basename(file).replace(/\.[cm]?[jt]sx?$/, '');
+    return name === 'index' ? path.basename(path.dirname(file)) : name;
+}
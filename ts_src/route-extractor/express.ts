@@ -0,0 +1,291 @@
+import { CallExpression, Node, Project } from 'ts-morph';
+import { validFuncDeclarations } from 'ts_src/common/analyzer';
+import {
+    ExtractedRoute,
+    ParameterBindings,
+    elementsOf,
+    fileLabel,
+    handlerName,
+    joinPaths,
+    loadRequiredModules,
+    moduleOf,
+    pathUnder,
+    projectSourceFiles,
+    resolveExpression,
+    resolveFunction,
+    returnedValues,
+    stringValue,
+    stringValues
+} from './common';
+
+// Express registers routes by calling methods on an app or a router:
+//
+//     app.get('/health', health)
+//     router.route('/:id').get(auth, show).put(auth, update)
+//     app.use('/users', auth, usersRouter)
+//
+// Routers are found where they are created, by express() or
+// express.Router(), and followed through variables, imports, require() and
+// factory functions to every call made on them. Paths are composed along
+// use() mounts, and middleware registered with use() runs before the
+// handlers of every route under it. Registration order is not tracked, so
+// middleware added after a route is counted too; for impact analysis an
+// extra dependency is better than a missed one.
+
+const METHODS = ['get', 'post', 'put', 'delete', 'patch', 'options', 'head', 'all'];
+
+interface ExpressRouter {
+    label: string;
+    routes: RouterRoute[];
+    mounts: Mount[];
+    middleware: Middleware[];
+}
+
+interface RouterRoute {
+    method: string;
+    path: string;
+    handlers: Node[];
+    file: string;
+}
+
+interface Mount {
+    prefix: string;
+    router: ExpressRouter;
+    middleware: Node[];
+}
+
+interface Middleware {
+    prefix: string;
+    handler: Node;
+}
+
+class ExpressRouteExtractor {
+    // Keyed by the express() or Router() call that created each router
+    private readonly routers = new Map<Node, ExpressRouter>();
+    private readonly bindings = new ParameterBindings<ExpressRouter>();
+    private readonly mounted = new Set<ExpressRouter>();
+
+    constructor(private readonly project: Project) {}
+
+    extract(): ExtractedRoute[] {
+        loadRequiredModules(this.project);
+        const calls: CallExpression[] = [];
+        for (const sourceFile of projectSourceFiles(this.project)) {
+            sourceFile.forEachDescendant(node => {
+                if (Node.isCallExpression(node)) {
+                    calls.push(node);
+                }
+            });
+        }
+
+        // Routers passed to functions, as in require('./routes')(app), are
+        // bound to the parameters they arrive in before routes are read, so
+        // that app.get() inside those functions finds its router
+        this.bindings.bindCalls(calls, arg => this.routerOf(arg));
+        for (const call of calls) {
+            this.visitCall(call);
+        }
+
+        const routes: ExtractedRoute[] = [];
+        for (const router of this.routers.values()) {
+            if (!this.mounted.has(router)) {
+                this.emit(router, '', [], new Set(), routes);
+            }
+        }
+        return routes;
+    }
+
+    private isRouterFactory(call: Node): call is CallExpression {
+        if (!Node.isCallExpression(call)) {
+            return false;
+        }
+        const callee = call.getExpression();
+        if (Node.isIdentifier(callee)) {
+            // express() and Router(), however they were imported
+            const module = moduleOf(callee);
+            if (module !== undefined) {
+                return module === 'express';
+            }
+            return ['express', 'Router'].includes(callee.getText());
+        }
+        if (Node.isPropertyAccessExpression(callee) && callee.getName() === 'Router') {
+            const object = callee.getExpression();
+            return !Node.isIdentifier(object) || (moduleOf(object) ?? 'express') === 'express';
+        }
+        return false;
+    }
+
+    // routerOf returns the router node evaluates to, if any.
+    private routerOf(node: Node, depth = 0): ExpressRouter | undefined {
+        const value = resolveExpression(node);
+        if (this.isRouterFactory(value)) {
+            let router = this.routers.get(value);
+            if (!router) {
+                const label = fileLabel(value.getSourceFile().getFilePath());
+                router = { label, routes: [], mounts: [], middleware: [] };
+                this.routers.set(value, router);
+            }
+            return router;
+        }
+        if (Node.isParameterDeclaration(value)) {
+            return this.bindings.get(value);
+        }
+        if (Node.isCallExpression(value) && depth < 3) {
+            // A factory such as createUsersRouter() returning a router
+            const factory = resolveFunction(value.getExpression());
+            for (const returned of factory ? returnedValues(factory) : []) {
+                const router = this.routerOf(returned, depth + 1);
+                if (router) {
+                    return router;
+                }
+            }
+        }
+        return undefined;
+    }
+
+    private visitCall(call: CallExpression) {
+        const callee = call.getExpression();
+        if (!Node.isPropertyAccessExpression(callee)) {
+            return;
+        }
+        const name = callee.getName();
+        const file = call.getSourceFile().getFilePath();
+
+        if (METHODS.includes(name)) {
+            const chain = this.routeChain(callee.getExpression());
+            if (chain) {
+                // router.route('/path').get(...).post(...)
+                const router = this.routerOf(chain.receiver);
+                const path = stringValue(chain.path);
+                if (router && path !== undefined && call.getArguments().length > 0) {
+                    router.routes.push({ method: name, path, handlers: call.getArguments(), file });
+                }
+                return;
+            }
+
+            const router = this.routerOf(callee.getExpression());
+            const [pathArg, ...handlers] = call.getArguments();
+            // app.get('setting') reads a setting rather than adding a route
+            if (!router || handlers.length === 0) {
+                return;
+            }
+            for (const path of stringValues(pathArg)) {
+                router.routes.push({ method: name, path, handlers, file });
+            }
+            return;
+        }
+
+        if (name === 'use') {
+            const router = this.routerOf(callee.getExpression());
+            if (router) {
+                this.visitUse(router, call);
+            }
+        }
+    }
+
+    // routeChain finds the route('/path') call at the start of a chain such
+    // as router.route('/path').get(a).post(b).
+    private routeChain(node: Node): { receiver: Node; path: Node } | undefined {
+        while (Node.isCallExpression(node)) {
+            const callee = node.getExpression();
+            if (!Node.isPropertyAccessExpression(callee)) {
+                return undefined;
+            }
+            if (callee.getName() === 'route') {
+                const path = node.getArguments()[0];
+                return path ? { receiver: callee.getExpression(), path } : undefined;
+            }
+            if (!METHODS.includes(callee.getName())) {
+                return undefined;
+            }
+            node = callee.getExpression();
+        }
+        return undefined;
+    }
+
+    private visitUse(router: ExpressRouter, call: CallExpression) {
+        let args = call.getArguments();
+        let prefixes = [''];
+        if (args.length > 1) {
+            const paths = stringValues(args[0]);
+            if (paths.length > 0) {
+                prefixes = paths;
+                args = args.slice(1);
+            }
+        }
+
+        // Middleware passed along with routers guards only them, as in
+        // app.use('/admin', auth, adminRouter)
+        const flattened = args.flatMap(arg => elementsOf(arg));
+        const children: ExpressRouter[] = [];
+        const middleware: Node[] = [];
+        for (const arg of flattened) {
+            const child = this.routerOf(arg);
+            if (child && child !== router) {
+                children.push(child);
+            } else {
+                middleware.push(arg);
+            }
+        }
+
+        for (const prefix of prefixes) {
+            for (const child of children) {
+                router.mounts.push({ prefix, router: child, middleware });
+                this.mounted.add(child);
+            }
+            if (children.length === 0) {
+                router.middleware.push(...middleware.map(handler => ({ prefix, handler })));
+            }
+        }
+    }
+
+    // emit adds the routes of router mounted at prefix, and of the routers
+    // mounted on it, to routes.
+    private emit(
+        router: ExpressRouter,
+        prefix: string,
+        inherited: Node[],
+        visiting: Set<ExpressRouter>,
+        routes: ExtractedRoute[]
+    ) {
+        visiting.add(router);
+        const middlewareFor = (path: string) =>
+            router.middleware.filter(m => pathUnder(path, m.prefix)).map(m => m.handler);
+
+        for (const route of router.routes) {
+            const args = [...inherited, ...middlewareFor(route.path), ...route.handlers];
+            const handlers = args
+                .map(arg => resolveFunction(arg))
+                .filter((fn): fn is validFuncDeclarations => fn !== undefined);
+            const last = route.handlers[route.handlers.length - 1];
+            routes.push({
+                published_path: `${route.method.toUpperCase()} ${joinPaths(prefix, route.path)}`,
+                function_name: handlerName(last, resolveFunction(last)),
+                file: route.file,
+                controller: router.label,
+                handlers
+            });
+        }
+        for (const mount of router.mounts) {
+            if (visiting.has(mount.router)) {
+                continue;
+            }
+            this.emit(
+                mount.router,
+                joinPaths(prefix, mount.prefix),
+                [...inherited, ...middlewareFor(mount.prefix), ...mount.middleware],
+                visiting,
+                routes
+            );
+        }
+        visiting.delete(router);
+    }
+}
+
+// extractExpressRoutes finds the routes of an Express app loaded into
+// project.
+function extractExpressRoutes(project: Project): ExtractedRoute[] {
+    return new ExpressRouteExtractor(project).extract();
+}
+
+export { ExpressRouteExtractor, extractExpressRoutes };
@@ -0,0 +1,306 @@
+import { CallExpression, Node, Project } from 'ts-morph';
+import { validFuncDeclarations } from 'ts_src/common/analyzer';
+import {
+    ExtractedRoute,
+    ParameterBindings,
+    elementsOf,
+    fileLabel,
+    handlerName,
+    joinPaths,
+    loadRequiredModules,
+    moduleOf,
+    projectSourceFiles,
+    propertyValue,
+    resolveExpression,
+    resolveFunction,
+    returnedValues,
+    stringValue
+} from './common';
+
+// Fastify registers routes on an instance, either with a shorthand method or
+// with a full route definition:
+//
+//     fastify.get('/health', { preHandler: auth }, health)
+//     fastify.route({ method: ['GET', 'HEAD'], url: '/users', handler: list })
+//     fastify.register(usersRoutes, { prefix: '/users' })
+//
+// A plugin passed to register() gets a child instance as its first
+// parameter, which inherits the parent's hooks and adds the prefix it was
+// registered with to every route registered on it. A plugin registered
+// twice, under two prefixes, serves its routes under both. Plugins wrapped
+// with fastify-plugin share their parent's instance instead. The request
+// hooks that run before a handler, whether added with addHook() or given in
+// a route's options, count as part of the route. As with Express,
+// registration order is not tracked.
+
+const METHODS = ['get', 'post', 'put', 'delete', 'patch', 'options', 'head', 'all'];
+
+// Hooks run before the handler, in the order Fastify runs them
+const REQUEST_HOOKS = ['onRequest', 'preParsing', 'preValidation', 'preHandler'];
+
+interface FastifyInstance {
+    label: string;
+    routes: InstanceRoute[];
+    hooks: Node[];
+    registrations: Registration[];
+}
+
+interface Registration {
+    prefix: string;
+    instance: FastifyInstance;
+}
+
+interface InstanceRoute {
+    method: string;
+    path: string;
+    handler: Node;
+    hooks: Node[];
+    file: string;
+}
+
+class FastifyRouteExtractor {
+    // Keyed by the fastify() call that created each root instance; plugins'
+    // instances are bound to their first parameter
+    private readonly instances = new Map<Node, FastifyInstance>();
+    private readonly bindings = new ParameterBindings<FastifyInstance>();
+    private readonly registered = new Set<CallExpression>();
+
+    constructor(private readonly project: Project) {}
+
+    extract(): ExtractedRoute[] {
+        loadRequiredModules(this.project);
+        const calls: CallExpression[] = [];
+        for (const sourceFile of projectSourceFiles(this.project)) {
+            sourceFile.forEachDescendant(node => {
+                if (Node.isCallExpression(node)) {
+                    calls.push(node);
+                }
+            });
+        }
+
+        // Plugins can register plugins of their own, so keep binding until
+        // every instance reachable from a root is known
+        for (let round = 0; round < 4; round++) {
+            this.bindings.bindCalls(calls, arg => this.instanceOf(arg));
+            const added = calls.filter(call => this.visitRegister(call));
+            if (added.length === 0) {
+                break;
+            }
+        }
+        for (const call of calls) {
+            this.visitCall(call);
+        }
+
+        const routes: ExtractedRoute[] = [];
+        for (const instance of this.instances.values()) {
+            this.emit(instance, '', [], new Set(), routes);
+        }
+        return routes;
+    }
+
+    private isFactory(call: Node): call is CallExpression {
+        if (!Node.isCallExpression(call)) {
+            return false;
+        }
+        const callee = call.getExpression();
+        if (Node.isIdentifier(callee)) {
+            const module = moduleOf(callee);
+            if (module !== undefined) {
+                return module === 'fastify';
+            }
+            return callee.getText().toLowerCase() === 'fastify';
+        }
+        // require('fastify')({ logger: true })
+        return (
+            Node.isCallExpression(callee) &&
+            callee.getExpression().getText() === 'require' &&
+            stringValue(callee.getArguments()[0]) === 'fastify'
+        );
+    }
+
+    // instanceOf returns the instance node evaluates to, if any.
+    private instanceOf(node: Node, depth = 0): FastifyInstance | undefined {
+        const value = resolveExpression(node);
+        if (this.isFactory(value)) {
+            let instance = this.instances.get(value);
+            if (!instance) {
+                const label = fileLabel(value.getSourceFile().getFilePath());
+                instance = { label, routes: [], hooks: [], registrations: [] };
+                this.instances.set(value, instance);
+            }
+            return instance;
+        }
+        if (Node.isParameterDeclaration(value)) {
+            return this.bindings.get(value);
+        }
+        if (Node.isCallExpression(value) && depth < 3) {
+            // A builder such as buildApp() returning an instance
+            const factory = resolveFunction(value.getExpression());
+            for (const returned of factory ? returnedValues(factory) : []) {
+                const instance = this.instanceOf(returned, depth + 1);
+                if (instance) {
+                    return instance;
+                }
+            }
+        }
+        return undefined;
+    }
+
+    // visitRegister records the plugin registered by call on the instance
+    // it is registered on, reporting whether call was new.
+    private visitRegister(call: CallExpression): boolean {
+        const callee = call.getExpression();
+        if (
+            this.registered.has(call) ||
+            !Node.isPropertyAccessExpression(callee) ||
+            callee.getName() !== 'register'
+        ) {
+            return false;
+        }
+        const parent = this.instanceOf(callee.getExpression());
+        const [pluginArg, options] = call.getArguments();
+        const plugin = pluginArg && resolveFunction(pluginArg);
+        const parameter = plugin?.getParameters()[0];
+        if (!parent || !parameter) {
+            return false;
+        }
+        this.registered.add(call);
+
+        if (this.isSharedPlugin(pluginArg)) {
+            this.bindings.bind(parameter, parent);
+            return true;
+        }
+        let child = this.bindings.get(parameter);
+        if (!child) {
+            const label = fileLabel(parameter.getSourceFile().getFilePath());
+            child = { label, routes: [], hooks: [], registrations: [] };
+            this.bindings.bind(parameter, child);
+        }
+        const prefix = stringValue(propertyValue(options, 'prefix')) ?? '';
+        parent.registrations.push({ prefix, instance: child });
+        return true;
+    }
+
+    // isSharedPlugin reports whether a plugin is wrapped with fastify-plugin,
+    // which runs it on its parent's instance rather than a child.
+    private isSharedPlugin(plugin: Node): boolean {
+        const value = resolveExpression(plugin);
+        if (!Node.isCallExpression(value)) {
+            return false;
+        }
+        const callee = value.getExpression();
+        if (!Node.isIdentifier(callee)) {
+            return false;
+        }
+        const module = moduleOf(callee);
+        return module === 'fastify-plugin' || (module === undefined && callee.getText() === 'fp');
+    }
+
+    private visitCall(call: CallExpression) {
+        const callee = call.getExpression();
+        if (!Node.isPropertyAccessExpression(callee)) {
+            return;
+        }
+        const name = callee.getName();
+        if (name !== 'route' && name !== 'addHook' && !METHODS.includes(name)) {
+            return;
+        }
+        const instance = this.instanceOf(callee.getExpression());
+        if (!instance) {
+            return;
+        }
+        const args = call.getArguments();
+        const file = call.getSourceFile().getFilePath();
+
+        if (name === 'addHook') {
+            if (REQUEST_HOOKS.includes(stringValue(args[0]) ?? '') && args[1]) {
+                instance.hooks.push(args[1]);
+            }
+            return;
+        }
+
+        if (name === 'route') {
+            // fastify.route({ method, url, handler, ...hooks })
+            const [options] = args;
+            const url = propertyValue(options, 'url') ?? propertyValue(options, 'path');
+            const path = stringValue(url);
+            const handler = propertyValue(options, 'handler');
+            if (path === undefined || !handler) {
+                return;
+            }
+            for (const method of elementsOf(propertyValue(options, 'method'))) {
+                const value = stringValue(method);
+                if (value) {
+                    instance.routes.push({
+                        method: value.toLowerCase(),
+                        path,
+                        handler,
+                        hooks: this.routeHooks(options),
+                        file
+                    });
+                }
+            }
+            return;
+        }
+
+        // fastify.get(path, [options], handler), where the handler may also
+        // be given in the options
+        const path = stringValue(args[0]);
+        const options = args.length > 2 ? args[1] : undefined;
+        const handler = options ? args[2] : (propertyValue(args[1], 'handler') ?? args[1]);
+        if (path === undefined || !handler) {
+            return;
+        }
+        const hooks = this.routeHooks(options ?? args[1]);
+        instance.routes.push({ method: name, path, handler, hooks, file });
+    }
+
+    // routeHooks returns the request hooks given in a route's options.
+    private routeHooks(options: Node | undefined): Node[] {
+        return REQUEST_HOOKS.flatMap(hook => elementsOf(propertyValue(options, hook)));
+    }
+
+    // emit adds the routes of instance registered at prefix, and of the
+    // plugins registered on it, to routes.
+    private emit(
+        instance: FastifyInstance,
+        prefix: string,
+        inherited: Node[],
+        visiting: Set<FastifyInstance>,
+        routes: ExtractedRoute[]
+    ) {
+        visiting.add(instance);
+        const hooks = [...inherited, ...instance.hooks];
+        for (const route of instance.routes) {
+            const handler = resolveFunction(route.handler);
+            const handlers = [...hooks, ...route.hooks]
+                .map(hook => resolveFunction(hook))
+                .filter((fn): fn is validFuncDeclarations => fn !== undefined);
+            if (handler) {
+                handlers.push(handler);
+            }
+            routes.push({
+                published_path: `${route.method.toUpperCase()} ${joinPaths(prefix, route.path)}`,
+                function_name: handlerName(route.handler, handler),
+                file: route.file,
+                controller: instance.label,
+                handlers
+            });
+        }
+        for (const registration of instance.registrations) {
+            if (!visiting.has(registration.instance)) {
+                const path = joinPaths(prefix, registration.prefix);
+                this.emit(registration.instance, path, hooks, visiting, routes);
+            }
+        }
+        visiting.delete(instance);
+    }
+}
+
+// extractFastifyRoutes finds the routes of a Fastify app loaded into
+// project.
+function extractFastifyRoutes(project: Project): ExtractedRoute[] {
+    return new FastifyRouteExtractor(project).extract();
+}
+
+export { FastifyRouteExtractor, extractFastifyRoutes };
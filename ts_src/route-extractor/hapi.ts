@@ -0,0 +1,339 @@
+import { CallExpression, Node, Project } from 'ts-morph';
+import { validFuncDeclarations } from 'ts_src/common/analyzer';
+import {
+    ExtractedRoute,
+    ParameterBindings,
+    elementsOf,
+    fileLabel,
+    handlerName,
+    joinPaths,
+    loadRequiredModules,
+    moduleOf,
+    projectSourceFiles,
+    propertyValue,
+    resolveExpression,
+    resolveFunction,
+    returnedValues,
+    stringValue
+} from './common';
+
+// Hapi routes are configuration objects handed to server.route(), one at a
+// time or in arrays:
+//
+//     server.route({ method: 'GET', path: '/users/{id}', handler: show,
+//         options: { pre: [{ method: loadUser, assign: 'user' }] } });
+//     await server.register({ plugin: users, routes: { prefix: '/v1' } });
+//     server.ext('onPreHandler', audit);
+//
+// A plugin's register function gets a server of its own as its first
+// parameter, whose routes take the prefix the plugin was registered with.
+// Request lifecycle extensions added with server.ext() apply to every route
+// of the root server, as in Hapi, unless added with sandbox: 'plugin'; those
+// and a route's own options.ext and options.pre count as part of the route.
+// As with Fastify's hooks, only extensions run before the handler count:
+// onPostHandler, onPreResponse and onPostResponse usually shape the
+// responses of every route alike, such as error formatting, and counting
+// them would mark every endpoint changed whenever one of them is edited.
+
+const HAPI_MODULES = ['@hapi/hapi', 'hapi'];
+
+// The extension points of the request lifecycle up to the handler
+const REQUEST_EXTENSIONS = [
+    'onRequest',
+    'onPreAuth',
+    'onCredentials',
+    'onPostAuth',
+    'onPreHandler'
+];
+
+interface HapiServer {
+    label: string;
+    root?: HapiServer;
+    routes: ServerRoute[];
+    extensions: Node[];
+    registrations: Registration[];
+}
+
+interface Registration {
+    prefix: string;
+    server: HapiServer;
+}
+
+interface ServerRoute {
+    method: string;
+    path: string;
+    handler: Node | undefined;
+    lifecycle: Node[];
+    file: string;
+}
+
+class HapiRouteExtractor {
+    // Keyed by the Hapi.server() call that created each root server; plugins'
+    // servers are bound to their first parameter
+    private readonly servers = new Map<Node, HapiServer>();
+    private readonly bindings = new ParameterBindings<HapiServer>();
+    private readonly registered = new Set<CallExpression>();
+
+    constructor(private readonly project: Project) {}
+
+    extract(): ExtractedRoute[] {
+        loadRequiredModules(this.project);
+        const calls: CallExpression[] = [];
+        for (const sourceFile of projectSourceFiles(this.project)) {
+            sourceFile.forEachDescendant(node => {
+                if (Node.isCallExpression(node)) {
+                    calls.push(node);
+                }
+            });
+        }
+
+        // Plugins can register plugins of their own, so keep binding until
+        // every server reachable from a root is known
+        for (let round = 0; round < 4; round++) {
+            this.bindings.bindCalls(calls, arg => this.serverOf(arg));
+            const added = calls.filter(call => this.visitRegister(call));
+            if (added.length === 0) {
+                break;
+            }
+        }
+        for (const call of calls) {
+            this.visitCall(call);
+        }
+
+        const routes: ExtractedRoute[] = [];
+        for (const server of this.servers.values()) {
+            this.emit(server, '', server.extensions, new Set(), routes);
+        }
+        return routes;
+    }
+
+    // isFactory reports whether node creates a server: Hapi.server(),
+    // new Hapi.Server() or server() imported from Hapi.
+    private isFactory(node: Node): boolean {
+        if (!Node.isCallExpression(node) && !Node.isNewExpression(node)) {
+            return false;
+        }
+        const callee = node.getExpression();
+        if (Node.isIdentifier(callee)) {
+            const module = moduleOf(callee);
+            return module !== undefined && HAPI_MODULES.includes(module);
+        }
+        if (!Node.isPropertyAccessExpression(callee)) {
+            return false;
+        }
+        if (callee.getName() !== 'server' && callee.getName() !== 'Server') {
+            return false;
+        }
+        const object = callee.getExpression();
+        if (!Node.isIdentifier(object)) {
+            return false;
+        }
+        const module = moduleOf(object);
+        return module !== undefined ? HAPI_MODULES.includes(module) : object.getText() === 'Hapi';
+    }
+
+    // serverOf returns the server node evaluates to, if any.
+    private serverOf(node: Node, depth = 0): HapiServer | undefined {
+        const value = resolveExpression(node);
+        if (this.isFactory(value)) {
+            let server = this.servers.get(value);
+            if (!server) {
+                const label = fileLabel(value.getSourceFile().getFilePath());
+                server = { label, routes: [], extensions: [], registrations: [] };
+                this.servers.set(value, server);
+            }
+            return server;
+        }
+        if (Node.isParameterDeclaration(value)) {
+            return this.bindings.get(value);
+        }
+        if (Node.isCallExpression(value) && depth < 3) {
+            // A builder such as createServer() returning a server
+            const factory = resolveFunction(value.getExpression());
+            for (const returned of factory ? returnedValues(factory) : []) {
+                const server = this.serverOf(returned, depth + 1);
+                if (server) {
+                    return server;
+                }
+            }
+        }
+        return undefined;
+    }
+
+    // visitRegister records the plugins registered by call on the server it
+    // is called on, reporting whether call was new.
+    private visitRegister(call: CallExpression): boolean {
+        const callee = call.getExpression();
+        if (
+            this.registered.has(call) ||
+            !Node.isPropertyAccessExpression(callee) ||
+            callee.getName() !== 'register'
+        ) {
+            return false;
+        }
+        const parent = this.serverOf(callee.getExpression());
+        if (!parent) {
+            return false;
+        }
+        this.registered.add(call);
+
+        // server.register(plugin, { routes: { prefix } }), where plugin may be
+        // { plugin, routes: { prefix } } or an array of either
+        const [plugins, options] = call.getArguments();
+        const defaultPrefix = this.routesPrefix(options);
+        for (const entry of elementsOf(plugins)) {
+            const wrapped = propertyValue(entry, 'plugin');
+            const plugin = wrapped ?? entry;
+            const prefix = (wrapped && this.routesPrefix(entry)) ?? defaultPrefix;
+            // Plugin modules export the plugin object as plugin, or are it
+            const register =
+                propertyValue(plugin, 'register') ??
+                propertyValue(propertyValue(plugin, 'plugin'), 'register');
+            const parameter = register && resolveFunction(register)?.getParameters()[0];
+            if (!parameter) {
+                continue;
+            }
+
+            let server = this.bindings.get(parameter);
+            if (!server) {
+                const label = fileLabel(parameter.getSourceFile().getFilePath());
+                const root = parent.root ?? parent;
+                server = { label, root, routes: [], extensions: [], registrations: [] };
+                this.bindings.bind(parameter, server);
+            }
+            parent.registrations.push({ prefix: prefix ?? '', server });
+        }
+        return true;
+    }
+
+    private routesPrefix(options: Node | undefined): string | undefined {
+        return stringValue(propertyValue(propertyValue(options, 'routes'), 'prefix'));
+    }
+
+    private visitCall(call: CallExpression) {
+        const callee = call.getExpression();
+        if (!Node.isPropertyAccessExpression(callee)) {
+            return;
+        }
+        const name = callee.getName();
+        if (name !== 'route' && name !== 'ext') {
+            return;
+        }
+        const server = this.serverOf(callee.getExpression());
+        if (!server) {
+            return;
+        }
+        const args = call.getArguments();
+
+        if (name === 'ext') {
+            this.visitExt(server, args);
+            return;
+        }
+        for (const config of elementsOf(args[0])) {
+            this.visitRoute(server, config);
+        }
+    }
+
+    // visitExt records extensions added with server.ext(event, method,
+    // options) or server.ext({ type, method, options }), or arrays of those.
+    private visitExt(server: HapiServer, args: Node[]) {
+        const events =
+            stringValue(args[0]) !== undefined
+                ? [{ type: args[0], method: args[1], options: args[2] }]
+                : elementsOf(args[0]).map(event => ({
+                      type: propertyValue(event, 'type'),
+                      method: propertyValue(event, 'method'),
+                      options: propertyValue(event, 'options')
+                  }));
+        for (const { type, method, options } of events) {
+            if (!REQUEST_EXTENSIONS.includes(stringValue(type) ?? '') || !method) {
+                continue;
+            }
+            const sandboxed = stringValue(propertyValue(options, 'sandbox')) === 'plugin';
+            const target = sandboxed ? server : (server.root ?? server);
+            target.extensions.push(...elementsOf(method));
+        }
+    }
+
+    private visitRoute(server: HapiServer, config: Node) {
+        const path = stringValue(propertyValue(config, 'path'));
+        if (path === undefined) {
+            return;
+        }
+        // Route options were called config before Hapi 17
+        const options = propertyValue(config, 'options') ?? propertyValue(config, 'config');
+        const handler = propertyValue(config, 'handler') ?? propertyValue(options, 'handler');
+        const lifecycle = [...this.preMethods(propertyValue(options, 'pre'))];
+        const ext = propertyValue(options, 'ext');
+        for (const event of REQUEST_EXTENSIONS) {
+            for (const entry of elementsOf(propertyValue(ext, event))) {
+                lifecycle.push(propertyValue(entry, 'method') ?? entry);
+            }
+        }
+
+        const file = config.getSourceFile().getFilePath();
+        for (const method of elementsOf(propertyValue(config, 'method'))) {
+            const value = stringValue(method);
+            if (value) {
+                const name = value === '*' ? 'all' : value.toLowerCase();
+                server.routes.push({ method: name, path, handler, lifecycle, file });
+            }
+        }
+    }
+
+    // preMethods returns the functions of options.pre, whose entries are
+    // functions, { method, assign } objects, or arrays of those run in
+    // parallel.
+    private preMethods(pre: Node | undefined, depth = 0): Node[] {
+        return elementsOf(pre).flatMap(entry => {
+            const value = resolveExpression(entry);
+            if (Node.isArrayLiteralExpression(value) && depth < 2) {
+                return this.preMethods(value, depth + 1);
+            }
+            return [propertyValue(entry, 'method') ?? entry];
+        });
+    }
+
+    // emit adds the routes of server registered at prefix, and of the
+    // plugins registered on it, to routes.
+    private emit(
+        server: HapiServer,
+        prefix: string,
+        inherited: Node[],
+        visiting: Set<HapiServer>,
+        routes: ExtractedRoute[]
+    ) {
+        visiting.add(server);
+        const extensions = server.root ? [...inherited, ...server.extensions] : inherited;
+        for (const route of server.routes) {
+            const handler = route.handler && resolveFunction(route.handler);
+            const handlers = [...extensions, ...route.lifecycle]
+                .map(node => resolveFunction(node))
+                .filter((fn): fn is validFuncDeclarations => fn !== undefined);
+            if (handler) {
+                handlers.push(handler);
+            }
+            routes.push({
+                published_path: `${route.method.toUpperCase()} ${joinPaths(prefix, route.path)}`,
+                function_name: handlerName(route.handler, handler),
+                file: route.file,
+                controller: server.label,
+                handlers
+            });
+        }
+        for (const registration of server.registrations) {
+            if (!visiting.has(registration.server)) {
+                const path = joinPaths(prefix, registration.prefix);
+                this.emit(registration.server, path, extensions, visiting, routes);
+            }
+        }
+        visiting.delete(server);
+    }
+}
+
+// extractHapiRoutes finds the routes of a Hapi server loaded into project.
+function extractHapiRoutes(project: Project): ExtractedRoute[] {
+    return new HapiRouteExtractor(project).extract();
+}
+
+export { HapiRouteExtractor, extractHapiRoutes };
@@ -0,0 +1,25 @@
+import { Project } from 'ts-morph';
+import { extractAdonisRoutes } from './adonis';
+import { ExtractedRoute } from './common';
+import { extractExpressRoutes } from './express';
+import { extractFastifyRoutes } from './fastify';
+import { extractHapiRoutes } from './hapi';
+import { extractKoaRoutes } from './koa';
+import { extractControllerFromProject } from './nestjs';
+
+// Route extractors by the framework names pit passes, the lowercase names of
+// its framework types
+const extractors: Record<string, (project: Project) => ExtractedRoute[]> = {
+    nestjs: extractControllerFromProject,
+    express: extractExpressRoutes,
+    fastify: extractFastifyRoutes,
+    hapi: extractHapiRoutes,
+    koa: extractKoaRoutes,
+    adonis: extractAdonisRoutes
+};
+
+// extractRoutes reads the routes of project as framework defines them, or
+// returns undefined if routes of framework cannot be read yet.
+export function extractRoutes(framework: string, project: Project): ExtractedRoute[] | undefined {
+    return extractors[framework]?.(project);
+}
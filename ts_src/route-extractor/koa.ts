@@ -0,0 +1,285 @@
+import { CallExpression, NewExpression, Node, Project } from 'ts-morph';
+import { validFuncDeclarations } from 'ts_src/common/analyzer';
+import {
+    ExtractedRoute,
+    ParameterBindings,
+    fileLabel,
+    handlerName,
+    joinPaths,
+    loadRequiredModules,
+    moduleOf,
+    pathUnder,
+    projectSourceFiles,
+    propertyValue,
+    resolveExpression,
+    resolveFunction,
+    returnedValues,
+    stringValue,
+    stringValues
+} from './common';
+
+// Koa has no routing of its own; routes come from @koa/router, or its
+// predecessor koa-router, and reach the app as middleware:
+//
+//     const router = new Router({ prefix: '/users' });
+//     router.get('/:id', auth, show);
+//     api.use('/v1', router.routes());
+//     app.use(api.routes());
+//
+// Every middleware function in a route's chain, whether passed to the route
+// or added with use() on a router or the app, counts as part of the route.
+// As with Express, registration order is not tracked.
+
+const METHODS = ['get', 'post', 'put', 'patch', 'delete', 'del', 'options', 'head', 'all'];
+
+// Methods that return the router they are called on, so calls can be chained
+const CHAINABLE = [...METHODS, 'use', 'prefix', 'param', 'routes', 'middleware'];
+
+const ROUTER_MODULES = ['@koa/router', 'koa-router'];
+
+interface KoaRouter {
+    label: string;
+    prefix: string;
+    routes: RouterRoute[];
+    mounts: Mount[];
+    middleware: Middleware[];
+}
+
+interface RouterRoute {
+    method: string;
+    path: string;
+    middleware: Node[];
+    file: string;
+}
+
+interface Mount {
+    prefix: string;
+    router: KoaRouter;
+}
+
+interface Middleware {
+    prefix: string;
+    handler: Node;
+}
+
+class KoaRouteExtractor {
+    // Routers and apps, keyed by the new Router() or new Koa() expression
+    // that created them
+    private readonly routers = new Map<Node, KoaRouter>();
+    private readonly bindings = new ParameterBindings<KoaRouter>();
+    private readonly mounted = new Set<KoaRouter>();
+
+    constructor(private readonly project: Project) {}
+
+    extract(): ExtractedRoute[] {
+        loadRequiredModules(this.project);
+        const calls: CallExpression[] = [];
+        for (const sourceFile of projectSourceFiles(this.project)) {
+            sourceFile.forEachDescendant(node => {
+                if (Node.isCallExpression(node)) {
+                    calls.push(node);
+                }
+            });
+        }
+
+        this.bindings.bindCalls(calls, arg => this.routerOf(arg));
+        for (const call of calls) {
+            this.visitCall(call);
+        }
+
+        const routes: ExtractedRoute[] = [];
+        for (const router of this.routers.values()) {
+            if (!this.mounted.has(router)) {
+                this.emit(router, '', [], new Set(), routes);
+            }
+        }
+        return routes;
+    }
+
+    // isFactory reports whether node creates a router or an app: new Router(),
+    // Router() and new Koa(), however they were imported.
+    private isFactory(node: Node): node is CallExpression | NewExpression {
+        if (!Node.isNewExpression(node) && !Node.isCallExpression(node)) {
+            return false;
+        }
+        const callee = node.getExpression();
+        if (!Node.isIdentifier(callee)) {
+            return false;
+        }
+        const module = moduleOf(callee);
+        if (module !== undefined) {
+            return ROUTER_MODULES.includes(module) || module === 'koa';
+        }
+        return ['Router', 'KoaRouter', 'Koa'].includes(callee.getText());
+    }
+
+    // routerOf returns the router or app node evaluates to, if any.
+    private routerOf(node: Node, depth = 0): KoaRouter | undefined {
+        const value = resolveExpression(node);
+        if (this.isFactory(value)) {
+            let router = this.routers.get(value);
+            if (!router) {
+                const [options] = value.getArguments();
+                router = {
+                    label: fileLabel(value.getSourceFile().getFilePath()),
+                    prefix: stringValue(propertyValue(options, 'prefix')) ?? '',
+                    routes: [],
+                    mounts: [],
+                    middleware: []
+                };
+                this.routers.set(value, router);
+            }
+            return router;
+        }
+        if (Node.isParameterDeclaration(value)) {
+            return this.bindings.get(value);
+        }
+        if (!Node.isCallExpression(value) || depth >= 3) {
+            return undefined;
+        }
+
+        const callee = value.getExpression();
+        if (Node.isPropertyAccessExpression(callee) && CHAINABLE.includes(callee.getName())) {
+            // router.get(...).post(...) and router.routes() are the router
+            return this.routerOf(callee.getExpression(), depth + 1);
+        }
+        // A factory such as createUsersRouter() returning a router
+        const factory = resolveFunction(callee);
+        for (const returned of factory ? returnedValues(factory) : []) {
+            const router = this.routerOf(returned, depth + 1);
+            if (router) {
+                return router;
+            }
+        }
+        return undefined;
+    }
+
+    private visitCall(call: CallExpression) {
+        const callee = call.getExpression();
+        if (!Node.isPropertyAccessExpression(callee)) {
+            return;
+        }
+        const name = callee.getName();
+        if (name !== 'use' && name !== 'prefix' && !METHODS.includes(name)) {
+            return;
+        }
+        const router = this.routerOf(callee.getExpression());
+        if (!router) {
+            return;
+        }
+        const file = call.getSourceFile().getFilePath();
+        let args = call.getArguments();
+
+        if (name === 'prefix') {
+            router.prefix = stringValue(args[0]) ?? router.prefix;
+            return;
+        }
+        if (name === 'use') {
+            this.visitUse(router, args);
+            return;
+        }
+
+        // router.get([name], path, ...middleware)
+        if (args.length > 2 && stringValue(args[1]) !== undefined) {
+            args = args.slice(1);
+        }
+        const [pathArg, ...middleware] = args;
+        if (middleware.length === 0) {
+            return;
+        }
+        const method = name === 'del' ? 'delete' : name;
+        for (const path of stringValues(pathArg)) {
+            router.routes.push({ method, path, middleware, file });
+        }
+    }
+
+    private visitUse(router: KoaRouter, args: Node[]) {
+        let prefixes = [''];
+        const paths = stringValues(args[0]);
+        if (args.length > 1 && paths.length > 0) {
+            prefixes = paths;
+            args = args.slice(1);
+        }
+
+        for (const arg of args) {
+            const mount = this.mountOf(arg);
+            for (const prefix of prefixes) {
+                if (mount && mount.router !== router) {
+                    // router.use('/x', sub.routes()), or with koa-mount
+                    // app.use(mount('/x', sub.routes()))
+                    const path = joinPaths(prefix, mount.prefix);
+                    router.mounts.push({ prefix: path, router: mount.router });
+                    this.mounted.add(mount.router);
+                } else if (!mount) {
+                    router.middleware.push({ prefix, handler: arg });
+                }
+            }
+        }
+    }
+
+    // mountOf returns the router a use() argument mounts, with the path
+    // koa-mount mounts it at, if any.
+    private mountOf(arg: Node): Mount | undefined {
+        const value = resolveExpression(arg);
+        if (Node.isCallExpression(value)) {
+            const callee = value.getExpression();
+            const [path, mounted] = value.getArguments();
+            if (Node.isIdentifier(callee) && moduleOf(callee) === 'koa-mount' && mounted) {
+                const router = this.routerOf(mounted);
+                return router && { prefix: stringValue(path) ?? '', router };
+            }
+        }
+        const router = this.routerOf(arg);
+        return router && { prefix: '', router };
+    }
+
+    // emit adds the routes of router mounted at prefix, and of the routers
+    // mounted on it, to routes.
+    private emit(
+        router: KoaRouter,
+        prefix: string,
+        inherited: Node[],
+        visiting: Set<KoaRouter>,
+        routes: ExtractedRoute[]
+    ) {
+        visiting.add(router);
+        const base = joinPaths(prefix, router.prefix);
+        const middlewareFor = (path: string) =>
+            router.middleware.filter(m => pathUnder(path, m.prefix)).map(m => m.handler);
+
+        for (const route of router.routes) {
+            const chain = [...inherited, ...middlewareFor(route.path), ...route.middleware];
+            const handlers = chain
+                .map(arg => resolveFunction(arg))
+                .filter((fn): fn is validFuncDeclarations => fn !== undefined);
+            const last = route.middleware[route.middleware.length - 1];
+            routes.push({
+                published_path: `${route.method.toUpperCase()} ${joinPaths(base, route.path)}`,
+                function_name: handlerName(last, resolveFunction(last)),
+                file: route.file,
+                controller: router.label,
+                handlers
+            });
+        }
+        for (const mount of router.mounts) {
+            if (visiting.has(mount.router)) {
+                continue;
+            }
+            this.emit(
+                mount.router,
+                joinPaths(base, mount.prefix),
+                [...inherited, ...middlewareFor(mount.prefix)],
+                visiting,
+                routes
+            );
+        }
+        visiting.delete(router);
+    }
+}
+
+// extractKoaRoutes finds the routes of a Koa app loaded into project.
+function extractKoaRoutes(project: Project): ExtractedRoute[] {
+    return new KoaRouteExtractor(project).extract();
+}
+
+export { KoaRouteExtractor, extractKoaRoutes };
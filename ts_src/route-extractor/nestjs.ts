@@ -1,7 +1,8 @@
 import { validFuncDeclarations } from 'ts_src/common/analyzer';
 import { findTargetFunctionFromFileString } from '../../ts_src/common/utils';
-import * as ts from 'typescript';
-import { Project } from 'ts-morph';
+// ts-morph's own copy of the compiler, so routes can also be read from the
+// programs of ts-morph projects kept by the daemon
+import { Project, ts } from 'ts-morph';
 
 interface RouteInfo {
     filename: string;
@@ -158,6 +159,17 @@ function extractController(file: string) {
     //     delete route.methodNode;
     //     console.log(route);
     // }
+    return toControllers(routes);
+}
+
+// extractControllerFromProject reads routes from a ts-morph project that
+// already has the entrypoint and its dependencies loaded.
+function extractControllerFromProject(project: Project) {
+    const program = project.getProgram().compilerObject;
+    return toControllers(NestRouteExtractor.extractRoutesFromProgram(program));
+}
+
+function toControllers(routes: RouteInfo[]) {
     return routes
         .map(obj => {
             return {
@@ -176,4 +188,4 @@ function extractController(file: string) {
 }
 
 // console.log(analyzeFiles(['/Users/prasshan/Desktop/Repos/core-backend/src/main.ts']))
-export { NestRouteExtractor, RouteInfo, extractController };
+export { NestRouteExtractor, RouteInfo, extractController, extractControllerFromProject };
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/binary"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// worktreeSnapshot holds everything needed to read a file from HEAD, the
+// index or the working tree.
+type worktreeSnapshot struct {
+	repo     *git.Repository
+	worktree *git.Worktree
+	headTree *object.Tree
+	index    *index.Index
+}
+
+// uncommittedComparison compares HEAD, the index and the working tree
+// according to staged and unstaged; see uncommittedPatch.
+func uncommittedComparison(r *git.Repository, staged, unstaged bool) (*comparison, error) {
+	patch, err := uncommittedPatch(r, staged, unstaged)
+	if err != nil {
+		return nil, err
+	}
+
+	cmp := &comparison{patch: patch}
+	if staged {
+		// Before the first commit everything staged is new
+		ref, err := r.Head()
+		switch {
+		case errors.Is(err, plumbing.ErrReferenceNotFound):
+			cmp.base.empty = true
+		case err != nil:
+			return nil, fmt.Errorf("error getting HEAD: %w", err)
+		default:
+			cmp.base.commit, err = r.CommitObject(ref.Hash())
+			if err != nil {
+				return nil, fmt.Errorf("error getting HEAD commit: %w", err)
+			}
+		}
+	} else {
+		cmp.base.index = true
+	}
+	if !unstaged {
+		cmp.head.index = true
+	}
+	return cmp, nil
+}
+
+// uncommittedPatch builds a patch for changes that have not been committed.
+// With staged it compares HEAD against the index, with unstaged the index
+// against the working tree, and with both HEAD against the working tree.
+// Untracked files are ignored, as with git diff, and deleted and added
+// files are paired up into renames as commit comparisons are.
+func uncommittedPatch(r *git.Repository, staged, unstaged bool) (fdiff.Patch, error) {
+	snap, err := newWorktreeSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := snap.worktree.Status()
+	if err != nil {
+		return nil, fmt.Errorf("getting worktree status: %w", err)
+	}
+
+	// Sort paths so output is stable between runs
+	paths := make([]string, 0, len(status))
+	for path := range status {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	patch := &uncommitted{}
+	var deleted, added []uncommittedFile
+	for _, path := range paths {
+		fileStatus := status[path]
+		if fileStatus.Worktree == git.Untracked {
+			continue
+		}
+		stagedChange := staged && fileStatus.Staging != git.Unmodified
+		unstagedChange := unstaged && fileStatus.Worktree != git.Unmodified
+		if !stagedChange && !unstagedChange {
+			continue
+		}
+
+		var from, to []byte
+		var fromOK, toOK bool
+		if staged {
+			from, fromOK, err = snap.headContent(path)
+		} else {
+			from, fromOK, err = snap.indexContent(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if unstaged {
+			to, toOK, err = snap.worktreeContent(path)
+		} else {
+			to, toOK, err = snap.indexContent(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if fromOK == toOK && bytes.Equal(from, to) {
+			continue
+		}
+		switch {
+		case fromOK && !toOK:
+			deleted = append(deleted, newUncommittedFile(path, from))
+		case !fromOK && toOK:
+			added = append(added, newUncommittedFile(path, to))
+		default:
+			patch.filePatches = append(patch.filePatches, newUncommittedFilePatch(path, from, fromOK, to, toOK))
+		}
+	}
+
+	renames, deleted, added := detectRenames(deleted, added)
+	for _, r := range renames {
+		patch.filePatches = append(patch.filePatches, newFilePatch(&r[0], &r[1]))
+	}
+	for i := range deleted {
+		patch.filePatches = append(patch.filePatches, newFilePatch(&deleted[i], nil))
+	}
+	for i := range added {
+		patch.filePatches = append(patch.filePatches, newFilePatch(nil, &added[i]))
+	}
+	sort.SliceStable(patch.filePatches, func(i, j int) bool {
+		return filePatchPath(patch.filePatches[i]) < filePatchPath(patch.filePatches[j])
+	})
+	return patch, nil
+}
+
+// renameScore is the similarity, in percent, at which a deleted and an
+// added file are taken to be one file moved, as with the rename detection
+// of commit comparisons.
+var renameScore = object.DefaultDiffTreeOptions.RenameScore
+
+// detectRenames pairs deleted files with the added files they were moved
+// to: identical contents first, then the most similar pairs scoring at
+// least renameScore. It returns the pairs and the files left unpaired.
+func detectRenames(deleted, added []uncommittedFile) ([][2]uncommittedFile, []uncommittedFile, []uncommittedFile) {
+	var renames [][2]uncommittedFile
+	pair := func(i, j int) {
+		renames = append(renames, [2]uncommittedFile{deleted[i], added[j]})
+		deleted = append(deleted[:i], deleted[i+1:]...)
+		added = append(added[:j], added[j+1:]...)
+	}
+
+	for i := 0; i < len(deleted); i++ {
+		for j := range added {
+			if deleted[i].hash == added[j].hash {
+				pair(i, j)
+				i--
+				break
+			}
+		}
+	}
+
+	type candidate struct {
+		from, to, score int
+	}
+	var candidates []candidate
+	for i := range deleted {
+		for j := range added {
+			if score := similarity(deleted[i].content, added[j].content); score >= int(renameScore) {
+				candidates = append(candidates, candidate{i, j, score})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].score > candidates[b].score })
+
+	usedFrom, usedTo := make(map[int]bool), make(map[int]bool)
+	var fromLeft, toLeft []uncommittedFile
+	for _, c := range candidates {
+		if !usedFrom[c.from] && !usedTo[c.to] {
+			usedFrom[c.from], usedTo[c.to] = true, true
+			renames = append(renames, [2]uncommittedFile{deleted[c.from], added[c.to]})
+		}
+	}
+	for i, f := range deleted {
+		if !usedFrom[i] {
+			fromLeft = append(fromLeft, f)
+		}
+	}
+	for j, f := range added {
+		if !usedTo[j] {
+			toLeft = append(toLeft, f)
+		}
+	}
+	return renames, fromLeft, toLeft
+}
+
+// similarity returns how much of two files' contents is unchanged between
+// them, in percent of the larger one.
+func similarity(from, to []byte) int {
+	size := max(len(from), len(to))
+	if size == 0 {
+		return 100
+	}
+	common := 0
+	for _, d := range diff.Do(string(from), string(to)) {
+		if d.Type == diffmatchpatch.DiffEqual {
+			common += len(d.Text)
+		}
+	}
+	return common * 100 / size
+}
+
+// filePatchPath is the path a file patch is sorted by: its new path, or
+// its old one for deletions.
+func filePatchPath(fp fdiff.FilePatch) string {
+	from, to := fp.Files()
+	if to != nil {
+		return to.Path()
+	}
+	return from.Path()
+}
+
+func newWorktreeSnapshot(r *git.Repository) (*worktreeSnapshot, error) {
+	wt, err := r.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("opening worktree: %w", err)
+	}
+
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+
+	snap := &worktreeSnapshot{repo: r, worktree: wt, index: idx}
+
+	// A repository without commits has nothing at HEAD to compare against
+	ref, err := r.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return snap, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting HEAD: %w", err)
+	}
+	commit, err := r.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("error getting HEAD commit: %w", err)
+	}
+	snap.headTree, err = commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("error getting HEAD tree: %w", err)
+	}
+	return snap, nil
+}
+
+// headContent returns the file as committed at HEAD. The boolean is false
+// when the file does not exist there.
+func (s *worktreeSnapshot) headContent(path string) ([]byte, bool, error) {
+	if s.headTree == nil {
+		return nil, false, nil
+	}
+	file, err := s.headTree.File(path)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("reading %s from HEAD: %w", path, err)
+	}
+	reader, err := file.Reader()
+	if err != nil {
+		return nil, false, fmt.Errorf("reading %s from HEAD: %w", path, err)
+	}
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("reading %s from HEAD: %w", path, err)
+	}
+	return contents, true, nil
+}
+
+// indexContent returns the file as staged in the index.
+func (s *worktreeSnapshot) indexContent(path string) ([]byte, bool, error) {
+	entry, err := s.index.Entry(path)
+	if errors.Is(err, index.ErrEntryNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("reading %s from index: %w", path, err)
+	}
+	blob, err := s.repo.BlobObject(entry.Hash)
+	if err != nil {
+		return nil, false, fmt.Errorf("reading %s from index: %w", path, err)
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, false, fmt.Errorf("reading %s from index: %w", path, err)
+	}
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("reading %s from index: %w", path, err)
+	}
+	return contents, true, nil
+}
+
+// worktreeContent returns the file as it currently exists on disk.
+func (s *worktreeSnapshot) worktreeContent(path string) ([]byte, bool, error) {
+	file, err := s.worktree.Filesystem.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("reading %s from worktree: %w", path, err)
+	}
+	defer file.Close()
+	contents, err := io.ReadAll(file)
+	if err != nil {
+		return nil, false, fmt.Errorf("reading %s from worktree: %w", path, err)
+	}
+	return contents, true, nil
+}
+
+func newUncommittedFile(path string, content []byte) uncommittedFile {
+	return uncommittedFile{path: path, hash: plumbing.ComputeHash(plumbing.BlobObject, content), content: content}
+}
+
+func newUncommittedFilePatch(path string, from []byte, fromOK bool, to []byte, toOK bool) *uncommittedFilePatch {
+	var fromFile, toFile *uncommittedFile
+	if fromOK {
+		f := newUncommittedFile(path, from)
+		fromFile = &f
+	}
+	if toOK {
+		f := newUncommittedFile(path, to)
+		toFile = &f
+	}
+	return newFilePatch(fromFile, toFile)
+}
+
+// newFilePatch diffs two versions of a file, either of which may be
+// missing. Their paths differ for renames.
+func newFilePatch(fromFile, toFile *uncommittedFile) *uncommittedFilePatch {
+	fp := &uncommittedFilePatch{}
+	var from, to []byte
+	if fromFile != nil {
+		fp.from, from = *fromFile, fromFile.content
+	}
+	if toFile != nil {
+		fp.to, to = *toFile, toFile.content
+	}
+
+	fromBinary, _ := binary.IsBinary(bytes.NewReader(from))
+	toBinary, _ := binary.IsBinary(bytes.NewReader(to))
+	if fromBinary || toBinary {
+		fp.binary = true
+		return fp
+	}
+
+	for _, d := range diff.Do(string(from), string(to)) {
+		var op fdiff.Operation
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			op = fdiff.Equal
+		case diffmatchpatch.DiffDelete:
+			op = fdiff.Delete
+		case diffmatchpatch.DiffInsert:
+			op = fdiff.Add
+		}
+		fp.chunks = append(fp.chunks, uncommittedChunk{content: d.Text, op: op})
+	}
+	return fp
+}
+
+// uncommitted implements fdiff.Patch for index and working tree changes so
+// they can be analyzed exactly like a patch between two commits.
+type uncommitted struct {
+	filePatches []fdiff.FilePatch
+}
+
+func (p *uncommitted) FilePatches() []fdiff.FilePatch { return p.filePatches }
+func (p *uncommitted) Message() string                { return "" }
+
+type uncommittedFilePatch struct {
+	from, to fdiff.File
+	binary   bool
+	chunks   []fdiff.Chunk
+}
+
+func (fp *uncommittedFilePatch) IsBinary() bool                  { return fp.binary }
+func (fp *uncommittedFilePatch) Files() (fdiff.File, fdiff.File) { return fp.from, fp.to }
+func (fp *uncommittedFilePatch) Chunks() []fdiff.Chunk           { return fp.chunks }
+
+type uncommittedFile struct {
+	path    string
+	hash    plumbing.Hash
+	content []byte
+}
+
+func (f uncommittedFile) Hash() plumbing.Hash     { return f.hash }
+func (f uncommittedFile) Mode() filemode.FileMode { return filemode.Regular }
+func (f uncommittedFile) Path() string            { return f.path }
+
+type uncommittedChunk struct {
+	content string
+	op      fdiff.Operation
+}
+
+func (c uncommittedChunk) Content() string       { return c.content }
+func (c uncommittedChunk) Type() fdiff.Operation { return c.op }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+}
+
+func commitAll(t *testing.T, wt *git.Worktree, msg string) {
+	t.Helper()
+	if err := wt.AddGlob("."); err != nil {
+		t.Fatalf("failed to stage files: %v", err)
+	}
+	_, err := wt.Commit(msg, &git.CommitOptions{
+		Author: &object.Signature{Name: "pit", Email: "pit@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+}
+
+func initTestRepo(t *testing.T) (string, *git.Repository, *git.Worktree) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("failed to init repo: %v", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("failed to open worktree: %v", err)
+	}
+	return dir, repo, wt
+}
+
+func patchedPaths(patch fdiff.Patch) []string {
+	var paths []string
+	for _, fp := range patch.FilePatches() {
+		from, to := fp.Files()
+		if to != nil {
+			paths = append(paths, to.Path())
+		} else {
+			paths = append(paths, from.Path())
+		}
+	}
+	return paths
+}
+
+func TestUncommittedPatch_StagedAndUnstaged(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	writeFile(t, dir, "a.ts", "one\ntwo\n")
+	writeFile(t, dir, "b.ts", "three\n")
+	commitAll(t, wt, "initial")
+
+	// a.ts is staged, b.ts is only changed on disk
+	writeFile(t, dir, "a.ts", "one\nTWO\n")
+	if _, err := wt.Add("a.ts"); err != nil {
+		t.Fatalf("failed to stage a.ts: %v", err)
+	}
+	writeFile(t, dir, "b.ts", "three\nfour\n")
+
+	tests := []struct {
+		name             string
+		staged, unstaged bool
+		want             []string
+	}{
+		{"staged", true, false, []string{"a.ts"}},
+		{"unstaged", false, true, []string{"b.ts"}},
+		{"both", true, true, []string{"a.ts", "b.ts"}},
+	}
+	for _, tt := range tests {
+		patch, err := uncommittedPatch(repo, tt.staged, tt.unstaged)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		got := patchedPaths(patch)
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: expected paths %v, got %v", tt.name, tt.want, got)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: expected paths %v, got %v", tt.name, tt.want, got)
+			}
+		}
+	}
+}
+
+func TestUncommittedPatch_Chunks(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	writeFile(t, dir, "a.ts", "one\ntwo\nthree\n")
+	commitAll(t, wt, "initial")
+	writeFile(t, dir, "a.ts", "one\n2\nthree\n")
+
+	patch, err := uncommittedPatch(repo, false, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(patch.FilePatches()) != 1 {
+		t.Fatalf("expected one file patch, got %d", len(patch.FilePatches()))
+	}
+
+	var added, deleted string
+	for _, chunk := range patch.FilePatches()[0].Chunks() {
+		switch chunk.Type() {
+		case fdiff.Add:
+			added += chunk.Content()
+		case fdiff.Delete:
+			deleted += chunk.Content()
+		}
+	}
+	if added != "2\n" || deleted != "two\n" {
+		t.Errorf("expected +%q -%q, got +%q -%q", "2\n", "two\n", added, deleted)
+	}
+}
+
+func TestUncommittedPatch_Renames(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	body := "line 1\nline 2\nline 3\nline 4\nline 5\nline 6\nline 7\nline 8\nline 9\nline 10\n"
+	writeFile(t, dir, "user.service.ts", body)
+	writeFile(t, dir, "old.ts", "unrelated\n")
+	commitAll(t, wt, "initial")
+
+	// git mv user.service.ts users.ts with one line edited, plus an
+	// unrelated deletion and addition that must stay apart
+	for _, name := range []string{"user.service.ts", "old.ts"} {
+		if _, err := wt.Remove(name); err != nil {
+			t.Fatalf("failed to remove %s: %v", name, err)
+		}
+	}
+	writeFile(t, dir, "users.ts", strings.Replace(body, "line 8\n", "line eight\n", 1))
+	writeFile(t, dir, "new.ts", "something else entirely\n")
+	for _, name := range []string{"users.ts", "new.ts"} {
+		if _, err := wt.Add(name); err != nil {
+			t.Fatalf("failed to stage %s: %v", name, err)
+		}
+	}
+
+	patch, err := uncommittedPatch(repo, true, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var files []string
+	for _, fp := range patch.FilePatches() {
+		from, to := fp.Files()
+		var fromName, toName string
+		if from != nil {
+			fromName = from.Path()
+		}
+		if to != nil {
+			toName = to.Path()
+		}
+		files = append(files, fromName+" -> "+toName)
+	}
+	want := []string{" -> new.ts", "old.ts -> ", "user.service.ts -> users.ts"}
+	if strings.Join(files, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("expected file patches %v, got %v", want, files)
+	}
+
+	// Only the function around the edited line changed
+	baseFunctions := []FunctionRange{
+		{ControllerName: "GET users", Filename: "user.service.ts", StartLine: 1, EndLine: 4},
+		{ControllerName: "DELETE users", Filename: "user.service.ts", StartLine: 7, EndLine: 9},
+	}
+	headFunctions := []FunctionRange{
+		{ControllerName: "GET users", Filename: "users.ts", StartLine: 1, EndLine: 4},
+		{ControllerName: "DELETE users", Filename: "users.ts", StartLine: 7, EndLine: 9},
+	}
+	result := findChangedFunctions(patch, baseFunctions, headFunctions)
+	if len(result.Added) != 1 || result.Added[0] != "DELETE users" {
+		t.Errorf("expected only DELETE users added, got %v", result.Added)
+	}
+	if len(result.Removed) != 1 || result.Removed[0] != "DELETE users" {
+		t.Errorf("expected only DELETE users removed, got %v", result.Removed)
+	}
+}
+
+func TestUncommittedComparison_NoCommits(t *testing.T) {
+	dir, repo, wt := initTestRepo(t)
+	writeFile(t, dir, "a.ts", "one\n")
+	if _, err := wt.Add("a.ts"); err != nil {
+		t.Fatalf("failed to stage a.ts: %v", err)
+	}
+
+	cmp, err := uncommittedComparison(repo, true, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cmp.base.empty || !cmp.head.index {
+		t.Errorf("expected an empty base and the index as head, got %+v and %+v", cmp.base, cmp.head)
+	}
+	if paths := patchedPaths(cmp.patch); len(paths) != 1 || paths[0] != "a.ts" {
+		t.Errorf("expected a.ts added, got %v", paths)
+	}
+}